@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+// collageFlags holds the flags of the collage command besides the render
+// flags shared by every card.
+type collageFlags struct {
+	columns    *int
+	gap        *int
+	background *string
+}
+
+func runCollage(args []string) int {
+	fs, _, collage := newCollageFlags(flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "複数の投稿(spec/bsky/embedファイル)をまとめて1枚のコラージュ(PNG/SVG)にします\n\n")
+		fmt.Fprintf(os.Stderr, "使い方: xpostgen collage [flags] files...\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *collage.columns < 1 || *collage.gap < 0 {
+		fmt.Fprintln(os.Stderr, "-columns must be at least 1 and -gap must not be negative")
+		return 2
+	}
+
+	// Every file is resolved with a fresh flag set so values from one spec
+	// do not leak into the next. The card options and outputs come from
+	// the first.
+	flagArgs := args[:len(args)-fs.NArg()]
+	var posts []render.TweetData
+	var first renderConfig
+	for i, path := range fs.Args() {
+		postFS, postFlags, _ := newCollageFlags(flag.ContinueOnError)
+		postFS.SetOutput(io.Discard)
+		_ = postFS.Parse(flagArgs)
+		if err := postFS.Set("input", path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		cfg, err := postFlags.config()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 2
+		}
+		if i == 0 {
+			first = cfg
+			for _, target := range cfg.Targets {
+				if target.Format != "png" && target.Format != "svg" {
+					fmt.Fprintln(os.Stderr, "collage output must be png or svg")
+					return 2
+				}
+			}
+		}
+		posts = append(posts, cfg.Data)
+	}
+
+	options := render.CollageOptions{Columns: *collage.columns, Gap: *collage.gap, Background: *collage.background}
+	for _, target := range first.Targets {
+		out, err := renderCollage(posts, first.Opts, options, target.Format)
+		if err == nil && target.Output == "-" {
+			_, err = os.Stdout.Write(out)
+		} else if err == nil {
+			err = writeFile(target.Output, out)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func renderCollage(posts []render.TweetData, opts render.RenderOptions, options render.CollageOptions, format string) ([]byte, error) {
+	if format == "svg" {
+		svg, err := render.RenderCollageSVG(posts, opts, options)
+		return []byte(svg), err
+	}
+	img, err := render.RenderCollage(posts, opts, options)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func newCollageFlags(handling flag.ErrorHandling) (*flag.FlagSet, *renderFlags, *collageFlags) {
+	fs := flag.NewFlagSet("xpostgen collage", handling)
+	flags := newRenderFlags(fs)
+	output := fs.Lookup("output")
+	_ = output.Value.Set("collage.png")
+	output.DefValue = "collage.png"
+	def := render.DefaultCollageOptions()
+	collage := &collageFlags{
+		columns:    fs.Int("columns", def.Columns, "列数"),
+		gap:        fs.Int("gap", def.Gap, "カードの間隔と外側の余白(px、0で余白なし)"),
+		background: fs.String("bg", "", "背景色 (#RRGGBB またはtransparent、省略時はテーマの区切り線の色)"),
+	}
+	return fs, flags, collage
+}
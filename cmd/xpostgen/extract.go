@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+func runExtract(args []string) int {
+	fs := flag.NewFlagSet("xpostgen extract", flag.ExitOnError)
+	output := fs.String("output", "-", "specの出力パス(-で標準出力)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "PNG/SVG/HTML出力に埋め込まれたspec(TweetData+RenderOptions)をJSONで取り出します\n\n")
+		fmt.Fprintf(os.Stderr, "使い方: xpostgen extract [flags] file\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	spec, err := readSpecFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	out, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	out = append(out, '\n')
+	if *output == "-" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = os.WriteFile(*output, out, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// readSpecFile reads the spec embedded in a rendered file, or a spec JSON
+// written by the extract command.
+func readSpecFile(path string) (render.Spec, error) {
+	content, err := readInput(path)
+	if err != nil {
+		return render.Spec{}, err
+	}
+	return render.ExtractSpec(content)
+}
+
+// checkRemoteSpec refuses URLs in a spec restored with -from unless the
+// flag that sets them is given again: an image from elsewhere should not
+// make the CLI fetch anything on its own.
+func checkRemoteSpec(spec render.Spec, explicit map[string]bool) error {
+	for _, field := range []struct{ flag, value string }{
+		{"icon", spec.Data.Icon},
+		{"font", spec.Options.FontPath},
+		{"font-bold", spec.Options.BoldFontPath},
+		{"canvas-bg", spec.Options.Canvas.Background},
+	} {
+		if strings.Contains(field.value, "://") && !explicit[field.flag] {
+			return fmt.Errorf("spec loads %s from %s; pass -%s to confirm or replace it", field.flag, field.value, field.flag)
+		}
+	}
+	return nil
+}
+
+// fromIgnored lists render flags that choose the input or the outputs and
+// so leave a spec restored with -from alone.
+var fromIgnored = map[string]bool{
+	"output":       true,
+	"format":       true,
+	"formats":      true,
+	"outdir":       true,
+	"from":         true,
+	"input":        true,
+	"input-format": true,
+	"bsky-profile": true,
+}
+
+// mergeFrom overrides a spec restored with -from by the flags given
+// explicitly on the command line. Flag defaults are ignored, so the spec
+// renders as before unless a flag changes it. A render flag without an
+// override here is an error rather than silently ignored.
+func (f *renderFlags) mergeFrom(spec render.Spec, flags render.TweetData, opts render.RenderOptions, explicit map[string]bool) (render.TweetData, render.RenderOptions, error) {
+	data, out := spec.Data, spec.Options
+	overrides := map[string]func(){
+		"text": func() { data.Text, data.Entities = flags.Text, nil },
+		"icon": func() { data.Icon = flags.Icon },
+		"name": func() { data.Name = flags.Name },
+		"id":   func() { data.Handle = flags.Handle },
+		"date": func() {
+			data.Date = flags.Date
+			if !explicit["time"] {
+				data.Time = time.Time{}
+			}
+		},
+		"time":     func() { data.Time = flags.Time },
+		"lang":     func() { data.Lang = flags.Lang },
+		"location": func() { data.Location = flags.Location },
+		"cta":      func() { data.CTA = flags.CTA },
+		"no-cta": func() {
+			if *f.noCTA {
+				data.CTA = ""
+			}
+		},
+		"verified":    func() { data.Verified = flags.Verified },
+		"simple":      func() { data.Simple = flags.Simple },
+		"like-count":  func() { data.LikeCount = flags.LikeCount },
+		"permalink":   func() { data.Permalink = flags.Permalink },
+		"profile-url": func() { data.ProfileURL = flags.ProfileURL },
+		"cta-url":     func() { data.CTAURL = flags.CTAURL },
+
+		"tz":                func() { out.TimeZone = opts.TimeZone },
+		"date-style":        func() { out.DateStyle = opts.DateStyle },
+		"width":             func() { out.Width = opts.Width },
+		"width-mode":        func() { out.WidthMode = opts.WidthMode },
+		"padding":           func() { out.Padding = opts.Padding },
+		"theme":             func() { out.Theme = opts.Theme },
+		"font":              func() { out.FontPath = opts.FontPath },
+		"font-bold":         func() { out.BoldFontPath = opts.BoldFontPath },
+		"font-family":       func() { out.FontFamily = opts.FontFamily },
+		"scale":             func() { out.Scale = opts.Scale },
+		"transparent":       func() { out.Transparent = opts.Transparent },
+		"no-border":         func() { out.NoBorder = opts.NoBorder },
+		"corner-radius":     func() { out.CornerRadius = opts.CornerRadius },
+		"canvas":            func() { out.Canvas.Width, out.Canvas.Height = opts.Canvas.Width, opts.Canvas.Height },
+		"canvas-bg":         func() { out.Canvas.Background = opts.Canvas.Background },
+		"canvas-angle":      func() { out.Canvas.Angle = opts.Canvas.Angle },
+		"canvas-margin":     func() { out.Canvas.Margin = opts.Canvas.Margin },
+		"canvas-no-shadow":  func() { out.Canvas.NoShadow = opts.Canvas.NoShadow },
+		"svg-text":          func() { out.SVGText = opts.SVGText },
+		"html-mode":         func() { out.HTMLMode = opts.HTMLMode },
+		"html-class-prefix": func() { out.HTMLClassPrefix = opts.HTMLClassPrefix },
+		// fs.Visit goes in lexical order, so -animate comes before the
+		// timing flags.
+		"animate": func() { out.Animation = opts.Animation },
+		"animate-once": func() {
+			if out.Animation.Enabled() {
+				out.Animation.Once = *f.animateOnce
+			}
+		},
+		"duration": func() {
+			if out.Animation.Enabled() {
+				out.Animation.Duration = *f.duration
+			}
+		},
+		"fps": func() {
+			if out.Animation.Enabled() {
+				out.Animation.FPS = *f.fps
+			}
+		},
+		"webp-near-lossless": func() { out.WebP.NearLossless = opts.WebP.NearLossless },
+		"webp-lossy":         func() { out.WebP.Lossy = opts.WebP.Lossy },
+		"webp-quality":       func() { out.WebP.Quality = opts.WebP.Quality },
+		"jpeg-quality":       func() { out.JPEG.Quality = opts.JPEG.Quality },
+		"jpeg-chroma":        func() { out.JPEG.Chroma = opts.JPEG.Chroma },
+		"gif-dither":         func() { out.GIF.Dither = opts.GIF.Dither },
+		"no-metadata":        func() { out.EmbedSpec = opts.EmbedSpec },
+	}
+
+	var err error
+	f.fs.Visit(func(fl *flag.Flag) {
+		if override, ok := overrides[fl.Name]; ok {
+			override()
+		} else if f.names[fl.Name] && !fromIgnored[fl.Name] && err == nil {
+			err = fmt.Errorf("-%s cannot be combined with -from", fl.Name)
+		}
+	})
+	return data, out, err
+}
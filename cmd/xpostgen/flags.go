@@ -0,0 +1,502 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+const defaultCTA = "Explore what's happening on Twitter"
+
+// renderFlags holds the flags shared by every command that renders a card.
+type renderFlags struct {
+	fs           *flag.FlagSet
+	names        map[string]bool
+	text         *string
+	icon         *string
+	name         *string
+	handle       *string
+	date         *string
+	timestamp    *string
+	timeZone     *string
+	lang         *string
+	dateStyle    *string
+	location     *string
+	cta          *string
+	noCTA        *bool
+	verified     *bool
+	simple       *bool
+	likeCount    *string
+	permalink    *string
+	profileURL   *string
+	ctaURL       *string
+	output       *string
+	format       *string
+	formats      *string
+	outDir       *string
+	width        *int
+	widthMode    *string
+	padding      *int
+	theme        *string
+	fontPath     *string
+	fontBoldPath *string
+	fontFamily   *string
+	scale        *float64
+	transparent  *bool
+	noBorder     *bool
+	cornerRadius *string
+	svgText      *string
+	htmlMode     *string
+	htmlPrefix   *string
+	noMetadata   *bool
+	animate      *bool
+	animateOnce  *bool
+	fps          *int
+	duration     *time.Duration
+	nearLossless *bool
+	webpLossy    *bool
+	canvas       *string
+	canvasBg     *string
+	canvasAngle  *float64
+	canvasMargin *int
+	noShadow     *bool
+	webpQuality  *int
+	jpegQuality  *int
+	jpegChroma   *string
+	gifDither    *bool
+	input        *string
+	inputFormat  *string
+	bskyProfile  *string
+	from         *string
+}
+
+// renderConfig is the resolved input for a single render. Format and
+// Output are those of the first target.
+type renderConfig struct {
+	Data    render.TweetData
+	Opts    render.RenderOptions
+	Format  string
+	Output  string
+	Targets []renderTarget
+}
+
+// renderTarget is one output file and its format.
+type renderTarget struct {
+	Output string
+	Format string
+}
+
+func newRenderFlags(fs *flag.FlagSet) *renderFlags {
+	opts := render.DefaultOptions()
+	defined := map[string]bool{}
+	fs.VisitAll(func(fl *flag.Flag) { defined[fl.Name] = true })
+	f := &renderFlags{
+		fs:           fs,
+		text:         fs.String("text", "", "ツイート本文"),
+		icon:         fs.String("icon", "", "アイコン画像パスまたはURL"),
+		name:         fs.String("name", "", "表示名"),
+		handle:       fs.String("id", "", "ユーザーID (@なし可)"),
+		date:         fs.String("date", "", "日付(任意、-time指定時は無視)"),
+		timestamp:    fs.String("time", "", "投稿日時: RFC3339またはUnix秒"),
+		timeZone:     fs.String("tz", "", "-timeの表示タイムゾーン (例: Asia/Tokyo)"),
+		lang:         fs.String("lang", "", "投稿の言語 (例: en, ja)。日付の表記に使用"),
+		dateStyle:    fs.String("date-style", opts.DateStyle, "日付表記: absolute|relative"),
+		location:     fs.String("location", "", "現在地(任意)"),
+		cta:          fs.String("cta", defaultCTA, "CTAボタン文言(空で非表示)"),
+		noCTA:        fs.Bool("no-cta", false, "CTAを非表示にする"),
+		verified:     fs.Bool("verified", false, "認証バッジを表示する"),
+		simple:       fs.Bool("simple", false, "Simpleモード(フッター非表示)"),
+		likeCount:    fs.String("like-count", "0", "Like件数表示"),
+		permalink:    fs.String("permalink", "", "投稿のURL (SVG/HTMLで日付とロゴをリンクにする)"),
+		profileURL:   fs.String("profile-url", "", "プロフィールのURL (SVG/HTMLでアイコンと名前をリンクにする)"),
+		ctaURL:       fs.String("cta-url", "", "CTAボタンのリンク先 (省略時は-permalink)"),
+		output:       fs.String("output", "tweet.png", "出力ファイルパス。カンマ区切りで複数指定すると1回の描画で書き出す (例: card.png,card.svg)"),
+		format:       fs.String("format", "", "出力形式: png|jpg|jpeg|gif|webp|apng|svg|pdf|html|tsx|layout (省略時は拡張子から推定)"),
+		formats:      fs.String("formats", "", "カンマ区切りの出力形式 (例: png,svg,html)。ファイル名は-outputの拡張子を除いた部分から作る"),
+		outDir:       fs.String("outdir", "", "出力先ディレクトリ"),
+		width:        fs.Int("width", opts.Width, "出力幅(px)"),
+		widthMode:    fs.String("width-mode", opts.WidthMode, "横幅モード: fixed|tight"),
+		padding:      fs.Int("padding", opts.Padding, "余白(px)"),
+		theme:        fs.String("theme", "light", "テーマ: light|dark"),
+		fontPath:     fs.String("font", "", "本文フォントのパス(.ttf/.otf)"),
+		fontBoldPath: fs.String("font-bold", "", "太字フォントのパス(.ttf/.otf)"),
+		fontFamily:   fs.String("font-family", opts.FontFamily, "HTML/SVG用のfont-family"),
+		scale:        fs.Float64("scale", opts.Scale, "画像出力の倍率 (例: 2, 3)。レイアウトは等倍のまま解像度を上げる"),
+		transparent:  fs.Bool("transparent", false, "カードの外側を透過にする(PNG/WebP/SVG/HTML/PDF)"),
+		noBorder:     fs.Bool("no-border", false, "カードの枠線を描かない"),
+		cornerRadius: fs.String("corner-radius", "auto", "カードの角丸半径(px)。autoで自動、0で角丸なし"),
+		canvas:       fs.String("canvas", "", "背景キャンバスのサイズ: og|square|story|幅x高さ (例: 1600x900)"),
+		canvasBg:     fs.String("canvas-bg", "", "キャンバス背景: 色(#RRGGBB)、カンマ区切りでグラデーション、transparent、または画像パス/URL"),
+		canvasAngle:  fs.Float64("canvas-angle", opts.Canvas.Angle, "グラデーションの角度(度、CSSのlinear-gradientと同じ)"),
+		canvasMargin: fs.Int("canvas-margin", 0, "カードとキャンバス端の最小余白(px)。0で短辺の8%"),
+		noShadow:     fs.Bool("canvas-no-shadow", false, "キャンバス上のカードに影を付けない"),
+		svgText:      fs.String("svg-text", "text", "SVGの文字描画: text|paths (pathsはフォントをアウトライン化)"),
+		htmlMode:     fs.String("html-mode", "page", "HTML出力の形式: page|fragment|component"),
+		htmlPrefix:   fs.String("html-class-prefix", "xpost-", "-html-mode fragment のクラス名の接頭辞"),
+		noMetadata:   fs.Bool("no-metadata", false, "PNG/SVG/HTMLに入力(spec)を埋め込まない"),
+		animate:      fs.Bool("animate", false, "本文がタイプされるアニメーションを出力する(GIF/APNG/SVG)"),
+		animateOnce:  fs.Bool("animate-once", false, "アニメーションをループせず1回だけ再生する"),
+		fps:          fs.Int("fps", 15, "アニメーションのフレームレート(1-50)"),
+		duration:     fs.Duration("duration", 3*time.Second, "アニメーションの長さ (例: 3s, 4.5s)"),
+		nearLossless: fs.Bool("webp-near-lossless", false, "WebPをニアロスレスで圧縮する(輪郭の色を量子化してから可逆圧縮)"),
+		webpLossy:    fs.Bool("webp-lossy", false, "WebPを非可逆(VP8)で圧縮する(透過部分は可逆で保持)"),
+		webpQuality:  fs.Int("webp-quality", opts.WebP.Quality, "-webp-lossy / -webp-near-lossless時の品質(1-100)"),
+		jpegQuality:  fs.Int("jpeg-quality", opts.JPEG.Quality, "JPEGの品質(1-100)"),
+		jpegChroma:   fs.String("jpeg-chroma", "420", "JPEGの色差サンプリング: 420|444 (444は色付き文字がにじまない)"),
+		gifDither:    fs.Bool("gif-dither", false, "GIFをFloyd–Steinbergディザリングで減色する"),
+		input:        fs.String("input", "", "入力ファイルパス(-で標準入力)"),
+		inputFormat:  fs.String("input-format", "", "入力形式: spec|bsky|embed (省略時は拡張子から推定)"),
+		bskyProfile:  fs.String("bsky-profile", "", "Blueskyのプロフィールビュー(JSON)のパス"),
+		from:         fs.String("from", "", "specを埋め込んだ出力(PNG/SVG/HTML)またはextractしたJSONから再描画する。明示したフラグで上書き"),
+	}
+	// names are the render flags, without those the command defined before.
+	f.names = map[string]bool{}
+	fs.VisitAll(func(fl *flag.Flag) {
+		if !defined[fl.Name] {
+			f.names[fl.Name] = true
+		}
+	})
+	return f
+}
+
+// explicit returns the names of the flags given on the command line.
+func (f *renderFlags) explicit() map[string]bool {
+	set := map[string]bool{}
+	f.fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+	return set
+}
+
+// config resolves the parsed flags, and the -input file if any, into a
+// render configuration.
+func (f *renderFlags) config() (renderConfig, error) {
+	explicit := f.explicit()
+
+	var from *render.Spec
+	if *f.from != "" {
+		if *f.input != "" {
+			return renderConfig{}, fmt.Errorf("-from cannot be combined with -input")
+		}
+		spec, err := readSpecFile(*f.from)
+		if err != nil {
+			return renderConfig{}, fmt.Errorf("%s: %w", *f.from, err)
+		}
+		// Embedded specs name local files without their directory; they
+		// are looked up next to the file the spec came from.
+		resolveSpecFiles(&spec.Data, &spec.Options, filepath.Dir(*f.from))
+		if err := checkRemoteSpec(spec, explicit); err != nil {
+			return renderConfig{}, fmt.Errorf("%s: %w", *f.from, err)
+		}
+		from = &spec
+	}
+
+	var imported *render.TweetData
+	if *f.input != "" {
+		format := inferInputFormat(*f.input, *f.inputFormat)
+		data, err := readInput(*f.input)
+		if err != nil {
+			return renderConfig{}, err
+		}
+		if format == "spec" {
+			if err := applySpec(f.fs, data, explicit); err != nil {
+				return renderConfig{}, err
+			}
+		} else {
+			parsed, err := importInput(data, format, *f.bskyProfile)
+			if err != nil {
+				return renderConfig{}, err
+			}
+			imported = &parsed
+		}
+	}
+
+	selectedTheme, err := parseTheme(*f.theme)
+	if err != nil {
+		return renderConfig{}, err
+	}
+
+	targets, err := f.targets()
+	if err != nil {
+		return renderConfig{}, err
+	}
+	format := targets[0].Format
+
+	postTime, err := render.ParseTimestamp(*f.timestamp)
+	if err != nil {
+		return renderConfig{}, err
+	}
+	switch *f.dateStyle {
+	case "absolute", "relative":
+	default:
+		return renderConfig{}, fmt.Errorf("unknown date style: %s", *f.dateStyle)
+	}
+	if *f.scale <= 0 || *f.scale > 8 {
+		return renderConfig{}, fmt.Errorf("scale must be greater than 0 and at most 8: %g", *f.scale)
+	}
+	cornerRadius, err := parseCornerRadius(*f.cornerRadius)
+	if err != nil {
+		return renderConfig{}, err
+	}
+	canvas := render.CanvasOptions{
+		Background: *f.canvasBg,
+		Angle:      *f.canvasAngle,
+		Margin:     *f.canvasMargin,
+		NoShadow:   *f.noShadow,
+	}
+	if *f.canvas != "" {
+		canvas.Width, canvas.Height, err = render.ParseCanvasSize(*f.canvas)
+		if err != nil {
+			return renderConfig{}, err
+		}
+	}
+	if *f.canvasMargin < 0 {
+		return renderConfig{}, fmt.Errorf("canvas margin must not be negative: %d", *f.canvasMargin)
+	}
+	animate := *f.animate
+	if !animate {
+		// APNG output animates with -fps and -duration. Mixed with other
+		// formats it gets the default timing instead.
+		animate = true
+		for _, target := range targets {
+			animate = animate && target.Format == "apng"
+		}
+	}
+	if animate {
+		if *f.fps < 1 || *f.fps > 50 {
+			return renderConfig{}, fmt.Errorf("fps must be between 1 and 50: %d", *f.fps)
+		}
+		if *f.duration <= 0 || *f.duration > time.Minute {
+			return renderConfig{}, fmt.Errorf("duration must be greater than 0 and at most 1m: %s", *f.duration)
+		}
+	}
+	switch *f.svgText {
+	case "text", "paths":
+	default:
+		return renderConfig{}, fmt.Errorf("unknown svg text mode: %s", *f.svgText)
+	}
+	switch *f.htmlMode {
+	case "page", "fragment", "component":
+	default:
+		return renderConfig{}, fmt.Errorf("unknown html mode: %s", *f.htmlMode)
+	}
+	if *f.webpQuality < 1 || *f.webpQuality > 100 {
+		return renderConfig{}, fmt.Errorf("webp quality must be between 1 and 100: %d", *f.webpQuality)
+	}
+	if *f.jpegQuality < 1 || *f.jpegQuality > 100 {
+		return renderConfig{}, fmt.Errorf("jpeg quality must be between 1 and 100: %d", *f.jpegQuality)
+	}
+	switch *f.jpegChroma {
+	case "420", "444":
+	default:
+		return renderConfig{}, fmt.Errorf("unknown jpeg chroma subsampling: %s", *f.jpegChroma)
+	}
+	if *f.timeZone != "" {
+		if _, err := time.LoadLocation(*f.timeZone); err != nil {
+			return renderConfig{}, fmt.Errorf("unknown time zone: %s", *f.timeZone)
+		}
+	}
+
+	data := render.TweetData{
+		Text:       *f.text,
+		Icon:       *f.icon,
+		Name:       *f.name,
+		Handle:     *f.handle,
+		Date:       *f.date,
+		Time:       postTime,
+		Lang:       *f.lang,
+		Location:   *f.location,
+		CTA:        *f.cta,
+		Verified:   *f.verified,
+		Simple:     *f.simple,
+		LikeCount:  *f.likeCount,
+		Permalink:  *f.permalink,
+		ProfileURL: *f.profileURL,
+		CTAURL:     *f.ctaURL,
+	}
+	if imported != nil {
+		data = mergeInput(*imported, data, explicit)
+	}
+	// Only -no-cta=true hides the CTA; false keeps the imported one.
+	if *f.noCTA {
+		data.CTA = ""
+	}
+
+	opts := render.DefaultOptions()
+	opts.Width = *f.width
+	opts.WidthMode = *f.widthMode
+	opts.Padding = *f.padding
+	opts.FontPath = *f.fontPath
+	opts.BoldFontPath = *f.fontBoldPath
+	opts.FontFamily = *f.fontFamily
+	opts.TimeZone = *f.timeZone
+	opts.DateStyle = *f.dateStyle
+	opts.Theme = selectedTheme
+	opts.Scale = *f.scale
+	opts.Transparent = *f.transparent
+	opts.NoBorder = *f.noBorder
+	opts.CornerRadius = cornerRadius
+	opts.Canvas = canvas
+	opts.SVGText = *f.svgText
+	opts.HTMLMode = *f.htmlMode
+	opts.HTMLClassPrefix = *f.htmlPrefix
+	if animate {
+		opts.Animation = render.AnimationOptions{FPS: *f.fps, Duration: *f.duration, Once: *f.animateOnce}
+	}
+	opts.WebP = render.WebPOptions{NearLossless: *f.nearLossless, Lossy: *f.webpLossy, Quality: *f.webpQuality}
+	opts.JPEG = render.JPEGOptions{Quality: *f.jpegQuality, Chroma: *f.jpegChroma}
+	opts.GIF = render.GIFOptions{Dither: *f.gifDither}
+	opts.EmbedSpec = !*f.noMetadata
+	if from != nil {
+		data, opts, err = f.mergeFrom(*from, data, opts, explicit)
+		if err != nil {
+			return renderConfig{}, err
+		}
+	}
+
+	if strings.TrimSpace(data.Text) == "" || strings.TrimSpace(data.Name) == "" || strings.TrimSpace(data.Handle) == "" {
+		return renderConfig{}, errMissingRequired
+	}
+	for _, target := range targets {
+		if err := checkFormat(target.Format, opts); err != nil {
+			return renderConfig{}, err
+		}
+	}
+
+	return renderConfig{
+		Data:    data,
+		Opts:    opts,
+		Format:  format,
+		Output:  targets[0].Output,
+		Targets: targets,
+	}, nil
+}
+
+// targets resolves -output, -format, -formats and -outdir into the output
+// files. A single output keeps the -format flag and defaults to PNG;
+// several outputs take their formats from the file extensions.
+func (f *renderFlags) targets() ([]renderTarget, error) {
+	var outputs []string
+	for _, output := range strings.Split(*f.output, ",") {
+		if output = strings.TrimSpace(output); output != "" {
+			outputs = append(outputs, output)
+		}
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("-output must not be empty")
+	}
+	format := strings.ToLower(strings.TrimSpace(*f.format))
+	if *f.formats != "" {
+		if format != "" {
+			return nil, fmt.Errorf("-format cannot be combined with -formats")
+		}
+		if len(outputs) > 1 {
+			return nil, fmt.Errorf("-formats takes a single -output to name the files")
+		}
+		stem := strings.TrimSuffix(outputs[0], filepath.Ext(outputs[0]))
+		outputs = nil
+		for _, name := range strings.Split(*f.formats, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				outputs = append(outputs, stem+"."+formatExt(name))
+			}
+		}
+		if len(outputs) == 0 {
+			return nil, fmt.Errorf("-formats must list at least one format")
+		}
+	} else if format != "" && len(outputs) > 1 {
+		return nil, fmt.Errorf("-format cannot be combined with several outputs")
+	}
+
+	targets := make([]renderTarget, len(outputs))
+	for i, output := range outputs {
+		if len(outputs) > 1 && output == "-" {
+			return nil, fmt.Errorf("several outputs cannot include -")
+		}
+		if *f.outDir != "" && output != "-" {
+			output = filepath.Join(*f.outDir, output)
+		}
+		target := renderTarget{Output: output, Format: format}
+		if target.Format == "" {
+			target.Format = inferFormat(output)
+		}
+		if target.Format == "" {
+			if len(outputs) > 1 {
+				return nil, fmt.Errorf("cannot infer the format of %s", output)
+			}
+			target.Format = "png"
+		}
+		if target.Format == "jpg" {
+			target.Format = "jpeg"
+		}
+		targets[i] = target
+	}
+	return targets, nil
+}
+
+// checkFormat reports options the format cannot render.
+func checkFormat(format string, opts render.RenderOptions) error {
+	if opts.Transparent && (format == "jpeg" || format == "gif") {
+		return fmt.Errorf("%s output does not support -transparent", format)
+	}
+	if opts.Canvas.Enabled() && format == "pdf" {
+		return fmt.Errorf("pdf output does not support -canvas")
+	}
+	if opts.Animation.Enabled() {
+		switch format {
+		case "gif", "png", "apng", "svg":
+		default:
+			return fmt.Errorf("%s output does not support -animate (use gif, apng or svg)", format)
+		}
+		if opts.Canvas.Enabled() && format != "svg" {
+			return fmt.Errorf("-animate cannot be combined with -canvas")
+		}
+	}
+	return nil
+}
+
+// formatExt is the file extension written for a format by -formats.
+func formatExt(format string) string {
+	switch format {
+	case "jpeg":
+		return "jpg"
+	case "layout":
+		return "json"
+	default:
+		return format
+	}
+}
+
+var errMissingRequired = fmt.Errorf("-text, -name and -id are required")
+
+// parseCornerRadius maps the -corner-radius flag to RenderOptions, where 0
+// means automatic and a negative radius means square corners.
+func parseCornerRadius(value string) (float64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "auto" {
+		return 0, nil
+	}
+	radius, err := strconv.ParseFloat(value, 64)
+	if err != nil || radius < 0 {
+		return 0, fmt.Errorf("corner radius must be auto or a non-negative number: %s", value)
+	}
+	if radius == 0 {
+		return -1, nil
+	}
+	return radius, nil
+}
+
+func parseTheme(value string) (render.Theme, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "light":
+		return render.LightTheme(), nil
+	case "dark":
+		return render.DarkTheme(), nil
+	default:
+		return render.Theme{}, fmt.Errorf("unknown theme: %s", value)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/importer"
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+func importInput(data []byte, format string, profilePath string) (render.TweetData, error) {
+	switch format {
+	case "bsky", "bluesky":
+		var profile []byte
+		if profilePath != "" {
+			var err error
+			profile, err = os.ReadFile(profilePath)
+			if err != nil {
+				return render.TweetData{}, err
+			}
+		}
+		return importer.ParseBluesky(data, profile)
+	case "embed":
+		return importer.ParseEmbed(data)
+	case "":
+		return render.TweetData{}, fmt.Errorf("-input-format is required for this input")
+	default:
+		return render.TweetData{}, fmt.Errorf("unsupported input format: %s", format)
+	}
+}
+
+func inferInputFormat(path string, format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return "spec"
+	case ".html", ".htm":
+		return "embed"
+	default:
+		return ""
+	}
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// mergeInput fills imported data with flag values. Flags given explicitly
+// always win; flag defaults only fill fields the input left empty.
+func mergeInput(imported render.TweetData, flags render.TweetData, explicit map[string]bool) render.TweetData {
+	pick := func(name string, importedValue string, flagValue string) string {
+		if explicit[name] || importedValue == "" {
+			return flagValue
+		}
+		return importedValue
+	}
+
+	out := imported
+	out.Text = pick("text", imported.Text, flags.Text)
+	out.Icon = pick("icon", imported.Icon, flags.Icon)
+	out.Name = pick("name", imported.Name, flags.Name)
+	out.Handle = pick("id", imported.Handle, flags.Handle)
+	out.Date = pick("date", imported.Date, flags.Date)
+	out.Location = pick("location", imported.Location, flags.Location)
+	out.CTA = pick("cta", imported.CTA, flags.CTA)
+	out.LikeCount = pick("like-count", imported.LikeCount, flags.LikeCount)
+	out.Permalink = pick("permalink", imported.Permalink, flags.Permalink)
+	out.ProfileURL = pick("profile-url", imported.ProfileURL, flags.ProfileURL)
+	out.CTAURL = pick("cta-url", imported.CTAURL, flags.CTAURL)
+	if explicit["time"] || imported.Time.IsZero() {
+		out.Time = flags.Time
+	}
+	out.Lang = pick("lang", imported.Lang, flags.Lang)
+	if explicit["verified"] {
+		out.Verified = flags.Verified
+	}
+	if explicit["simple"] {
+		out.Simple = flags.Simple
+	}
+	if explicit["text"] {
+		out.Entities = nil
+	}
+	return out
+}
@@ -1,107 +1,235 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/ackkerman/x-post-preview-generator/internal/render"
+	"github.com/ackkerman/x-post-preview-generator/internal/termimg"
 )
 
 func main() {
-	opts := render.DefaultOptions()
-
-	text := flag.String("text", "", "ツイート本文")
-	icon := flag.String("icon", "", "アイコン画像パスまたはURL")
-	name := flag.String("name", "", "表示名")
-	handle := flag.String("id", "", "ユーザーID (@なし可)")
-	date := flag.String("date", "", "日付(任意)")
-	location := flag.String("location", "", "現在地(任意)")
-	cta := flag.String("cta", "Explore what's happening on Twitter", "CTAボタン文言(空で非表示)")
-	noCTA := flag.Bool("no-cta", false, "CTAを非表示にする")
-	verified := flag.Bool("verified", false, "認証バッジを表示する")
-	simple := flag.Bool("simple", false, "Simpleモード(フッター非表示)")
-	likeCount := flag.String("like-count", "0", "Like件数表示")
-	output := flag.String("output", "tweet.png", "出力ファイルパス")
-	format := flag.String("format", "", "出力形式: png|jpg|jpeg|gif|svg|html (省略時は拡張子から推定)")
-	width := flag.Int("width", opts.Width, "出力幅(px)")
-	widthMode := flag.String("width-mode", opts.WidthMode, "横幅モード: fixed|tight")
-	padding := flag.Int("padding", opts.Padding, "余白(px)")
-	theme := flag.String("theme", "light", "テーマ: light|dark")
-	fontPath := flag.String("font", "", "本文フォントのパス(.ttf/.otf)")
-	fontBoldPath := flag.String("font-bold", "", "太字フォントのパス(.ttf/.otf)")
-	fontFamily := flag.String("font-family", opts.FontFamily, "HTML/SVG用のfont-family")
-
-	flag.Usage = func() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "md":
+			os.Exit(runMarkdown(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		case "extract":
+			os.Exit(runExtract(os.Args[2:]))
+		case "collage":
+			os.Exit(runCollage(os.Args[2:]))
+		}
+	}
+	os.Exit(runRender(os.Args[1:]))
+}
+
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("xpostgen", flag.ExitOnError)
+	flags := newRenderFlags(fs)
+	preview := fs.Bool("preview", false, "ターミナルに画像をプレビュー表示する")
+	previewMode := fs.String("preview-mode", "auto", "プレビュー方式: auto|kitty|sixel|blocks")
+	imageMap := fs.String("image-map", "", "画像出力のリンク領域を<map>/<area>で記述したHTMLの出力パス")
+	altTextFile := fs.Bool("alt-text", false, "画像出力の代替テキストを同じ場所の .alt.txt に書き出す")
+	optimize := fs.Bool("optimize", false, "PNG出力を可逆で最適化(パレット化・最大圧縮)し、前後のサイズを表示する")
+	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "X投稿プレビュー生成CLI\n\n")
-		fmt.Fprintf(os.Stderr, "必須: -text, -name, -id\n\n")
-		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "使い方: xpostgen [flags] | xpostgen md [flags] files... | xpostgen watch -input spec.yaml [flags] | xpostgen extract file | xpostgen collage [flags] files...\n\n")
+		fmt.Fprintf(os.Stderr, "必須: -text, -name, -id (-input/-from指定時は入力から補完)\n\n")
+		fs.PrintDefaults()
 	}
-	flag.Parse()
+	_ = fs.Parse(args)
 
-	if strings.TrimSpace(*text) == "" || strings.TrimSpace(*name) == "" || strings.TrimSpace(*handle) == "" {
-		flag.Usage()
-		os.Exit(2)
+	cfg, err := flags.config()
+	if errors.Is(err, errMissingRequired) {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
 	}
 
-	selectedTheme, err := parseTheme(*theme)
+	protocol, err := termimg.ParseProtocol(*previewMode)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
+		return 2
 	}
 
-	fmtValue := *format
-	if strings.TrimSpace(fmtValue) == "" {
-		fmtValue = inferFormat(*output)
+	// The sidecars describe the first raster output.
+	raster := ""
+	for _, target := range cfg.Targets {
+		if isRasterFormat(target.Format) && target.Output != "-" {
+			raster = target.Output
+			break
+		}
 	}
-	if fmtValue == "" {
-		fmtValue = "png"
+	if *imageMap != "" && raster == "" {
+		fmt.Fprintln(os.Stderr, "-image-map requires raster output written to a file")
+		return 2
 	}
-
-	data := render.TweetData{
-		Text:      *text,
-		Icon:      *icon,
-		Name:      *name,
-		Handle:    *handle,
-		Date:      *date,
-		Location:  *location,
-		CTA:       *cta,
-		Verified:  *verified,
-		Simple:    *simple,
-		LikeCount: *likeCount,
+	if *altTextFile && raster == "" {
+		fmt.Fprintln(os.Stderr, "-alt-text requires raster output written to a file")
+		return 2
 	}
-	if *noCTA {
-		data.CTA = ""
+
+	if *optimize {
+		png := false
+		for _, target := range cfg.Targets {
+			png = png || target.Format == "png"
+		}
+		if !png {
+			fmt.Fprintln(os.Stderr, "-optimize requires png output")
+			return 2
+		}
 	}
 
-	opts.Width = *width
-	opts.WidthMode = *widthMode
-	opts.Padding = *padding
-	opts.FontPath = *fontPath
-	opts.BoldFontPath = *fontBoldPath
-	opts.FontFamily = *fontFamily
-	opts.Theme = selectedTheme
+	// Binary output to a terminal is shown as a preview instead.
+	toTerminal := cfg.Output == "-" && isRasterFormat(cfg.Format) && isTerminal(os.Stdout)
+	if !toTerminal {
+		if err := writeOutputs(cfg, *optimize); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *imageMap != "" {
+		if err := writeImageMap(*imageMap, raster, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *altTextFile {
+		path := strings.TrimSuffix(raster, filepath.Ext(raster)) + ".alt.txt"
+		if err := os.WriteFile(path, []byte(render.Description(cfg.Data, cfg.Opts)+"\n"), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *preview || toTerminal {
+		previewOut := os.Stdout
+		if cfg.Output == "-" && !toTerminal {
+			// Stdout carries the encoded image.
+			previewOut = os.Stderr
+		}
+		if err := writePreview(previewOut, cfg.Data, cfg.Opts, protocol); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
 
-	if err := writeOutput(*output, data, opts, fmtValue); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+// writePreview draws the card as an image in the terminal.
+func writePreview(out *os.File, data render.TweetData, opts render.RenderOptions, protocol termimg.Protocol) error {
+	img, err := render.RenderImage(data, opts)
+	if err != nil {
+		return err
+	}
+	if protocol == "" {
+		protocol = termimg.Detect(os.Getenv)
+	}
+	size := termimg.TerminalSize(out)
+	if size.Columns == 0 {
+		size.Columns, _ = strconv.Atoi(os.Getenv("COLUMNS"))
 	}
+	return termimg.Write(out, img, protocol, size)
 }
 
-func writeOutput(path string, data render.TweetData, opts render.RenderOptions, format string) error {
-	if path == "-" {
-		return render.RenderToWriter(os.Stdout, data, opts, format)
+// writeImageMap writes the <img>/<map> sidecar for the rendered image,
+// pointing at the output relative to the sidecar.
+func writeImageMap(path, output string, cfg renderConfig) error {
+	src, err := filepath.Rel(filepath.Dir(path), output)
+	if err != nil {
+		src = output
+	}
+	markup, err := render.RenderImageMap(cfg.Data, cfg.Opts, filepath.ToSlash(src))
+	if err != nil {
+		return err
 	}
-	dir := filepath.Dir(path)
-	if dir != "." {
+	if dir := filepath.Dir(path); dir != "." {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
 			return err
 		}
 	}
+	return os.WriteFile(path, []byte(markup), 0o644)
+}
 
-	file, err := os.Create(path)
+func isRasterFormat(format string) bool {
+	switch format {
+	case "png", "jpeg", "gif", "webp", "apng":
+		return true
+	default:
+		return false
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// writeOutputs writes every target of cfg, loading fonts and the avatar
+// once for all of them. With optimize, PNG output is optimized and the
+// sizes before and after are reported.
+func writeOutputs(cfg renderConfig, optimize bool) error {
+	if len(cfg.Targets) == 1 && !optimize {
+		return writeOutput(cfg.Output, cfg.Data, cfg.Opts, cfg.Format)
+	}
+	targets := make([]render.Target, len(cfg.Targets))
+	buffers := make([]*bytes.Buffer, len(cfg.Targets))
+	for i, target := range cfg.Targets {
+		switch {
+		case optimize && target.Format == "png":
+			buffers[i] = new(bytes.Buffer)
+			targets[i] = render.Target{Writer: buffers[i], Format: target.Format}
+		case target.Output == "-":
+			targets[i] = render.Target{Writer: os.Stdout, Format: target.Format}
+		default:
+			file, err := createOutput(target.Output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			targets[i] = render.Target{Writer: file, Format: target.Format}
+		}
+	}
+	if err := render.RenderToWriters(targets, cfg.Data, cfg.Opts); err != nil {
+		return err
+	}
+	for i, buf := range buffers {
+		if buf == nil {
+			continue
+		}
+		target := cfg.Targets[i]
+		optimized, err := render.OptimizePNG(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if target.Output == "-" {
+			_, err = os.Stdout.Write(optimized)
+		} else {
+			err = writeFile(target.Output, optimized)
+		}
+		if err != nil {
+			return err
+		}
+		before, after := buf.Len(), len(optimized)
+		fmt.Fprintf(os.Stderr, "optimized %s: %d -> %d bytes (-%.1f%%)\n", target.Output, before, after, 100*float64(before-after)/float64(before))
+	}
+	return nil
+}
+
+func writeOutput(path string, data render.TweetData, opts render.RenderOptions, format string) error {
+	if path == "-" {
+		return render.RenderToWriter(os.Stdout, data, opts, format)
+	}
+	file, err := createOutput(path)
 	if err != nil {
 		return err
 	}
@@ -110,6 +238,29 @@ func writeOutput(path string, data render.TweetData, opts render.RenderOptions,
 	return render.RenderToWriter(file, data, opts, format)
 }
 
+// writeFile writes content to path, creating its directory.
+func writeFile(path string, content []byte) error {
+	file, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// createOutput creates the output file and its directory.
+func createOutput(path string) (*os.File, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return os.Create(path)
+}
+
 func inferFormat(output string) string {
 	ext := strings.ToLower(filepath.Ext(output))
 	switch ext {
@@ -119,22 +270,21 @@ func inferFormat(output string) string {
 		return "jpeg"
 	case ".gif":
 		return "gif"
+	case ".webp":
+		return "webp"
+	case ".apng":
+		return "apng"
 	case ".svg":
 		return "svg"
+	case ".pdf":
+		return "pdf"
 	case ".html", ".htm":
 		return "html"
+	case ".tsx":
+		return "tsx"
+	case ".json":
+		return "layout"
 	default:
 		return ""
 	}
 }
-
-func parseTheme(value string) (render.Theme, error) {
-	switch strings.ToLower(strings.TrimSpace(value)) {
-	case "", "light":
-		return render.LightTheme(), nil
-	case "dark":
-		return render.DarkTheme(), nil
-	default:
-		return render.Theme{}, fmt.Errorf("unknown theme: %s", value)
-	}
-}
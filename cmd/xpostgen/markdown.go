@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/markdown"
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+func runMarkdown(args []string) int {
+	fs := flag.NewFlagSet("xpostgen md", flag.ExitOnError)
+	format := fs.String("format", "png", "画像形式: png|jpg|jpeg|gif|svg (specのformatが優先)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Markdown内の ```xpost ブロックを画像に変換します\n\n")
+		fmt.Fprintf(os.Stderr, "使い方: xpostgen md [flags] files...\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		if err := processMarkdown(path, *format); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func processMarkdown(path string, format string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	rendered := 0
+	linked := map[string]bool{}
+	renderer := func(spec string) (markdown.Image, error) {
+		cfg, err := specConfig(spec, format)
+		if err != nil {
+			return markdown.Image{}, err
+		}
+		// Relative paths in a block are relative to the markdown file, and
+		// the files they name are part of the hash so editing an icon or a
+		// font re-renders the block.
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\n%s", cfg.Format, spec)
+		for _, file := range resolveSpecFiles(&cfg.Data, &cfg.Opts, dir) {
+			content, err := os.ReadFile(*file)
+			if err != nil {
+				return markdown.Image{}, err
+			}
+			fmt.Fprintf(hash, "\n%d\n", len(content))
+			hash.Write(content)
+		}
+		sum := hash.Sum(nil)
+		target := fmt.Sprintf("%s.xpost-%s.%s", stem, hex.EncodeToString(sum[:6]), imageExt(cfg.Format))
+		image := markdown.Image{Target: target, Alt: render.Description(cfg.Data, cfg.Opts)}
+		linked[target] = true
+
+		output := filepath.Join(dir, target)
+		if _, err := os.Stat(output); err == nil {
+			return image, nil
+		}
+		if err := writeOutput(output, cfg.Data, cfg.Opts, cfg.Format); err != nil {
+			return markdown.Image{}, err
+		}
+		rendered++
+		return image, nil
+	}
+
+	out, blocks, err := markdown.Rewrite(string(src), renderer)
+	if err != nil {
+		return err
+	}
+	if out != string(src) {
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			return err
+		}
+	}
+	// Images the document linked before but no longer does belong to
+	// edited or removed blocks.
+	previous := regexp.MustCompile(regexp.QuoteMeta(stem) + `\.xpost-[0-9a-f]{12}\.[a-z]+`)
+	for _, target := range previous.FindAllString(string(src), -1) {
+		if linked[target] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, target)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		linked[target] = true
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d blocks (%d rendered, %d unchanged)\n", path, blocks, rendered, blocks-rendered)
+	return nil
+}
+
+// resolveSpecFiles makes the local file paths of data and opts (icon,
+// fonts and a canvas background image) relative to dir and returns
+// pointers to them. URLs, data URIs and absolute paths are left as they
+// are.
+func resolveSpecFiles(data *render.TweetData, opts *render.RenderOptions, dir string) []*string {
+	paths := []*string{&data.Icon, &opts.FontPath, &opts.BoldFontPath}
+	if bg := strings.TrimSpace(opts.Canvas.Background); bg != "" && !strings.HasPrefix(bg, "#") && !strings.EqualFold(bg, "transparent") {
+		paths = append(paths, &opts.Canvas.Background)
+	}
+	var files []*string
+	for _, path := range paths {
+		if *path == "" || strings.Contains(*path, "://") || strings.HasPrefix(*path, "data:") {
+			continue
+		}
+		if !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+// specConfig resolves a block spec with the same defaults as the render command.
+func specConfig(spec string, format string) (renderConfig, error) {
+	fs := flag.NewFlagSet("xpost", flag.ContinueOnError)
+	flags := newRenderFlags(fs)
+	if err := fs.Set("format", format); err != nil {
+		return renderConfig{}, err
+	}
+	if err := applySpec(fs, []byte(spec), nil); err != nil {
+		return renderConfig{}, err
+	}
+	cfg, err := flags.config()
+	if err != nil {
+		return renderConfig{}, err
+	}
+	if cfg.Format == "html" || cfg.Format == "pdf" || cfg.Format == "layout" || cfg.Format == "tsx" {
+		return renderConfig{}, fmt.Errorf("%s cannot be linked as an image", cfg.Format)
+	}
+	return cfg, nil
+}
+
+func imageExt(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
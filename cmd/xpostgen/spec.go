@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// specOnlyFlags lists flags that only make sense on the command line and
+// are rejected inside a spec file.
+var specOnlyFlags = map[string]bool{
+	"input":        true,
+	"input-format": true,
+	"bsky-profile": true,
+	"from":         true,
+}
+
+// parseSpec decodes a YAML or JSON spec whose keys are the CLI flag names,
+// for example {"text": "...", "name": "jack", "id": "jack", "verified": true}.
+func parseSpec(data []byte) (map[string]any, error) {
+	spec := map[string]any{}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+	return spec, nil
+}
+
+// applySpec sets every spec value on fs unless the flag was given
+// explicitly on the command line.
+func applySpec(fs *flag.FlagSet, data []byte, explicit map[string]bool) error {
+	spec, err := parseSpec(data)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(spec))
+	for key := range spec {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if specOnlyFlags[key] || fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown spec key: %s", key)
+		}
+		if explicit[key] {
+			continue
+		}
+		value := spec[key]
+		switch typed := value.(type) {
+		case map[string]any, []any:
+			return fmt.Errorf("spec key %s must be a scalar", key)
+		case nil:
+			continue
+		case time.Time:
+			value = typed.Format(time.RFC3339)
+		}
+		if err := fs.Set(key, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("spec key %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+const watchPage = `<!doctype html>
+<html lang="ja">
+<head>
+  <meta charset="utf-8" />
+  <title>xpostgen watch</title>
+  <style>
+    body { margin: 0; padding: 24px; font-family: sans-serif; background: #F7F9F9; color: #0F1419; }
+    header { display: flex; gap: 12px; align-items: baseline; margin-bottom: 16px; font-size: 14px; color: #536471; }
+    #error { display: none; white-space: pre-wrap; padding: 12px 16px; margin-bottom: 16px; border-radius: 8px; background: #FDECEA; color: #B3261E; font-family: monospace; }
+    #preview img, #preview iframe { display: block; max-width: 100%; border: 0; }
+    #preview iframe { width: 100%; height: 80vh; background: #FFFFFF; }
+  </style>
+</head>
+<body>
+  <header><strong>xpostgen watch</strong><span>{{.Output}}</span><span id="updated"></span></header>
+  <div id="error"></div>
+  <div id="preview">
+    {{if .Frame}}<iframe id="output" src="/output"></iframe>{{else}}<img id="output" src="/output" alt="preview" />{{end}}
+  </div>
+  <script>
+    let version = -1;
+    async function poll() {
+      try {
+        const res = await fetch("/status", { cache: "no-store" });
+        const status = await res.json();
+        const error = document.getElementById("error");
+        error.style.display = status.error ? "block" : "none";
+        error.textContent = status.error || "";
+        if (status.version !== version) {
+          if (version !== -1) {
+            document.getElementById("output").src = "/output?v=" + status.version;
+          }
+          version = status.version;
+          document.getElementById("updated").textContent = status.updated;
+        }
+      } catch (e) {
+        document.getElementById("updated").textContent = "disconnected";
+      }
+      setTimeout(poll, 500);
+    }
+    poll();
+  </script>
+</body>
+</html>
+`
+
+// watchState is the latest render shared with the preview server.
+type watchState struct {
+	mu      sync.Mutex
+	path    string
+	version int
+	output  []byte
+	format  string
+	err     string
+	updated time.Time
+}
+
+func runWatch(args []string) int {
+	fs, _, addr, interval := newWatchFlags(flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "spec/アイコン/フォントの変更を監視して再描画し、ローカルでプレビューを配信します\n\n")
+		fmt.Fprintf(os.Stderr, "使い方: xpostgen watch -input spec.yaml [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	state := &watchState{}
+	watched := map[string]fileStamp{}
+	rerender := func() {
+		files := renderWatch(args, state)
+		watched = stampFiles(files)
+	}
+	rerender()
+
+	page := template.Must(template.New("watch").Parse(watchPage))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		state.mu.Lock()
+		view := map[string]any{"Output": state.path, "Frame": state.format == "html" || state.format == "pdf" || state.format == "layout" || state.format == "tsx"}
+		state.mu.Unlock()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = page.Execute(w, view)
+	})
+	mux.HandleFunc("/output", func(w http.ResponseWriter, r *http.Request) {
+		state.mu.Lock()
+		output, format := state.output, state.format
+		state.mu.Unlock()
+		if output == nil {
+			http.Error(w, "no output yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeForFormat(format))
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(output)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		state.mu.Lock()
+		status := map[string]any{
+			"version": state.version,
+			"error":   state.err,
+			"updated": state.updated.Format("15:04:05"),
+		}
+		state.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(status)
+	})
+
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if filesChanged(watched) {
+				rerender()
+			}
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "watching... preview at http://%s/\n", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newWatchFlags builds a fresh flag set so every re-render resolves the
+// spec from scratch instead of inheriting values from the previous run.
+func newWatchFlags(handling flag.ErrorHandling) (*flag.FlagSet, *renderFlags, *string, *time.Duration) {
+	fs := flag.NewFlagSet("xpostgen watch", handling)
+	flags := newRenderFlags(fs)
+	addr := fs.String("addr", "localhost:8080", "プレビューサーバーのアドレス")
+	interval := fs.Duration("interval", 500*time.Millisecond, "変更の確認間隔")
+	return fs, flags, addr, interval
+}
+
+// renderWatch renders once, records the result in state, and returns the
+// files whose changes should trigger the next render.
+func renderWatch(args []string, state *watchState) (files []string) {
+	fs, flags, _, _ := newWatchFlags(flag.ContinueOnError)
+	fs.SetOutput(new(bytes.Buffer))
+	_ = fs.Parse(args)
+
+	files = []string{*flags.input}
+	state.mu.Lock()
+	state.path = *flags.output
+	state.mu.Unlock()
+	fail := func(err error) []string {
+		state.mu.Lock()
+		state.err = err.Error()
+		state.version++
+		state.updated = time.Now()
+		state.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "render failed: %v\n", err)
+		return files
+	}
+	// A panic in the renderer (a broken font or image) is reported like
+	// any other error so the watcher keeps running.
+	defer func() {
+		if r := recover(); r != nil {
+			files = fail(fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	cfg, err := flags.config()
+	if err != nil {
+		return fail(err)
+	}
+	// The icon, fonts and canvas background image are watched too.
+	for _, file := range resolveSpecFiles(&cfg.Data, &cfg.Opts, ".") {
+		files = append(files, *file)
+	}
+
+	// Every output is written; the preview shows the first.
+	outputs := make([]bytes.Buffer, len(cfg.Targets))
+	targets := make([]render.Target, len(cfg.Targets))
+	for i, target := range cfg.Targets {
+		targets[i] = render.Target{Writer: &outputs[i], Format: target.Format}
+	}
+	if err := render.RenderToWriters(targets, cfg.Data, cfg.Opts); err != nil {
+		return fail(err)
+	}
+	for i, target := range cfg.Targets {
+		if target.Output == "-" {
+			continue
+		}
+		if dir := filepath.Dir(target.Output); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fail(err)
+			}
+		}
+		if err := os.WriteFile(target.Output, outputs[i].Bytes(), 0o644); err != nil {
+			return fail(err)
+		}
+	}
+
+	state.mu.Lock()
+	state.output = outputs[0].Bytes()
+	state.format = cfg.Format
+	state.err = ""
+	state.version++
+	state.updated = time.Now()
+	state.mu.Unlock()
+	for _, target := range cfg.Targets {
+		fmt.Fprintf(os.Stderr, "rendered %s\n", target.Output)
+	}
+	return files
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, path := range paths {
+		if path == "" || path == "-" {
+			continue
+		}
+		stamps[path] = stampFile(path)
+	}
+	return stamps
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{missing: true}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+func filesChanged(stamps map[string]fileStamp) bool {
+	for path, stamp := range stamps {
+		if stampFile(path) != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+func contentTypeForFormat(format string) string {
+	switch format {
+	case "png":
+		return "image/png"
+	case "apng":
+		return "image/apng"
+	case "jpeg":
+		return "image/jpeg"
+	case "gif":
+		return "image/gif"
+	case "webp":
+		return "image/webp"
+	case "svg":
+		return "image/svg+xml"
+	case "pdf":
+		return "application/pdf"
+	case "html":
+		return "text/html; charset=utf-8"
+	case "layout":
+		return "application/json"
+	case "tsx":
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
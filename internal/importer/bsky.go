@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+type bskyRecord struct {
+	Type      string      `json:"$type"`
+	Text      string      `json:"text"`
+	CreatedAt string      `json:"createdAt"`
+	Facets    []bskyFacet `json:"facets"`
+	Langs     []string    `json:"langs"`
+}
+
+type bskyFacet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []bskyFeature `json:"features"`
+}
+
+type bskyFeature struct {
+	Type string `json:"$type"`
+	DID  string `json:"did"`
+	URI  string `json:"uri"`
+	Tag  string `json:"tag"`
+}
+
+type bskyProfile struct {
+	DID         string `json:"did"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"displayName"`
+	Avatar      string `json:"avatar"`
+}
+
+type bskyPostView struct {
+	URI        string          `json:"uri"`
+	Author     *bskyProfile    `json:"author"`
+	Record     json.RawMessage `json:"record"`
+	LikeCount  *int            `json:"likeCount"`
+	ReplyCount *int            `json:"replyCount"`
+}
+
+// bskyEnvelope covers the shapes returned by getPostThread and getPosts as
+// well as a bare post view.
+type bskyEnvelope struct {
+	bskyPostView
+	Thread *struct {
+		Post *bskyPostView `json:"post"`
+	} `json:"thread"`
+	Posts []bskyPostView `json:"posts"`
+}
+
+// ParseBluesky converts an app.bsky.feed.post record into TweetData.
+// data may be a bare record, a post view, or a getPostThread/getPosts
+// response. profile is an optional app.bsky.actor profile view; it is
+// required for bare records, which carry no author information.
+func ParseBluesky(data []byte, profile []byte) (render.TweetData, error) {
+	var envelope bskyEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return render.TweetData{}, fmt.Errorf("failed to parse bluesky post: %w", err)
+	}
+
+	view := envelope.bskyPostView
+	switch {
+	case envelope.Thread != nil && envelope.Thread.Post != nil:
+		view = *envelope.Thread.Post
+	case len(envelope.Posts) > 0:
+		view = envelope.Posts[0]
+	}
+
+	recordJSON := []byte(view.Record)
+	if len(recordJSON) == 0 {
+		recordJSON = data
+	}
+	var record bskyRecord
+	if err := json.Unmarshal(recordJSON, &record); err != nil {
+		return render.TweetData{}, fmt.Errorf("failed to parse bluesky record: %w", err)
+	}
+	if record.Type != "" && record.Type != "app.bsky.feed.post" {
+		return render.TweetData{}, fmt.Errorf("unsupported bluesky record type: %s", record.Type)
+	}
+
+	author := view.Author
+	if len(profile) > 0 {
+		var parsed bskyProfile
+		if err := json.Unmarshal(profile, &parsed); err != nil {
+			return render.TweetData{}, fmt.Errorf("failed to parse bluesky profile: %w", err)
+		}
+		author = &parsed
+	}
+	if author == nil {
+		return render.TweetData{}, fmt.Errorf("bluesky post has no author profile")
+	}
+
+	out := render.TweetData{
+		Text:     record.Text,
+		Icon:     author.Avatar,
+		Name:     author.DisplayName,
+		Handle:   author.Handle,
+		Entities: bskyEntities(record.Text, record.Facets),
+	}
+	if strings.TrimSpace(out.Name) == "" {
+		out.Name = author.Handle
+	}
+	if record.CreatedAt != "" {
+		created, err := time.Parse(time.RFC3339, record.CreatedAt)
+		if err != nil {
+			return render.TweetData{}, fmt.Errorf("invalid bluesky createdAt: %w", err)
+		}
+		out.Time = created
+	}
+	if len(record.Langs) > 0 {
+		out.Lang = record.Langs[0]
+	}
+	out.Permalink = bskyPermalink(view.URI, author)
+	if author.Handle != "" {
+		out.ProfileURL = "https://bsky.app/profile/" + author.Handle
+	}
+	if view.LikeCount != nil {
+		out.LikeCount = formatCount(*view.LikeCount)
+	}
+	return out, nil
+}
+
+func bskyEntities(text string, facets []bskyFacet) []render.Entity {
+	if len(facets) == 0 {
+		return nil
+	}
+	runeAt := byteToRuneIndex(text)
+	entities := make([]render.Entity, 0, len(facets))
+	for _, facet := range facets {
+		start, end := facet.Index.ByteStart, facet.Index.ByteEnd
+		if start < 0 || end > len(text) || start >= end {
+			continue
+		}
+		if runeAt[start] < 0 || runeAt[end] < 0 {
+			continue
+		}
+		for _, feature := range facet.Features {
+			entity := render.Entity{Start: runeAt[start], End: runeAt[end]}
+			switch feature.Type {
+			case "app.bsky.richtext.facet#mention":
+				entity.Type = "mention"
+				entity.URL = "https://bsky.app/profile/" + feature.DID
+			case "app.bsky.richtext.facet#link":
+				entity.Type = "link"
+				entity.URL = feature.URI
+			case "app.bsky.richtext.facet#tag":
+				entity.Type = "hashtag"
+				entity.URL = "https://bsky.app/hashtag/" + feature.Tag
+			default:
+				continue
+			}
+			entities = append(entities, entity)
+			break
+		}
+	}
+	return entities
+}
+
+// bskyPermalink turns at://<did>/app.bsky.feed.post/<rkey> into a bsky.app URL.
+func bskyPermalink(uri string, author *bskyProfile) string {
+	rest, ok := strings.CutPrefix(uri, "at://")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[1] != "app.bsky.feed.post" {
+		return ""
+	}
+	actor := parts[0]
+	if author.Handle != "" {
+		actor = author.Handle
+	}
+	return "https://bsky.app/profile/" + actor + "/post/" + parts[2]
+}
+
+// byteToRuneIndex maps every byte offset of text (including len(text)) to
+// the rune index starting there, or -1 for offsets inside a rune.
+func byteToRuneIndex(text string) []int {
+	index := make([]int, len(text)+1)
+	for i := range index {
+		index[i] = -1
+	}
+	count := 0
+	for offset := range text {
+		index[offset] = count
+		count++
+	}
+	index[len(text)] = count
+	return index
+}
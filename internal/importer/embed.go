@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+var embedAuthorPattern = regexp.MustCompile(`[—–-]\s*(.*?)\s*\(@([A-Za-z0-9_]+)\)`)
+
+// ParseEmbed extracts the first X embed blockquote
+// (<blockquote class="twitter-tweet">) found in the HTML document.
+func ParseEmbed(data []byte) (render.TweetData, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return render.TweetData{}, fmt.Errorf("failed to parse embed html: %w", err)
+	}
+	quote := findNode(doc, func(n *html.Node) bool {
+		return n.DataAtom == atom.Blockquote && hasClass(n, "twitter-tweet")
+	})
+	if quote == nil {
+		return render.TweetData{}, fmt.Errorf("no twitter-tweet blockquote found")
+	}
+
+	out := render.TweetData{}
+	var trailer strings.Builder
+	var permalink *html.Node
+	for child := quote.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case child.Type == html.ElementNode && child.DataAtom == atom.P && out.Text == "":
+			out.Text, out.Entities = embedText(child)
+			out.Lang = attr(child, "lang")
+		case child.Type == html.ElementNode && child.DataAtom == atom.A:
+			permalink = child
+		case child.Type == html.TextNode:
+			trailer.WriteString(child.Data)
+		}
+	}
+
+	match := embedAuthorPattern.FindStringSubmatch(trailer.String())
+	if match == nil {
+		return render.TweetData{}, fmt.Errorf("embed is missing the author line")
+	}
+	out.Name = strings.TrimSpace(match[1])
+	out.Handle = match[2]
+	if permalink != nil {
+		out.Date = strings.TrimSpace(textContent(permalink))
+		out.Permalink = cleanEmbedURL(attr(permalink, "href"))
+	}
+	if parsed, err := url.Parse(out.Permalink); err == nil && parsed.Host != "" {
+		out.ProfileURL = parsed.Scheme + "://" + parsed.Host + "/" + out.Handle
+	}
+	return out, nil
+}
+
+// embedText flattens the post paragraph into plain text, turning <br> into
+// newlines and anchors into entities.
+func embedText(p *html.Node) (string, []render.Entity) {
+	var builder strings.Builder
+	var entities []render.Entity
+	length := 0
+	write := func(s string) {
+		builder.WriteString(s)
+		length += len([]rune(s))
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch {
+			case child.Type == html.TextNode:
+				write(child.Data)
+			case child.Type == html.ElementNode && child.DataAtom == atom.Br:
+				write("\n")
+			case child.Type == html.ElementNode && child.DataAtom == atom.A:
+				label := textContent(child)
+				start := length
+				write(label)
+				entities = append(entities, render.Entity{
+					Type:  embedEntityType(label),
+					Start: start,
+					End:   length,
+					URL:   cleanEmbedURL(attr(child, "href")),
+				})
+			default:
+				walk(child)
+			}
+		}
+	}
+	walk(p)
+	return builder.String(), entities
+}
+
+func embedEntityType(label string) string {
+	switch {
+	case strings.HasPrefix(label, "@"):
+		return "mention"
+	case strings.HasPrefix(label, "#"), strings.HasPrefix(label, "$"):
+		return "hashtag"
+	default:
+		return "link"
+	}
+}
+
+// cleanEmbedURL drops the ref_src/src tracking parameters publish.twitter.com adds.
+func cleanEmbedURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := parsed.Query()
+	query.Del("ref_src")
+	query.Del("src")
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func findNode(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(n) {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findNode(child, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, value := range strings.Fields(attr(n, "class")) {
+		if value == class {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	var builder strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			builder.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return builder.String()
+}
@@ -0,0 +1,50 @@
+// Package importer converts posts from other sources into render.TweetData.
+package importer
+
+import (
+	"strconv"
+	"strings"
+)
+
+// formatCount renders a counter the way X abbreviates it: 1,234 / 12.3K / 262K / 1.2M.
+func formatCount(value int) string {
+	if value < 0 {
+		value = 0
+	}
+	switch {
+	case value < 10_000:
+		return groupThousands(value)
+	case value < 1_000_000:
+		return abbreviate(float64(value)/1_000, "K")
+	default:
+		return abbreviate(float64(value)/1_000_000, "M")
+	}
+}
+
+func abbreviate(value float64, suffix string) string {
+	if value >= 100 {
+		return strconv.Itoa(int(value)) + suffix
+	}
+	truncated := float64(int(value*10)) / 10
+	text := strconv.FormatFloat(truncated, 'f', 1, 64)
+	return strings.TrimSuffix(text, ".0") + suffix
+}
+
+func groupThousands(value int) string {
+	digits := strconv.Itoa(value)
+	if len(digits) <= 3 {
+		return digits
+	}
+	var builder strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		builder.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if builder.Len() > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(digits[i : i+3])
+	}
+	return builder.String()
+}
@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"testing"
+)
+
+func TestParseBlueskyPostView(t *testing.T) {
+	payload := `{
+  "uri": "at://did:plc:abc/app.bsky.feed.post/3k2",
+  "author": {"did": "did:plc:abc", "handle": "alice.bsky.social", "displayName": "Alice", "avatar": "https://cdn.example/a.jpg"},
+  "record": {
+    "$type": "app.bsky.feed.post",
+    "text": "こんにちは @bob.bsky.social see https://example.com #go",
+    "createdAt": "2024-03-05T10:55:00.000Z",
+    "facets": [
+      {"index": {"byteStart": 16, "byteEnd": 32}, "features": [{"$type": "app.bsky.richtext.facet#mention", "did": "did:plc:bob"}]},
+      {"index": {"byteStart": 37, "byteEnd": 56}, "features": [{"$type": "app.bsky.richtext.facet#link", "uri": "https://example.com"}]},
+      {"index": {"byteStart": 57, "byteEnd": 60}, "features": [{"$type": "app.bsky.richtext.facet#tag", "tag": "go"}]}
+    ]
+  },
+  "likeCount": 262123
+}`
+	data, err := ParseBluesky([]byte(payload), nil)
+	if err != nil {
+		t.Fatalf("ParseBluesky: %v", err)
+	}
+	if data.Name != "Alice" || data.Handle != "alice.bsky.social" {
+		t.Fatalf("unexpected author: %q %q", data.Name, data.Handle)
+	}
+	if data.Permalink != "https://bsky.app/profile/alice.bsky.social/post/3k2" {
+		t.Fatalf("unexpected permalink: %s", data.Permalink)
+	}
+	if data.ProfileURL != "https://bsky.app/profile/alice.bsky.social" {
+		t.Fatalf("unexpected profile url: %s", data.ProfileURL)
+	}
+	if data.LikeCount != "262K" {
+		t.Fatalf("unexpected like count: %s", data.LikeCount)
+	}
+	if len(data.Entities) != 3 {
+		t.Fatalf("expected 3 entities, got %v", data.Entities)
+	}
+	runes := []rune(data.Text)
+	want := []string{"@bob.bsky.social", "https://example.com", "#go"}
+	for i, entity := range data.Entities {
+		if got := string(runes[entity.Start:entity.End]); got != want[i] {
+			t.Fatalf("entity %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+}
+
+func TestParseBlueskyRecordNeedsProfile(t *testing.T) {
+	record := `{"$type": "app.bsky.feed.post", "text": "hi", "createdAt": "2024-03-05T10:55:00Z"}`
+	if _, err := ParseBluesky([]byte(record), nil); err == nil {
+		t.Fatalf("expected error without profile")
+	}
+	data, err := ParseBluesky([]byte(record), []byte(`{"handle": "alice.bsky.social"}`))
+	if err != nil {
+		t.Fatalf("ParseBluesky: %v", err)
+	}
+	if data.Name != "alice.bsky.social" {
+		t.Fatalf("expected handle as name fallback, got %q", data.Name)
+	}
+}
+
+func TestBlueskyFacetInsideRuneIsSkipped(t *testing.T) {
+	entities := bskyEntities("日本", []bskyFacet{{
+		Index: struct {
+			ByteStart int `json:"byteStart"`
+			ByteEnd   int `json:"byteEnd"`
+		}{ByteStart: 1, ByteEnd: 3},
+		Features: []bskyFeature{{Type: "app.bsky.richtext.facet#tag", Tag: "x"}},
+	}})
+	if len(entities) != 0 {
+		t.Fatalf("expected misaligned facet to be dropped, got %v", entities)
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	cases := map[int]string{
+		7:       "7",
+		1234:    "1,234",
+		12345:   "12.3K",
+		262000:  "262K",
+		1250000: "1.2M",
+	}
+	for value, want := range cases {
+		if got := formatCount(value); got != want {
+			t.Fatalf("formatCount(%d) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+func TestParseEmbed(t *testing.T) {
+	markup := `<p>intro</p>
+<blockquote class="twitter-tweet"><p lang="en" dir="ltr">hello <a href="https://twitter.com/bob?ref_src=twsrc%5Etfw">@bob</a> &amp; <a href="https://twitter.com/hashtag/go?src=hash&amp;ref_src=twsrc%5Etfw">#go</a><br>second line</p>&mdash; jack (@jack) <a href="https://twitter.com/jack/status/20?ref_src=twsrc%5Etfw">March 21, 2006</a></blockquote> <script async src="https://platform.twitter.com/widgets.js" charset="utf-8"></script>`
+	data, err := ParseEmbed([]byte(markup))
+	if err != nil {
+		t.Fatalf("ParseEmbed: %v", err)
+	}
+	if data.Text != "hello @bob & #go\nsecond line" {
+		t.Fatalf("unexpected text: %q", data.Text)
+	}
+	if data.Name != "jack" || data.Handle != "jack" {
+		t.Fatalf("unexpected author: %q %q", data.Name, data.Handle)
+	}
+	if data.Date != "March 21, 2006" {
+		t.Fatalf("unexpected date: %q", data.Date)
+	}
+	if data.Permalink != "https://twitter.com/jack/status/20" {
+		t.Fatalf("unexpected permalink: %q", data.Permalink)
+	}
+	if data.ProfileURL != "https://twitter.com/jack" {
+		t.Fatalf("unexpected profile url: %q", data.ProfileURL)
+	}
+	if len(data.Entities) != 2 || data.Entities[0].Type != "mention" || data.Entities[1].Type != "hashtag" {
+		t.Fatalf("unexpected entities: %+v", data.Entities)
+	}
+	if data.Entities[1].URL != "https://twitter.com/hashtag/go" {
+		t.Fatalf("expected tracking params to be dropped, got %s", data.Entities[1].URL)
+	}
+}
+
+func TestParseEmbedWithoutBlockquote(t *testing.T) {
+	if _, err := ParseEmbed([]byte("<p>nothing here</p>")); err == nil {
+		t.Fatalf("expected error")
+	}
+}
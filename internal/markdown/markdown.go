@@ -0,0 +1,152 @@
+// Package markdown rewrites ```xpost fenced blocks in Markdown documents
+// into image links.
+//
+// A block such as
+//
+//	```xpost
+//	text: just setting up my twttr
+//	name: jack
+//	id: jack
+//	```
+//
+// is replaced by an HTML comment that keeps the spec editable, followed by
+// the image link:
+//
+//	<!-- xpost
+//	text: just setting up my twttr
+//	name: jack
+//	id: jack
+//	-->
+//	![jack (@jack): just setting up my twttr](doc.xpost-1a2b3c4d5e6f.png)
+//
+// Later runs find the comment form again and refresh the link.
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	commentOpen  = "<!-- xpost"
+	commentClose = "-->"
+)
+
+var (
+	fencePattern = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*(\\S*)")
+	imagePattern = regexp.MustCompile(`^!\[.*\]\(.*\)\s*$`)
+)
+
+// Image is the rendered result for a spec.
+type Image struct {
+	Target string
+	Alt    string
+}
+
+// Renderer renders a spec (YAML or JSON) and returns the image to link.
+type Renderer func(spec string) (Image, error)
+
+// Rewrite renders every xpost block in src and returns the rewritten
+// document along with the number of blocks found.
+func Rewrite(src string, render Renderer) (string, int, error) {
+	lines := strings.Split(src, "\n")
+	var out []string
+	blocks := 0
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimRight(line, " \t\r")
+
+		if match := fencePattern.FindStringSubmatch(trimmed); match != nil {
+			end := closingFence(lines, i+1, match[1])
+			if match[2] != "xpost" {
+				if end < 0 {
+					end = len(lines) - 1
+				}
+				out = append(out, lines[i:end+1]...)
+				i = end
+				continue
+			}
+			if end < 0 {
+				return "", blocks, fmt.Errorf("line %d: unterminated xpost block", i+1)
+			}
+			spec := strings.Join(lines[i+1:end], "\n")
+			block, err := renderBlock(spec, render, i+1)
+			if err != nil {
+				return "", blocks, err
+			}
+			out = append(out, block...)
+			blocks++
+			i = end
+			continue
+		}
+
+		if trimmed == commentOpen {
+			end := -1
+			for j := i + 1; j < len(lines); j++ {
+				if strings.TrimRight(lines[j], " \t\r") == commentClose {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				return "", blocks, fmt.Errorf("line %d: unterminated xpost comment", i+1)
+			}
+			spec := strings.Join(lines[i+1:end], "\n")
+			block, err := renderBlock(spec, render, i+1)
+			if err != nil {
+				return "", blocks, err
+			}
+			out = append(out, block...)
+			blocks++
+			i = end
+			if i+1 < len(lines) && imagePattern.MatchString(lines[i+1]) {
+				i++
+			}
+			continue
+		}
+
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n"), blocks, nil
+}
+
+func closingFence(lines []string, from int, open string) int {
+	for j := from; j < len(lines); j++ {
+		candidate := strings.TrimSpace(lines[j])
+		if len(candidate) >= len(open) && strings.Trim(candidate, open[:1]) == "" {
+			return j
+		}
+	}
+	return -1
+}
+
+func renderBlock(spec string, render Renderer, line int) ([]string, error) {
+	if strings.Contains(spec, commentClose) {
+		return nil, fmt.Errorf("line %d: xpost spec must not contain %q", line, commentClose)
+	}
+	image, err := render(spec)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	block := []string{commentOpen}
+	if spec != "" {
+		block = append(block, strings.Split(spec, "\n")...)
+	}
+	block = append(block, commentClose, fmt.Sprintf("![%s](%s)", escapeAlt(image.Alt), linkTarget(image.Target)))
+	return block, nil
+}
+
+func escapeAlt(alt string) string {
+	alt = strings.Join(strings.Fields(alt), " ")
+	replacer := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(alt)
+}
+
+func linkTarget(target string) string {
+	if strings.ContainsAny(target, " ()") {
+		return "<" + target + ">"
+	}
+	return target
+}
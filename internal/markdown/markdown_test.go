@@ -0,0 +1,55 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteFencedBlock(t *testing.T) {
+	src := "# Doc\n\n```xpost\ntext: hi\nname: jack\nid: jack\n```\n\n```yaml\n```xpost\n```\n"
+	var specs []string
+	out, blocks, err := Rewrite(src, func(spec string) (Image, error) {
+		specs = append(specs, spec)
+		return Image{Target: "doc.xpost-abc.png", Alt: "jack [bot]\nhi"}, nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	if blocks != 1 || len(specs) != 1 || specs[0] != "text: hi\nname: jack\nid: jack" {
+		t.Fatalf("unexpected blocks: %d %q", blocks, specs)
+	}
+	want := "<!-- xpost\ntext: hi\nname: jack\nid: jack\n-->\n![jack \\[bot\\] hi](doc.xpost-abc.png)\n"
+	if !strings.Contains(out, want) {
+		t.Fatalf("expected rewritten block, got:\n%s", out)
+	}
+	if !strings.Contains(out, "```yaml\n```xpost\n```") {
+		t.Fatalf("expected other code fences to be kept, got:\n%s", out)
+	}
+}
+
+func TestRewriteIsIdempotent(t *testing.T) {
+	src := "```xpost\ntext: hi\n```\n"
+	renderer := func(spec string) (Image, error) {
+		return Image{Target: "a.png", Alt: "hi"}, nil
+	}
+	first, _, err := Rewrite(src, renderer)
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	second, blocks, err := Rewrite(first, renderer)
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	if blocks != 1 || first != second {
+		t.Fatalf("expected stable output, got:\n%s\n---\n%s", first, second)
+	}
+}
+
+func TestRewriteUnterminatedBlock(t *testing.T) {
+	_, _, err := Rewrite("```xpost\ntext: hi\n", func(string) (Image, error) {
+		return Image{}, nil
+	})
+	if err == nil {
+		t.Fatalf("expected error for unterminated block")
+	}
+}
@@ -0,0 +1,211 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AnimationOptions turns raster output into a "typing" animation: the post
+// text types in along its wrapped lines, the like count ticks up, and the
+// CTA fades in. The animation is disabled while Duration is 0.
+type AnimationOptions struct {
+	FPS      int
+	Duration time.Duration
+	// Once plays the animation a single time instead of looping.
+	Once bool
+}
+
+// Enabled reports whether an animation should be rendered.
+func (a AnimationOptions) Enabled() bool {
+	return a.Duration > 0
+}
+
+const (
+	defaultAnimationFPS      = 15
+	defaultAnimationDuration = 3 * time.Second
+	// Phase ends as fractions of the duration; the rest holds the final card.
+	typingEnd = 0.6
+	likesEnd  = 0.8
+	ctaEnd    = 0.9
+)
+
+// AnimationFrame is a rendered frame and how long it stays on screen.
+type AnimationFrame struct {
+	Image *image.RGBA
+	Delay time.Duration
+}
+
+// RenderAnimation renders the frames of the typing animation. Consecutive
+// identical frames are merged into one longer frame.
+func RenderAnimation(data TweetData, opts RenderOptions) ([]AnimationFrame, error) {
+	opts = normalizeOptions(opts)
+	if opts.Canvas.Enabled() {
+		return nil, fmt.Errorf("animation does not support canvas mode")
+	}
+	anim := opts.Animation
+	if anim.Duration <= 0 {
+		return nil, fmt.Errorf("animation duration must be positive")
+	}
+	fps := anim.FPS
+	if fps <= 0 {
+		fps = defaultAnimationFPS
+	}
+
+	painter, err := newCardPainter(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer painter.Close()
+
+	totalChars := 0
+	for _, line := range computeLayout(data, opts, painter.fonts).TextLines {
+		totalChars += len([]rune(line))
+	}
+	likes, formatLikes, countable := parseLikeCount(data.LikeCount)
+
+	count := max(1, int(math.Round(anim.Duration.Seconds()*float64(fps))))
+	delay := anim.Duration / time.Duration(count)
+	var frames []AnimationFrame
+	var prevKey string
+	for i := 0; i < count; i++ {
+		t := 1.0
+		if count > 1 {
+			t = float64(i) / float64(count-1)
+		}
+		frame := cardFrame{
+			Chars:    int(math.Round(float64(totalChars) * phase(t, 0, typingEnd))),
+			CTAAlpha: phase(t, likesEnd, ctaEnd),
+		}
+		frameData := data
+		if countable {
+			eased := 1 - math.Pow(1-phase(t, typingEnd, likesEnd), 3)
+			frameData.LikeCount = formatLikes(int(math.Round(float64(likes) * eased)))
+		}
+
+		key := fmt.Sprintf("%d/%.3f/%s", frame.Chars, frame.CTAAlpha, frameData.LikeCount)
+		if len(frames) > 0 && key == prevKey {
+			frames[len(frames)-1].Delay += delay
+			continue
+		}
+		img, err := painter.paint(frameData, frame)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, AnimationFrame{Image: img, Delay: delay})
+		prevKey = key
+	}
+	return frames, nil
+}
+
+// phase maps t to the progress of a phase running from start to end,
+// clamped to [0, 1].
+func phase(t, start, end float64) float64 {
+	return math.Max(0, math.Min(1, (t-start)/(end-start)))
+}
+
+// parseLikeCount reads a like count such as "42" or "1,234" so it can be
+// animated. Labels like "1.2K" are not countable and stay fixed.
+func parseLikeCount(label string) (int, func(int) string, bool) {
+	label = strings.TrimSpace(label)
+	commas := strings.Contains(label, ",")
+	value, err := strconv.Atoi(strings.ReplaceAll(label, ",", ""))
+	if err != nil || value < 0 {
+		return 0, nil, false
+	}
+	format := func(n int) string {
+		s := strconv.Itoa(n)
+		if !commas {
+			return s
+		}
+		for i := len(s) - 3; i > 0; i -= 3 {
+			s = s[:i] + "," + s[i:]
+		}
+		return s
+	}
+	return value, format, true
+}
+
+// truncateRunes returns at most n runes of s.
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// fade multiplies the alpha of c by alpha.
+func fade(c color.NRGBA, alpha float64) color.NRGBA {
+	c.A = uint8(math.Round(float64(c.A) * math.Max(0, math.Min(1, alpha))))
+	return c
+}
+
+// changedBounds returns the smallest rectangle containing every pixel that
+// differs between two images of the same size.
+func changedBounds(prev, cur *image.RGBA) image.Rectangle {
+	b := cur.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X-1, b.Min.Y-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		prevRow := prev.Pix[prev.PixOffset(b.Min.X, y):prev.PixOffset(b.Max.X, y)]
+		curRow := cur.Pix[cur.PixOffset(b.Min.X, y):cur.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(curRow); i += 4 {
+			if prevRow[i] != curRow[i] || prevRow[i+1] != curRow[i+1] || prevRow[i+2] != curRow[i+2] || prevRow[i+3] != curRow[i+3] {
+				x := b.Min.X + i/4
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+	}
+	if maxX < minX {
+		return image.Rectangle{}
+	}
+	return image.Rect(minX, minY, maxX+1, maxY+1)
+}
+
+// EncodeAnimatedGIF writes frames as a GIF with one palette shared by all
+// frames, looping unless once is set. Frames after the first only store the
+// region that changed. Seed colors are kept exactly as in EncodeGIF.
+func EncodeAnimatedGIF(w io.Writer, frames []AnimationFrame, once bool, seed ...color.Color) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+	images := make([]*image.RGBA, len(frames))
+	for i, frame := range frames {
+		images[i] = frame.Image
+	}
+	mapper := newPaletteMapper(medianCutPalette(images, 256, seed...))
+	bounds := frames[0].Image.Bounds()
+
+	anim := &gif.GIF{
+		Config: image.Config{ColorModel: mapper.palette, Width: bounds.Dx(), Height: bounds.Dy()},
+	}
+	if once {
+		anim.LoopCount = -1
+	}
+	for i, frame := range frames {
+		region := bounds
+		if i > 0 {
+			region = changedBounds(frames[i-1].Image, frame.Image)
+			if region.Empty() {
+				// GIF needs at least one pixel per frame.
+				region = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+1, bounds.Min.Y+1)
+			}
+		}
+		anim.Image = append(anim.Image, mapper.paletted(frame.Image, region))
+		anim.Delay = append(anim.Delay, max(2, int(math.Round(frame.Delay.Seconds()*100))))
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+	}
+	return gif.EncodeAll(w, anim)
+}
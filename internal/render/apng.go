@@ -0,0 +1,197 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// EncodeAPNG writes frames as an animated PNG, looping unless once is set.
+// Frames after the first only store the region that changed and are drawn
+// over the previous frame.
+func EncodeAPNG(w io.Writer, frames []AnimationFrame, once bool) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+	bounds := frames[0].Image.Bounds()
+	opaque := true
+	for _, frame := range frames {
+		opaque = opaque && frame.Image.Opaque()
+	}
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(bounds.Dy()))
+	ihdr[8] = 8
+	ihdr[9] = 6 // truecolor with alpha
+	if opaque {
+		ihdr[9] = 2 // truecolor
+	}
+	writePNGChunk(&out, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+	if once {
+		binary.BigEndian.PutUint32(actl[4:], 1)
+	}
+	writePNGChunk(&out, "acTL", actl)
+
+	sequence := uint32(0)
+	for i, frame := range frames {
+		region := bounds
+		if i > 0 {
+			region = changedBounds(frames[i-1].Image, frame.Image)
+			if region.Empty() {
+				region = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+1, bounds.Min.Y+1)
+			}
+		}
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], sequence)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(region.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(region.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(region.Min.X-bounds.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(region.Min.Y-bounds.Min.Y))
+		delay := min(math.MaxUint16, int(math.Round(frame.Delay.Seconds()*1000)))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(delay))
+		binary.BigEndian.PutUint16(fctl[22:], 1000)
+		// dispose_op 0 (none); blend_op 0 (source) replaces the region.
+		writePNGChunk(&out, "fcTL", fctl)
+		sequence++
+
+		data, err := pngImageData(frame.Image, region, !opaque)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			writePNGChunk(&out, "IDAT", data)
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, sequence)
+		writePNGChunk(&out, "fdAT", append(fdat, data...))
+		sequence++
+	}
+	writePNGChunk(&out, "IEND", nil)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], kind)
+	w.Write(header[:])
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+// pngImageData returns the compressed scanlines of the r part of img, as
+// 8-bit RGB or RGBA. Each row uses the filter with the smallest sum of
+// absolute values, the usual heuristic for photos and UI alike.
+func pngImageData(img *image.RGBA, r image.Rectangle, alpha bool) ([]byte, error) {
+	bpp := 3
+	if alpha {
+		bpp = 4
+	}
+	rowLen := r.Dx() * bpp
+	prev := make([]byte, rowLen)
+	cur := make([]byte, rowLen)
+	filtered := make([][]byte, 5)
+	for i := range filtered {
+		filtered[i] = make([]byte, rowLen+1)
+		filtered[i][0] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			rgb := unpremultiply(p[:4])
+			o := (x - r.Min.X) * bpp
+			copy(cur[o:], rgb[:])
+			if alpha {
+				cur[o+3] = p[3]
+			}
+		}
+		best, bestSum := 0, -1
+		for f := range filtered {
+			sum := pngFilter(filtered[f][1:], cur, prev, bpp, f)
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = f, sum
+			}
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		prev, cur = cur, prev
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pngFilter applies filter type f to a row and returns the sum of the
+// filtered bytes taken as signed values.
+func pngFilter(dst, cur, prev []byte, bpp int, f int) int {
+	sum := 0
+	for i := range cur {
+		var left, upLeft byte
+		if i >= bpp {
+			left, upLeft = cur[i-bpp], prev[i-bpp]
+		}
+		up := prev[i]
+		var predictor byte
+		switch f {
+		case 1:
+			predictor = left
+		case 2:
+			predictor = up
+		case 3:
+			predictor = byte((int(left) + int(up)) / 2)
+		case 4:
+			predictor = paeth(left, up, upLeft)
+		}
+		dst[i] = cur[i] - predictor
+		sum += int(math.Abs(float64(int8(dst[i]))))
+	}
+	return sum
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -18,23 +18,41 @@ func avatarDataURI(pathOrURL string) (string, error) {
 	if strings.HasPrefix(pathOrURL, "data:") {
 		return pathOrURL, nil
 	}
+	data, contentType, err := readAsset(pathOrURL)
+	if err != nil {
+		return "", err
+	}
+	return encodeDataURI(data, contentType), nil
+}
+
+func encodeDataURI(data []byte, contentType string) string {
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return fmt.Sprintf("data:%s;base64,%s", contentType, encoded)
+}
+
+// readAsset reads a local file or fetches a URL, returning the content
+// type reported by the server or guessed from the extension.
+func readAsset(pathOrURL string) ([]byte, string, error) {
 	var reader io.ReadCloser
 	var contentType string
 	if strings.HasPrefix(pathOrURL, "http://") || strings.HasPrefix(pathOrURL, "https://") {
 		resp, err := http.Get(pathOrURL)
 		if err != nil {
-			return "", err
+			return nil, "", err
 		}
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 			resp.Body.Close()
-			return "", fmt.Errorf("failed to fetch icon: %s", resp.Status)
+			return nil, "", fmt.Errorf("failed to fetch icon: %s", resp.Status)
 		}
 		reader = resp.Body
 		contentType = resp.Header.Get("Content-Type")
 	} else {
 		file, err := os.Open(pathOrURL)
 		if err != nil {
-			return "", err
+			return nil, "", err
 		}
 		reader = file
 		contentType = contentTypeFromExt(pathOrURL)
@@ -43,13 +61,9 @@ func avatarDataURI(pathOrURL string) (string, error) {
 
 	data, err := io.ReadAll(reader)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
-	if contentType == "" {
-		contentType = http.DetectContentType(data)
-	}
-	encoded := base64.StdEncoding.EncodeToString(data)
-	return fmt.Sprintf("data:%s;base64,%s", contentType, encoded), nil
+	return data, contentType, nil
 }
 
 func contentTypeFromExt(path string) string {
@@ -0,0 +1,137 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// Target is one output of RenderToWriters.
+type Target struct {
+	Writer io.Writer
+	Format string
+}
+
+// RenderToWriters renders the card in every target's format. The fonts,
+// the avatar and the layout are loaded once and shared by all backends.
+func RenderToWriters(targets []Target, data TweetData, opts RenderOptions) error {
+	cache := newRenderCache()
+	defer cache.Close()
+	opts.cache = cache
+	for _, target := range targets {
+		if err := RenderToWriter(target.Writer, data, opts, target.Format); err != nil {
+			return fmt.Errorf("%s: %w", normalizeFormat(target.Format), err)
+		}
+	}
+	return nil
+}
+
+// renderCache keeps what the backends load for a card so several formats
+// can be rendered from one load. It travels in RenderOptions.cache; a nil
+// cache loads everything afresh. It is not safe for concurrent use.
+type renderCache struct {
+	fonts   map[string]FontSet
+	assets  map[string]cachedAsset
+	images  map[string]cachedImage
+	layouts map[string]Layout
+}
+
+type cachedAsset struct {
+	data        []byte
+	contentType string
+	err         error
+}
+
+type cachedImage struct {
+	img image.Image
+	err error
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{
+		fonts:   map[string]FontSet{},
+		assets:  map[string]cachedAsset{},
+		images:  map[string]cachedImage{},
+		layouts: map[string]Layout{},
+	}
+}
+
+// Close releases the cached fonts.
+func (c *renderCache) Close() {
+	for _, fonts := range c.fonts {
+		fonts.shared = false
+		fonts.Close()
+	}
+}
+
+// fontSet returns the fonts for opts. The returned set is owned by the
+// cache, so closing it has no effect.
+func (c *renderCache) fontSet(opts RenderOptions) (FontSet, error) {
+	key := opts.FontPath + "\x00" + opts.BoldFontPath
+	if fonts, ok := c.fonts[key]; ok {
+		return fonts, nil
+	}
+	fonts, err := readFontSet(opts)
+	if err != nil {
+		return FontSet{}, err
+	}
+	fonts.shared = true
+	c.fonts[key] = fonts
+	return fonts, nil
+}
+
+// layout returns the layout of data, computing it once per data and
+// options.
+func (c *renderCache) layout(data TweetData, opts RenderOptions, fonts FontSet) Layout {
+	key, err := json.Marshal(Spec{Data: data, Options: opts})
+	if err != nil {
+		return layoutCard(data, opts, fonts)
+	}
+	if layout, ok := c.layouts[string(key)]; ok {
+		return layout
+	}
+	layout := layoutCard(data, opts, fonts)
+	c.layouts[string(key)] = layout
+	return layout
+}
+
+func (c *renderCache) asset(pathOrURL string) ([]byte, string, error) {
+	if cached, ok := c.assets[pathOrURL]; ok {
+		return cached.data, cached.contentType, cached.err
+	}
+	data, contentType, err := readAsset(pathOrURL)
+	c.assets[pathOrURL] = cachedAsset{data: data, contentType: contentType, err: err}
+	return data, contentType, err
+}
+
+// avatarDataURI is avatarDataURI reading through the cache.
+func (c *renderCache) avatarDataURI(pathOrURL string) (string, error) {
+	pathOrURL = strings.TrimSpace(pathOrURL)
+	if c == nil || pathOrURL == "" || strings.HasPrefix(pathOrURL, "data:") {
+		return avatarDataURI(pathOrURL)
+	}
+	data, contentType, err := c.asset(pathOrURL)
+	if err != nil {
+		return "", err
+	}
+	return encodeDataURI(data, contentType), nil
+}
+
+// loadImage is loadImage reading and decoding through the cache.
+func (c *renderCache) loadImage(pathOrURL string) (image.Image, error) {
+	if c == nil {
+		return loadImage(pathOrURL)
+	}
+	if cached, ok := c.images[pathOrURL]; ok {
+		return cached.img, cached.err
+	}
+	data, _, err := c.asset(pathOrURL)
+	var img image.Image
+	if err == nil {
+		img, err = decodeImage(data)
+	}
+	c.images[pathOrURL] = cachedImage{img: img, err: err}
+	return img, err
+}
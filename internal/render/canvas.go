@@ -0,0 +1,411 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	imagedraw "image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/fogleman/gg"
+	xdraw "golang.org/x/image/draw"
+)
+
+// CanvasOptions places the card centered on a larger background, scaled to
+// fit. The canvas is disabled while Width or Height is 0.
+type CanvasOptions struct {
+	Width  int
+	Height int
+	// Background is a hex color, comma-separated hex colors for a linear
+	// gradient, "transparent", or an image path or URL (drawn to cover).
+	Background string
+	// Angle is the gradient direction in degrees, as in CSS linear-gradient.
+	Angle float64
+	// Margin is the minimum space around the card. 0 uses 8% of the
+	// shorter canvas side.
+	Margin   int
+	NoShadow bool
+}
+
+// Enabled reports whether the card should be placed on a canvas.
+func (c CanvasOptions) Enabled() bool {
+	return c.Width > 0 && c.Height > 0
+}
+
+// canvasPresets are the named canvas sizes.
+var canvasPresets = map[string][2]int{
+	"og":     {1200, 630},
+	"square": {1080, 1080},
+	"story":  {1080, 1920},
+}
+
+const (
+	defaultCanvasBackground = "#F7F9F9"
+	canvasShadowOffset      = 12.0
+	canvasShadowBlur        = 16.0
+	canvasShadowAlpha       = 0.25
+)
+
+// ParseCanvasSize resolves a preset name (og, square, story) or a custom
+// WIDTHxHEIGHT size.
+func ParseCanvasSize(value string) (int, int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if size, ok := canvasPresets[value]; ok {
+		return size[0], size[1], nil
+	}
+	parts := strings.Split(value, "x")
+	if len(parts) == 2 {
+		width, errW := strconv.Atoi(parts[0])
+		height, errH := strconv.Atoi(parts[1])
+		if errW == nil && errH == nil && width > 0 && height > 0 {
+			return width, height, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unknown canvas size: %s (use og, square, story or WIDTHxHEIGHT)", value)
+}
+
+// canvasBackground is a parsed CanvasOptions.Background.
+type canvasBackground struct {
+	Colors []string
+	Image  string
+}
+
+func parseCanvasBackground(value string) (canvasBackground, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return canvasBackground{Colors: []string{defaultCanvasBackground}}, nil
+	case strings.EqualFold(value, "transparent"):
+		return canvasBackground{}, nil
+	case strings.HasPrefix(value, "#"):
+		var colors []string
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if _, err := parseHexColor(part); err != nil {
+				return canvasBackground{}, err
+			}
+			colors = append(colors, part)
+		}
+		return canvasBackground{Colors: colors}, nil
+	default:
+		return canvasBackground{Image: value}, nil
+	}
+}
+
+// canvasPlacement returns the card scale and its top-left position on the
+// canvas, in logical canvas pixels.
+func canvasPlacement(canvas CanvasOptions, cardWidth int, cardHeight int) (float64, float64, float64) {
+	margin := float64(canvas.Margin)
+	if canvas.Margin <= 0 {
+		margin = math.Round(float64(min(canvas.Width, canvas.Height)) * 0.08)
+	}
+	availableWidth := math.Max(1, float64(canvas.Width)-2*margin)
+	availableHeight := math.Max(1, float64(canvas.Height)-2*margin)
+	fit := math.Min(availableWidth/float64(cardWidth), availableHeight/float64(cardHeight))
+	x := (float64(canvas.Width) - float64(cardWidth)*fit) / 2
+	y := (float64(canvas.Height) - float64(cardHeight)*fit) / 2
+	return fit, x, y
+}
+
+// gradientLine returns the start and end of a CSS-style gradient line for
+// a box, so PNG and SVG match linear-gradient(<angle>deg, ...).
+func gradientLine(width, height, angle float64) (x0, y0, x1, y1 float64) {
+	rad := angle * math.Pi / 180
+	dx, dy := math.Sin(rad), -math.Cos(rad)
+	half := (math.Abs(width*dx) + math.Abs(height*dy)) / 2
+	cx, cy := width/2, height/2
+	return cx - dx*half, cy - dy*half, cx + dx*half, cy + dy*half
+}
+
+// cardSize measures the card without drawing it.
+func cardSize(data TweetData, opts RenderOptions) (int, int, error) {
+	fonts, err := loadFontSet(opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fonts.Close()
+	layout := computeLayout(data, opts, fonts)
+	return layout.Width, layout.Height, nil
+}
+
+// canvasCardOptions returns the options for the card drawn on a canvas.
+func canvasCardOptions(opts RenderOptions) RenderOptions {
+	card := opts
+	card.Canvas = CanvasOptions{}
+	card.Transparent = true
+	card.EmbedSpec = false
+	return card
+}
+
+func renderCanvasImage(data TweetData, opts RenderOptions) (*image.RGBA, error) {
+	canvas := opts.Canvas
+	background, err := parseCanvasBackground(canvas.Background)
+	if err != nil {
+		return nil, err
+	}
+	cardWidth, cardHeight, err := cardSize(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	fit, x, y := canvasPlacement(canvas, cardWidth, cardHeight)
+	cardOpts := canvasCardOptions(opts)
+	cardOpts.Scale = opts.Scale * fit
+	card, err := renderCardImage(data, cardOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := opts.Scale
+	width := int(math.Ceil(float64(canvas.Width) * scale))
+	height := int(math.Ceil(float64(canvas.Height) * scale))
+	ctx := gg.NewContext(width, height)
+	if err := drawCanvasBackground(ctx, background, canvas.Angle, opts.cache); err != nil {
+		return nil, err
+	}
+
+	cardX, cardY := int(math.Round(x*scale)), int(math.Round(y*scale))
+	if !canvas.NoShadow {
+		corner := cardCornerRadius(opts, cardHeight) * fit * scale
+		drawCardShadow(ctx, cardX, cardY, card.Bounds().Dx(), card.Bounds().Dy(), corner, scale)
+	}
+	ctx.DrawImage(card, cardX, cardY)
+
+	img := ctx.Image()
+	rgba := image.NewRGBA(img.Bounds())
+	imagedraw.Draw(rgba, img.Bounds(), img, image.Point{}, imagedraw.Src)
+	return rgba, nil
+}
+
+func drawCanvasBackground(ctx *gg.Context, background canvasBackground, angle float64, cache *renderCache) error {
+	width, height := float64(ctx.Width()), float64(ctx.Height())
+	switch {
+	case background.Image != "":
+		img, err := cache.loadImage(background.Image)
+		if err != nil {
+			return fmt.Errorf("failed to load canvas background: %w", err)
+		}
+		dst := ctx.Image().(*image.RGBA)
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, coverRect(img.Bounds(), dst.Bounds()), xdraw.Src, nil)
+	case len(background.Colors) == 1:
+		c, _ := parseHexColor(background.Colors[0])
+		ctx.SetColor(c)
+		ctx.Clear()
+	case len(background.Colors) > 1:
+		gradient := gg.NewLinearGradient(gradientLine(width, height, angle))
+		for i, hex := range background.Colors {
+			c, _ := parseHexColor(hex)
+			gradient.AddColorStop(float64(i)/float64(len(background.Colors)-1), c)
+		}
+		ctx.SetFillStyle(gradient)
+		ctx.DrawRectangle(0, 0, width, height)
+		ctx.Fill()
+	}
+	return nil
+}
+
+// coverRect returns the centered part of src with the aspect ratio of dst,
+// like CSS background-size: cover.
+func coverRect(src image.Rectangle, dst image.Rectangle) image.Rectangle {
+	scale := math.Max(float64(dst.Dx())/float64(src.Dx()), float64(dst.Dy())/float64(src.Dy()))
+	w := int(math.Round(float64(dst.Dx()) / scale))
+	h := int(math.Round(float64(dst.Dy()) / scale))
+	x := src.Min.X + (src.Dx()-w)/2
+	y := src.Min.Y + (src.Dy()-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// drawCardShadow draws a blurred rounded rectangle below the card.
+func drawCardShadow(ctx *gg.Context, x, y, width, height int, corner float64, scale float64) {
+	shape := gg.NewContext(ctx.Width(), ctx.Height())
+	shape.DrawRoundedRectangle(float64(x), float64(y)+canvasShadowOffset*scale, float64(width), float64(height), corner)
+	shape.SetColor(color.Black)
+	shape.Fill()
+
+	src := shape.Image().(*image.RGBA)
+	mask := image.NewAlpha(src.Bounds())
+	for i := range mask.Pix {
+		mask.Pix[i] = src.Pix[i*4+3]
+	}
+	radius := int(math.Round(canvasShadowBlur * scale))
+	for pass := 0; pass < 3; pass++ {
+		boxBlurAlpha(mask, radius)
+	}
+	shadow := image.NewUniform(color.NRGBA{A: uint8(math.Round(255 * canvasShadowAlpha))})
+	dst := ctx.Image().(*image.RGBA)
+	imagedraw.DrawMask(dst, dst.Bounds(), shadow, image.Point{}, mask, image.Point{}, imagedraw.Over)
+}
+
+// boxBlurAlpha blurs an alpha mask in place with a box of the given radius.
+// Three passes approximate a Gaussian with a standard deviation of radius.
+func boxBlurAlpha(mask *image.Alpha, radius int) {
+	if radius <= 0 {
+		return
+	}
+	w, h := mask.Rect.Dx(), mask.Rect.Dy()
+	line := make([]int, max(w, h))
+	blur := func(get func(int) int, set func(int, uint8), n int) {
+		for i := 0; i < n; i++ {
+			line[i] = get(i)
+		}
+		sum := 0
+		for i := -radius; i <= radius; i++ {
+			if i >= 0 && i < n {
+				sum += line[i]
+			}
+		}
+		size := 2*radius + 1
+		for i := 0; i < n; i++ {
+			set(i, uint8(sum/size))
+			if out := i - radius; out >= 0 {
+				sum -= line[out]
+			}
+			if in := i + radius + 1; in < n {
+				sum += line[in]
+			}
+		}
+	}
+	for y := 0; y < h; y++ {
+		row := mask.Pix[y*mask.Stride:]
+		blur(func(i int) int { return int(row[i]) }, func(i int, v uint8) { row[i] = v }, w)
+	}
+	for x := 0; x < w; x++ {
+		blur(func(i int) int { return int(mask.Pix[i*mask.Stride+x]) }, func(i int, v uint8) { mask.Pix[i*mask.Stride+x] = v }, h)
+	}
+}
+
+type canvasStop struct {
+	Offset float64
+	Color  string
+}
+
+type canvasSVGView struct {
+	Width        int
+	Height       int
+	Color        string
+	Stops        []canvasStop
+	X1, Y1       float64
+	X2, Y2       float64
+	ImageURI     string
+	Shadow       bool
+	ShadowOffset float64
+	ShadowBlur   float64
+	ShadowAlpha  float64
+	Card         string
+	Title        string
+	Description  string
+	Spec         string
+}
+
+const canvasSVGTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img">
+  <title>{{.Title}}</title>
+  <desc>{{.Description}}</desc>
+  {{if .Spec}}<metadata id="xpostgen-spec">{{.Spec}}</metadata>{{end}}
+  <defs>
+    {{if .Stops}}<linearGradient id="canvas-bg" gradientUnits="userSpaceOnUse" x1="{{.X1}}" y1="{{.Y1}}" x2="{{.X2}}" y2="{{.Y2}}">{{range .Stops}}<stop offset="{{.Offset}}" stop-color="{{.Color}}" />{{end}}</linearGradient>{{end}}
+    {{if .Shadow}}<filter id="card-shadow" x="-20%" y="-20%" width="140%" height="140%"><feDropShadow dx="0" dy="{{.ShadowOffset}}" stdDeviation="{{.ShadowBlur}}" flood-color="#000000" flood-opacity="{{.ShadowAlpha}}" /></filter>{{end}}
+  </defs>
+  {{if .ImageURI}}<image href="{{.ImageURI}}" x="0" y="0" width="{{.Width}}" height="{{.Height}}" preserveAspectRatio="xMidYMid slice" />
+  {{else if .Stops}}<rect width="{{.Width}}" height="{{.Height}}" fill="url(#canvas-bg)" />
+  {{else if .Color}}<rect width="{{.Width}}" height="{{.Height}}" fill="{{.Color}}" />
+  {{end}}
+  <g{{if .Shadow}} filter="url(#card-shadow)"{{end}}>
+{{.Card}}
+  </g>
+</svg>
+`
+
+// renderCanvasSVG nests the card SVG, scaled through its viewBox, inside a
+// canvas-sized SVG.
+func renderCanvasSVG(data TweetData, opts RenderOptions) (string, error) {
+	canvas := opts.Canvas
+	background, err := parseCanvasBackground(canvas.Background)
+	if err != nil {
+		return "", err
+	}
+	cardWidth, cardHeight, err := cardSize(data, opts)
+	if err != nil {
+		return "", err
+	}
+	fit, x, y := canvasPlacement(canvas, cardWidth, cardHeight)
+	card, err := RenderSVG(data, canvasCardOptions(opts))
+	if err != nil {
+		return "", err
+	}
+	card = strings.TrimPrefix(card, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	root := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"`, cardWidth, cardHeight)
+	if !strings.HasPrefix(card, root) {
+		return "", fmt.Errorf("unexpected card svg header")
+	}
+	card = fmt.Sprintf(`<svg x="%.2f" y="%.2f" width="%.2f" height="%.2f"`, x, y, float64(cardWidth)*fit, float64(cardHeight)*fit) + card[len(root):]
+
+	view := canvasSVGView{
+		Width:        canvas.Width,
+		Height:       canvas.Height,
+		Shadow:       !canvas.NoShadow,
+		ShadowOffset: canvasShadowOffset,
+		ShadowBlur:   canvasShadowBlur,
+		ShadowAlpha:  canvasShadowAlpha,
+		Card:         strings.TrimSpace(card),
+		Title:        escapeXML(descriptionAuthor(data, dateLocale(postLang(data)) == "ja")),
+		Description:  escapeXML(Description(data, opts)),
+	}
+	if opts.EmbedSpec {
+		view.Spec, err = specJSON(data, opts)
+		if err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case background.Image != "":
+		view.ImageURI, err = opts.cache.avatarDataURI(background.Image)
+		if err != nil {
+			return "", fmt.Errorf("failed to load canvas background: %w", err)
+		}
+	case len(background.Colors) == 1:
+		view.Color = background.Colors[0]
+	case len(background.Colors) > 1:
+		x1, y1, x2, y2 := gradientLine(float64(canvas.Width), float64(canvas.Height), canvas.Angle)
+		view.X1, view.Y1 = math.Round(x1*100)/100, math.Round(y1*100)/100
+		view.X2, view.Y2 = math.Round(x2*100)/100, math.Round(y2*100)/100
+		for i, hex := range background.Colors {
+			view.Stops = append(view.Stops, canvasStop{Offset: float64(i) / float64(len(background.Colors)-1), Color: hex})
+		}
+	}
+
+	tmpl, err := template.New("canvas").Parse(canvasSVGTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// canvasCSSBackground returns the CSS background for the HTML canvas.
+func canvasCSSBackground(canvas CanvasOptions, cache *renderCache) (string, error) {
+	background, err := parseCanvasBackground(canvas.Background)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case background.Image != "":
+		uri, err := cache.avatarDataURI(background.Image)
+		if err != nil {
+			return "", fmt.Errorf("failed to load canvas background: %w", err)
+		}
+		return fmt.Sprintf("url(%q) center / cover no-repeat", uri), nil
+	case len(background.Colors) == 1:
+		return background.Colors[0], nil
+	case len(background.Colors) > 1:
+		return fmt.Sprintf("linear-gradient(%sdeg, %s)", strconv.FormatFloat(canvas.Angle, 'f', -1, 64), strings.Join(background.Colors, ", ")), nil
+	default:
+		return "transparent", nil
+	}
+}
@@ -0,0 +1,232 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"text/template"
+)
+
+// CollageOptions controls RenderCollage and RenderCollageSVG.
+type CollageOptions struct {
+	// Columns is the number of columns (3 when zero).
+	Columns int
+	// Gap is the space between the cards and around them in pixels. 0
+	// leaves none and negative values pick the default.
+	Gap int
+	// Background is "#RRGGBB" or "transparent"; empty uses the theme's
+	// divider color.
+	Background string
+}
+
+// DefaultCollageOptions returns the default collage layout.
+func DefaultCollageOptions() CollageOptions {
+	return CollageOptions{Columns: 3, Gap: 24}
+}
+
+func normalizeCollage(collage CollageOptions) CollageOptions {
+	def := DefaultCollageOptions()
+	if collage.Columns <= 0 {
+		collage.Columns = def.Columns
+	}
+	if collage.Gap < 0 {
+		collage.Gap = def.Gap
+	}
+	return collage
+}
+
+// collageBackground returns the background color, or nil when it is
+// transparent.
+func collageBackground(collage CollageOptions, theme Theme) (color.Color, error) {
+	background := strings.TrimSpace(collage.Background)
+	switch {
+	case strings.EqualFold(background, "transparent"):
+		return nil, nil
+	case background == "":
+		background = theme.Divider
+	}
+	c, err := parseHexColor(background)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collage background: %s", collage.Background)
+	}
+	return c, nil
+}
+
+// masonry places boxes of the given sizes in columns as wide as the
+// widest box. Each box goes into the shortest column, leftmost first, so
+// cards of different heights pack tightly while keeping their order. It
+// returns the top-left corner of every box and the total size, with gap
+// between the boxes and around them.
+func masonry(sizes []image.Point, columns int, gap int) ([]image.Point, image.Point) {
+	columns = min(columns, len(sizes))
+	columnWidth := 0
+	for _, size := range sizes {
+		columnWidth = max(columnWidth, size.X)
+	}
+	heights := make([]int, columns)
+	positions := make([]image.Point, len(sizes))
+	for i, size := range sizes {
+		column := 0
+		for c := range heights {
+			if heights[c] < heights[column] {
+				column = c
+			}
+		}
+		// Narrower cards (tight width mode) are centered in the column.
+		x := gap + column*(columnWidth+gap) + (columnWidth-size.X)/2
+		positions[i] = image.Pt(x, gap+heights[column])
+		heights[column] += size.Y + gap
+	}
+	height := 0
+	for _, h := range heights {
+		height = max(height, h)
+	}
+	return positions, image.Pt(gap+columns*(columnWidth+gap), gap+height)
+}
+
+// collageCardOptions returns the options for the cards of a collage:
+// transparent outside the rounded corners, without canvas, animation or
+// embedded spec.
+func collageCardOptions(opts RenderOptions) RenderOptions {
+	card := canvasCardOptions(normalizeOptions(opts))
+	card.Animation = AnimationOptions{}
+	return card
+}
+
+// RenderCollage renders every post with RenderImage and packs the cards
+// masonry-style into one image.
+func RenderCollage(posts []TweetData, opts RenderOptions, collage CollageOptions) (*image.RGBA, error) {
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("collage has no posts")
+	}
+	collage = normalizeCollage(collage)
+	opts = collageCardOptions(opts)
+	background, err := collageBackground(collage, opts.Theme)
+	if err != nil {
+		return nil, err
+	}
+	if opts.cache == nil {
+		opts.cache = newRenderCache()
+		defer opts.cache.Close()
+	}
+
+	cards := make([]*image.RGBA, len(posts))
+	sizes := make([]image.Point, len(posts))
+	for i, post := range posts {
+		card, err := RenderImage(post, opts)
+		if err != nil {
+			return nil, fmt.Errorf("post %d: %w", i+1, err)
+		}
+		cards[i], sizes[i] = card, card.Bounds().Size()
+	}
+	gap := int(math.Round(float64(collage.Gap) * opts.Scale))
+	positions, size := masonry(sizes, collage.Columns, gap)
+
+	out := image.NewRGBA(image.Rectangle{Max: size})
+	if background != nil {
+		draw.Draw(out, out.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	}
+	for i, card := range cards {
+		draw.Draw(out, card.Bounds().Sub(card.Bounds().Min).Add(positions[i]), card, card.Bounds().Min, draw.Over)
+	}
+	return out, nil
+}
+
+const collageSVGTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img">
+  <title>{{.Title}}</title>
+  {{if .Background}}<rect width="{{.Width}}" height="{{.Height}}" fill="{{.Background}}" />{{end}}
+  {{range .Cards}}{{.}}
+  {{end}}
+</svg>
+`
+
+type collageSVGView struct {
+	Width      int
+	Height     int
+	Title      string
+	Background string
+	Cards      []string
+}
+
+// RenderCollageSVG packs the cards like RenderCollage, nesting the SVG of
+// every post in one document.
+func RenderCollageSVG(posts []TweetData, opts RenderOptions, collage CollageOptions) (string, error) {
+	if len(posts) == 0 {
+		return "", fmt.Errorf("collage has no posts")
+	}
+	collage = normalizeCollage(collage)
+	opts = collageCardOptions(opts)
+	background, err := collageBackground(collage, opts.Theme)
+	if err != nil {
+		return "", err
+	}
+	if opts.cache == nil {
+		opts.cache = newRenderCache()
+		defer opts.cache.Close()
+	}
+
+	cards := make([]string, len(posts))
+	sizes := make([]image.Point, len(posts))
+	for i, post := range posts {
+		width, height, err := cardSize(post, opts)
+		if err != nil {
+			return "", fmt.Errorf("post %d: %w", i+1, err)
+		}
+		card, err := RenderSVG(post, opts)
+		if err != nil {
+			return "", fmt.Errorf("post %d: %w", i+1, err)
+		}
+		card = strings.TrimPrefix(card, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+		root := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"`, width, height)
+		if !strings.HasPrefix(card, root) {
+			return "", fmt.Errorf("unexpected card svg header")
+		}
+		// Ids must be unique across the nested cards.
+		prefix := fmt.Sprintf("post-%d-", i+1)
+		card = strings.ReplaceAll(card, `id="avatar-clip"`, `id="`+prefix+`avatar-clip"`)
+		card = strings.ReplaceAll(card, `"url(#avatar-clip)"`, `"url(#`+prefix+`avatar-clip)"`)
+		cards[i] = strings.TrimSpace(card)[len(root):]
+		sizes[i] = image.Pt(width, height)
+	}
+	positions, size := masonry(sizes, collage.Columns, collage.Gap)
+	for i, card := range cards {
+		cards[i] = fmt.Sprintf(`<svg x="%d" y="%d" width="%d" height="%d"`, positions[i].X, positions[i].Y, sizes[i].X, sizes[i].Y) + card
+	}
+
+	view := collageSVGView{
+		Width:  size.X,
+		Height: size.Y,
+		Title:  escapeXML(collageTitle(posts)),
+		Cards:  cards,
+	}
+	if background != nil {
+		c := color.NRGBAModel.Convert(background).(color.NRGBA)
+		view.Background = fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+	}
+	tmpl, err := template.New("collage").Parse(collageSVGTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// collageTitle names the collage by its number of posts, in the language
+// of the first one.
+func collageTitle(posts []TweetData) string {
+	if dateLocale(postLang(posts[0])) == "ja" {
+		return fmt.Sprintf("%d件の投稿", len(posts))
+	}
+	if len(posts) == 1 {
+		return "1 post"
+	}
+	return fmt.Sprintf("%d posts", len(posts))
+}
@@ -0,0 +1,108 @@
+package render
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeNow is replaced in tests to pin relative timestamps.
+var timeNow = time.Now
+
+// ParseTimestamp accepts an RFC3339 timestamp or Unix time in seconds
+// (or milliseconds when the value has 13 or more digits).
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if len(strings.TrimPrefix(value, "-")) >= 13 {
+			return time.UnixMilli(unix), nil
+		}
+		return time.Unix(unix, 0), nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC3339 or unix seconds", value)
+	}
+	return parsed, nil
+}
+
+// formatPostTime renders t the way X shows post timestamps, using the
+// absolute style ("10:55 AM · Dec 6, 2017") or the relative timeline style
+// ("2h", "Mar 5").
+func formatPostTime(t time.Time, lang string, opts RenderOptions) string {
+	if opts.TimeZone != "" {
+		if loc, err := time.LoadLocation(opts.TimeZone); err == nil {
+			t = t.In(loc)
+		}
+	}
+	if strings.EqualFold(opts.DateStyle, "relative") {
+		return formatRelativeTime(t, timeNow().In(t.Location()), lang)
+	}
+	return formatAbsoluteTime(t, lang)
+}
+
+func formatAbsoluteTime(t time.Time, lang string) string {
+	switch dateLocale(lang) {
+	case "ja":
+		period := "午前"
+		if t.Hour() >= 12 {
+			period = "午後"
+		}
+		return fmt.Sprintf("%s%d:%02d · %s", period, t.Hour()%12, t.Minute(), t.Format("2006年1月2日"))
+	default:
+		return t.Format("3:04 PM · Jan 2, 2006")
+	}
+}
+
+func formatRelativeTime(t time.Time, now time.Time, lang string) string {
+	elapsed := now.Sub(t)
+	if elapsed < 0 {
+		return formatAbsoluteTime(t, lang)
+	}
+	ja := dateLocale(lang) == "ja"
+	switch {
+	case elapsed < time.Minute:
+		if ja {
+			return fmt.Sprintf("%d秒", int(elapsed.Seconds()))
+		}
+		return fmt.Sprintf("%ds", int(elapsed.Seconds()))
+	case elapsed < time.Hour:
+		if ja {
+			return fmt.Sprintf("%d分", int(elapsed.Minutes()))
+		}
+		return fmt.Sprintf("%dm", int(elapsed.Minutes()))
+	case elapsed < 24*time.Hour:
+		if ja {
+			return fmt.Sprintf("%d時間", int(elapsed.Hours()))
+		}
+		return fmt.Sprintf("%dh", int(elapsed.Hours()))
+	}
+
+	sameYear := t.Year() == now.Year()
+	switch {
+	case ja && sameYear:
+		return t.Format("1月2日")
+	case ja:
+		return t.Format("2006年1月2日")
+	case sameYear:
+		return t.Format("Jan 2")
+	default:
+		return t.Format("Jan 2, 2006")
+	}
+}
+
+// dateLocale reduces a BCP 47 tag such as "ja-JP" to a supported locale.
+func dateLocale(lang string) string {
+	base := strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(base, "-_"); i >= 0 {
+		base = base[:i]
+	}
+	if base == "ja" {
+		return "ja"
+	}
+	return "en"
+}
@@ -0,0 +1,66 @@
+package render
+
+import (
+	"strings"
+)
+
+// Description returns a plain-text summary of the card for alt text and
+// accessible names, such as
+// "Post by jack @jack: just setting up my twttr · 9:50 PM · Mar 21, 2006 · 262K likes".
+// The wording follows the language of the post; the date, location and
+// likes are left out in simple mode, as on the card.
+func Description(data TweetData, opts RenderOptions) string {
+	opts = normalizeOptions(opts)
+	ja := dateLocale(postLang(data)) == "ja"
+	author := descriptionAuthor(data, ja)
+	parts := []string{}
+	if text := strings.Join(strings.Fields(data.Text), " "); text != "" {
+		parts = append(parts, text)
+	}
+	if !data.Simple {
+		if date := buildDateLine(data, opts); date != "" {
+			parts = append(parts, date)
+		}
+		likes := strings.TrimSpace(data.LikeCount)
+		if likes == "" {
+			likes = "0"
+		}
+		if ja {
+			parts = append(parts, "いいね"+likes+"件")
+		} else if likes == "1" {
+			parts = append(parts, "1 like")
+		} else {
+			parts = append(parts, likes+" likes")
+		}
+	}
+	return author + ": " + strings.Join(parts, " · ")
+}
+
+// descriptionAuthor is the short form of Description, used where the
+// description needs a title.
+func descriptionAuthor(data TweetData, ja bool) string {
+	author := strings.TrimSpace(data.Name) + " " + normalizeHandle(data.Handle)
+	if data.Verified {
+		if ja {
+			author += " (認証済み)"
+		} else {
+			author += " (verified)"
+		}
+	}
+	if ja {
+		return author + " の投稿"
+	}
+	return "Post by " + author
+}
+
+// postLang returns the BCP 47 language of the post: TweetData.Lang when
+// set, "ja" for text with Japanese characters, and "en" otherwise.
+func postLang(data TweetData) string {
+	if lang := strings.TrimSpace(data.Lang); lang != "" {
+		return lang
+	}
+	if containsJapanese(data.Text) {
+		return "ja"
+	}
+	return "en"
+}
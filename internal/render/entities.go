@@ -0,0 +1,144 @@
+package render
+
+import (
+	"sort"
+
+	"golang.org/x/image/font"
+)
+
+// TextRun is a piece of a wrapped text line drawn in a single style.
+type TextRun struct {
+	Text   string
+	X      float64
+	Entity bool
+	// URL is the link of the entity the run belongs to, if any.
+	URL string
+}
+
+// entityMask reports, for every rune of text, whether it belongs to an entity.
+func entityMask(text string, entities []Entity) []bool {
+	mask := make([]bool, len([]rune(text)))
+	for _, entity := range entities {
+		start := entity.Start
+		end := entity.End
+		if start < 0 {
+			start = 0
+		}
+		if end > len(mask) {
+			end = len(mask)
+		}
+		for i := start; i < end; i++ {
+			mask[i] = true
+		}
+	}
+	return mask
+}
+
+// entityURLs returns, for every rune of text, the link of the entity it
+// belongs to. Links that are not safe to follow are left out.
+func entityURLs(text string, entities []Entity) []string {
+	urls := make([]string, len([]rune(text)))
+	for _, entity := range entities {
+		link := safeLinkURL(entity.URL)
+		for i := max(0, entity.Start); i < min(entity.End, len(urls)); i++ {
+			urls[i] = link
+		}
+	}
+	return urls
+}
+
+// lineOffsets locates each wrapped line inside text and returns its rune
+// offset, or -1 when the line cannot be matched back to the source.
+func lineOffsets(text string, lines []string) []int {
+	source := []rune(text)
+	offsets := make([]int, len(lines))
+	cursor := 0
+	for i, line := range lines {
+		offsets[i] = -1
+		target := []rune(line)
+		if len(target) == 0 {
+			continue
+		}
+		for start := cursor; start+len(target) <= len(source); start++ {
+			if runesEqual(source[start:start+len(target)], target) {
+				offsets[i] = start
+				cursor = start + len(target)
+				break
+			}
+		}
+	}
+	return offsets
+}
+
+func runesEqual(a []rune, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func buildTextRuns(text string, lines []string, entities []Entity, x float64, face font.Face) [][]TextRun {
+	runs := make([][]TextRun, len(lines))
+	if len(entities) == 0 {
+		for i, line := range lines {
+			runs[i] = []TextRun{{Text: line, X: x}}
+		}
+		return runs
+	}
+
+	mask := entityMask(text, entities)
+	urls := entityURLs(text, entities)
+	offsets := lineOffsets(text, lines)
+	for i, line := range lines {
+		lineRunes := []rune(line)
+		if offsets[i] < 0 || len(lineRunes) == 0 {
+			runs[i] = []TextRun{{Text: line, X: x}}
+			continue
+		}
+		start := 0
+		for start < len(lineRunes) {
+			highlighted := mask[offsets[i]+start]
+			link := urls[offsets[i]+start]
+			end := start + 1
+			for end < len(lineRunes) && mask[offsets[i]+end] == highlighted && urls[offsets[i]+end] == link {
+				end++
+			}
+			runs[i] = append(runs[i], TextRun{
+				Text:   string(lineRunes[start:end]),
+				X:      x + measureString(face, string(lineRunes[:start])),
+				Entity: highlighted,
+				URL:    link,
+			})
+			start = end
+		}
+	}
+	return runs
+}
+
+// normalizeEntities sorts entities and drops empty or overlapping spans.
+func normalizeEntities(text string, entities []Entity) []Entity {
+	if len(entities) == 0 {
+		return nil
+	}
+	length := len([]rune(text))
+	sorted := make([]Entity, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	out := make([]Entity, 0, len(sorted))
+	lastEnd := 0
+	for _, entity := range sorted {
+		if entity.Start < lastEnd || entity.Start >= entity.End || entity.End > length {
+			continue
+		}
+		out = append(out, entity)
+		lastEnd = entity.End
+	}
+	return out
+}
@@ -28,9 +28,25 @@ type FontSet struct {
 	Action   font.Face
 	CTA      font.Face
 	Initials font.Face
+
+	// regular and bold are the fonts the faces were built from, kept for
+	// backends that embed the font program (PDF).
+	regular fontFile
+	bold    fontFile
+	// shared marks fonts owned by a render cache, which closes them.
+	shared bool
+}
+
+// fontFile is a parsed font together with its raw bytes.
+type fontFile struct {
+	Font *opentype.Font
+	Data []byte
 }
 
 func (f FontSet) Close() {
+	if f.shared {
+		return
+	}
 	closeFace(f.Name)
 	closeFace(f.Handle)
 	closeFace(f.Meta)
@@ -46,7 +62,16 @@ func closeFace(face font.Face) {
 	}
 }
 
+// loadFontSet loads the fonts for opts, or takes them from the render
+// cache when there is one.
 func loadFontSet(opts RenderOptions) (FontSet, error) {
+	if opts.cache != nil {
+		return opts.cache.fontSet(opts)
+	}
+	return readFontSet(opts)
+}
+
+func readFontSet(opts RenderOptions) (FontSet, error) {
 	regularFont, err := loadFont(opts.FontPath, goregular.TTF)
 	if err != nil {
 		return FontSet{}, err
@@ -60,31 +85,36 @@ func loadFontSet(opts RenderOptions) (FontSet, error) {
 		return FontSet{}, err
 	}
 
-	nameFace, err := newFace(boldFont, nameFontSize)
+	return newFontSet(regularFont, boldFont, 1)
+}
+
+// newFontSet builds the faces at their sizes multiplied by scale.
+func newFontSet(regularFont fontFile, boldFont fontFile, scale float64) (FontSet, error) {
+	nameFace, err := newFace(boldFont.Font, nameFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
-	handleFace, err := newFace(regularFont, handleFontSize)
+	handleFace, err := newFace(regularFont.Font, handleFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
-	metaFace, err := newFace(regularFont, metaFontSize)
+	metaFace, err := newFace(regularFont.Font, metaFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
-	textFace, err := newFace(regularFont, textFontSize)
+	textFace, err := newFace(regularFont.Font, textFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
-	actionFace, err := newFace(regularFont, actionFontSize)
+	actionFace, err := newFace(regularFont.Font, actionFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
-	ctaFace, err := newFace(boldFont, ctaFontSize)
+	ctaFace, err := newFace(boldFont.Font, ctaFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
-	initialsFace, err := newFace(boldFont, initialsFontSize)
+	initialsFace, err := newFace(boldFont.Font, initialsFontSize*scale)
 	if err != nil {
 		return FontSet{}, err
 	}
@@ -97,22 +127,30 @@ func loadFontSet(opts RenderOptions) (FontSet, error) {
 		Action:   actionFace,
 		CTA:      ctaFace,
 		Initials: initialsFace,
+		regular:  regularFont,
+		bold:     boldFont,
 	}, nil
 }
 
-func loadFont(path string, fallback []byte) (*opentype.Font, error) {
-	if path == "" {
-		return opentype.Parse(fallback)
-	}
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read font: %w", err)
+// scaled returns the same fonts with faces sized for a scaled canvas.
+func (f FontSet) scaled(scale float64) (FontSet, error) {
+	return newFontSet(f.regular, f.bold, scale)
+}
+
+func loadFont(path string, fallback []byte) (fontFile, error) {
+	data := fallback
+	if path != "" {
+		read, err := os.ReadFile(path)
+		if err != nil {
+			return fontFile{}, fmt.Errorf("failed to read font: %w", err)
+		}
+		data = read
 	}
 	parsed, err := opentype.Parse(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse font: %w", err)
+		return fontFile{}, fmt.Errorf("failed to parse font: %w", err)
 	}
-	return parsed, nil
+	return fontFile{Font: parsed, Data: data}, nil
 }
 
 func newFace(otf *opentype.Font, size float64) (font.Face, error) {
@@ -0,0 +1,48 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// GIFOptions controls the GIF encoder.
+type GIFOptions struct {
+	// Dither spreads the quantization error with Floyd–Steinberg
+	// dithering. Gradients, photos and the avatar look smoother; flat
+	// areas stay exact, but files get larger. Animations are never
+	// dithered, since the noise would change between frames.
+	Dither bool
+}
+
+// EncodeGIF writes img as a GIF with a median cut palette of up to 256
+// colors. Seed colors present in the image, such as the theme colors,
+// are kept exactly.
+func EncodeGIF(w io.Writer, img image.Image, opts GIFOptions, seed ...color.Color) error {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	mapper := newPaletteMapper(medianCutPalette([]*image.RGBA{rgba}, 256, seed...))
+	var paletted *image.Paletted
+	if opts.Dither {
+		paletted = mapper.dithered(rgba)
+	} else {
+		paletted = mapper.paletted(rgba, rgba.Bounds())
+	}
+	return gif.Encode(w, paletted, nil)
+}
+
+// themePalette returns the theme colors for seeding GIF palettes.
+func themePalette(theme Theme) []color.Color {
+	var colors []color.Color
+	for _, hex := range []string{theme.Background, theme.Text, theme.Muted, theme.Accent, theme.Border, theme.Divider, theme.AvatarBg, theme.AvatarText} {
+		if c, err := parseHexColor(hex); err == nil {
+			colors = append(colors, c)
+		}
+	}
+	return colors
+}
@@ -2,25 +2,41 @@ package render
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
+	"math"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type htmlView struct {
-	Width         int
-	Padding       int
-	AvatarSize    int
-	Gap           int
-	Name          string
-	Handle        string
-	DateLine      string
+	Width      int
+	Padding    int
+	AvatarSize int
+	Gap        int
+	Name       string
+	Handle     string
+	DateLine   string
+	// DateText and Location make up DateLine; DateTime is the RFC 3339
+	// form of the post time for <time datetime>, if known.
+	DateText      string
+	DateTime      string
+	Location      string
+	Lang          string
+	Title         string
+	Description   string
 	Text          template.HTML
 	CTA           string
 	Verified      bool
 	ShowFooter    bool
 	AvatarDataURI string
 	Initials      string
-	FontFamily    string
+	FontFamily    template.CSS
+	FontFaces     template.CSS
+	PageBg        template.CSS
+	CardBorder    template.CSS
+	CornerRadius  float64
 	Background    string
 	Border        string
 	Divider       string
@@ -33,15 +49,38 @@ type htmlView struct {
 	VerifiedIcon  template.HTML
 	InfoIcon      template.HTML
 	Actions       []htmlAction
+	Canvas        *htmlCanvas
+	ProfileURL    string
+	PermalinkURL  string
+	CTAURL        string
+	// Mode is "page", "fragment" or "component". P prefixes every class
+	// name and Root selects the element that holds the theme variables.
+	Mode string
+	P    string
+	Root template.CSS
+	// HostFontFaces holds the @font-face rules of a component, which only
+	// take effect in the document, not in a shadow root.
+	HostFontFaces template.CSS
+	// Spec is the embedded spec JSON, if any.
+	Spec template.JS
 }
 
-const htmlTemplate = `<!doctype html>
-<html lang="ja">
-<head>
-  <meta charset="utf-8" />
-  <title>X Post Preview</title>
-  <style>
-    :root {
+// htmlCanvas centers the card on a fixed-size background. Shadow lengths are
+// in card pixels, so they come out at the canvas size after scaling.
+type htmlCanvas struct {
+	Width        int
+	Height       int
+	Background   template.CSS
+	Scale        float64
+	Shadow       bool
+	ShadowOffset float64
+	ShadowBlur   float64
+	ShadowAlpha  float64
+}
+
+const htmlTemplate = `{{define "style"}}
+    {{.FontFaces}}
+    {{.Root}} {
       --bg: {{.Background}};
       --border: {{.Border}};
       --divider: {{.Divider}};
@@ -51,32 +90,55 @@ const htmlTemplate = `<!doctype html>
       --avatar-bg: {{.AvatarBg}};
       --avatar-text: {{.AvatarText}};
     }
-    body {
+    {{if eq .Mode "page"}}body {
       margin: 0;
       padding: 0;
-      background: var(--bg);
+      background: {{.PageBg}};
       font-family: {{.FontFamily}};
       color: var(--text);
-    }
-    .card {
+    }{{else}}{{.Root}} {
+      display: block;
+      font-family: {{.FontFamily}};
+      color: var(--text);
+      line-height: normal;
+      letter-spacing: normal;
+      text-align: left;
+    }{{end}}
+    .{{.P}}card {
       width: {{.Width}}px;
       box-sizing: border-box;
       padding: {{.Padding}}px;
-      border: 1.5px solid var(--border);
-      border-radius: 20px;
+      border: {{.CardBorder}};
+      border-radius: {{.CornerRadius}}px;
       background: var(--bg);
     }
-    .header {
+    {{with .Canvas}}
+    .{{$.P}}canvas {
+      width: {{.Width}}px;
+      height: {{.Height}}px;
+      display: flex;
+      align-items: center;
+      justify-content: center;
+      overflow: hidden;
+      background: {{.Background}};
+    }
+    .{{$.P}}canvas .{{$.P}}card {
+      flex: none;
+      transform: scale({{.Scale}});
+      {{if .Shadow}}box-shadow: 0 {{.ShadowOffset}}px {{.ShadowBlur}}px rgba(0, 0, 0, {{.ShadowAlpha}});{{end}}
+    }
+    {{end}}
+    .{{.P}}header {
       display: flex;
       align-items: flex-start;
       justify-content: space-between;
     }
-    .header-left {
+    .{{.P}}header-left {
       display: flex;
       gap: {{.Gap}}px;
       align-items: flex-start;
     }
-    .avatar {
+    .{{.P}}avatar {
       width: {{.AvatarSize}}px;
       height: {{.AvatarSize}}px;
       border-radius: 999px;
@@ -90,34 +152,34 @@ const htmlTemplate = `<!doctype html>
       overflow: hidden;
       flex: none;
     }
-    .avatar img {
+    .{{.P}}avatar img {
       width: 100%;
       height: 100%;
       object-fit: cover;
       display: block;
     }
-    .name-row {
+    .{{.P}}name-row {
       display: flex;
       align-items: center;
       gap: 6px;
     }
-    .name {
+    .{{.P}}name {
       font-size: 28px;
       font-weight: 700;
       line-height: 1.2;
     }
-    .verified {
+    .{{.P}}verified {
       color: var(--accent);
     }
-    .handle {
+    .{{.P}}handle {
       font-size: 22px;
       color: var(--muted);
       margin-top: 4px;
     }
-    .twitter {
+    .{{.P}}twitter {
       color: var(--accent);
     }
-    .text {
+    .{{.P}}text {
       margin-top: 16px;
       font-size: 28px;
       line-height: 1.45;
@@ -125,7 +187,21 @@ const htmlTemplate = `<!doctype html>
       word-break: keep-all;
       overflow-wrap: break-word;
     }
-    .date-row {
+    .{{.P}}entity {
+      color: var(--accent);
+    }
+    a.{{.P}}entity {
+      text-decoration: none;
+    }
+    a.{{.P}}link {
+      display: block;
+      color: inherit;
+      text-decoration: none;
+    }
+    a.{{.P}}entity:hover, a.{{.P}}link:hover .{{.P}}name, a.{{.P}}link:hover .{{.P}}date {
+      text-decoration: underline;
+    }
+    .{{.P}}date-row {
       margin-top: 16px;
       display: flex;
       align-items: center;
@@ -133,44 +209,46 @@ const htmlTemplate = `<!doctype html>
       color: var(--muted);
       font-size: 22px;
     }
-    .divider {
+    .{{.P}}divider {
       margin-top: 12px;
       border-top: 1px solid var(--divider);
     }
-    .actions {
-      margin-top: 14px;
+    .{{.P}}actions {
+      margin: 14px 0 0;
+      padding: 0;
+      list-style: none;
       display: flex;
       gap: 32px;
       align-items: center;
       color: var(--muted);
       font-size: 20px;
     }
-    .action {
+    .{{.P}}action {
       display: inline-flex;
       align-items: center;
       gap: 8px;
     }
-    .icon svg {
+    .{{.P}}icon svg {
       width: 22px;
       height: 22px;
       display: block;
     }
-    .twitter svg {
+    .{{.P}}twitter svg {
       width: 30px;
       height: 30px;
       display: block;
     }
-    .verified svg {
+    .{{.P}}verified svg {
       width: 20px;
       height: 20px;
       display: block;
     }
-    .info svg {
+    .{{.P}}info svg {
       width: 20px;
       height: 20px;
       display: block;
     }
-    .cta {
+    .{{.P}}cta {
       margin-top: 16px;
       height: 44px;
       border-radius: 999px;
@@ -182,55 +260,97 @@ const htmlTemplate = `<!doctype html>
       font-weight: 600;
       font-size: 20px;
     }
-  </style>
-</head>
-<body>
-  <div class="card">
-    <div class="header">
-      <div class="header-left">
-        <div class="avatar">
+{{end}}{{define "card"}}
+  {{if .Canvas}}<div class="{{.P}}canvas">{{end}}
+  <article class="{{.P}}card" lang="{{.Lang}}" aria-label="{{.Title}}">
+    <div class="{{.P}}header">
+      {{if .ProfileURL}}<a class="{{.P}}link" href="{{.ProfileURL}}">{{end}}
+      <div class="{{.P}}header-left">
+        <div class="{{.P}}avatar">
           {{if .AvatarDataURI}}
-            <img src="{{.AvatarDataURI}}" alt="avatar" />
+            <img src="{{.AvatarDataURI}}" alt="" />
           {{else}}
             {{.Initials}}
           {{end}}
         </div>
-        <div class="header-text">
-          <div class="name-row">
-            <div class="name">{{.Name}}</div>
-            {{if .Verified}}<div class="verified icon">{{.VerifiedIcon}}</div>{{end}}
+        <div class="{{.P}}header-text">
+          <div class="{{.P}}name-row">
+            <div class="{{.P}}name">{{.Name}}</div>
+            {{if .Verified}}<div class="{{.P}}verified {{.P}}icon">{{.VerifiedIcon}}</div>{{end}}
           </div>
-          <div class="handle">{{.Handle}}</div>
+          <div class="{{.P}}handle">{{.Handle}}</div>
         </div>
       </div>
-      <div class="twitter icon">{{.TwitterIcon}}</div>
+      {{if .ProfileURL}}</a>{{end}}
+      {{if .PermalinkURL}}<a class="{{.P}}link" href="{{.PermalinkURL}}">{{end}}<div class="{{.P}}twitter {{.P}}icon">{{.TwitterIcon}}</div>{{if .PermalinkURL}}</a>{{end}}
     </div>
-    <div class="text">{{.Text}}</div>
+    <div class="{{.P}}text">{{.Text}}</div>
     {{if .ShowFooter}}
       {{if .DateLine}}
-      <div class="date-row">
-        <div>{{.DateLine}}</div>
-        <div class="info">{{.InfoIcon}}</div>
+      <div class="{{.P}}date-row">
+        {{if .PermalinkURL}}<a class="{{.P}}link" href="{{.PermalinkURL}}"><div class="{{.P}}date">{{template "date" .}}</div></a>{{else}}<div>{{template "date" .}}</div>{{end}}
+        <div class="{{.P}}info">{{.InfoIcon}}</div>
       </div>
-      <div class="divider"></div>
+      <div class="{{.P}}divider"></div>
       {{else}}
-      <div class="divider" style="margin-top: 16px;"></div>
+      <div class="{{.P}}divider" style="margin-top: 16px;"></div>
       {{end}}
-      <div class="actions">
+      <ul class="{{.P}}actions">
         {{range .Actions}}
-        <div class="action icon">{{.Icon}}<span>{{.Label}}</span></div>
+        <li class="{{$.P}}action {{$.P}}icon">{{.Icon}}<span>{{.Label}}</span></li>
         {{end}}
-      </div>
+      </ul>
       {{if .CTA}}
-      <div class="cta">{{.CTA}}</div>
+      {{if .CTAURL}}<a class="{{.P}}link" href="{{.CTAURL}}"><div class="{{.P}}cta">{{.CTA}}</div></a>{{else}}<div class="{{.P}}cta">{{.CTA}}</div>{{end}}
       {{end}}
     {{end}}
-  </div>
-</body>
+  </article>
+  {{if .Canvas}}</div>{{end}}
+{{end}}{{define "date"}}{{if .DateTime}}<time datetime="{{.DateTime}}">{{.DateText}}</time>{{else}}{{.DateText}}{{end}}{{if and .DateText .Location}} · {{end}}{{.Location}}{{end}}{{define "spec"}}{{if .Spec}}<script type="application/json" id="xpostgen-spec">{{.Spec}}</script>
+{{end}}{{end}}{{if eq .Mode "fragment"}}<div class="{{.P}}root">
+  <style>{{template "style" .}}  </style>
+{{template "card" .}}{{template "spec" .}}</div>
+{{else if eq .Mode "component"}}{{if .HostFontFaces}}<style>
+    {{.HostFontFaces}}
+</style>
+{{end}}<x-post-card>
+  <template shadowrootmode="open">
+  <style>{{template "style" .}}  </style>
+{{template "card" .}}  </template>
+</x-post-card>
+{{template "spec" .}}<script>
+  if (!customElements.get("x-post-card")) {
+    customElements.define("x-post-card", class extends HTMLElement {
+      connectedCallback() {
+        const template = this.querySelector(":scope > template[shadowrootmode]");
+        if (!this.shadowRoot && template) {
+          this.attachShadow({ mode: "open" }).appendChild(template.content.cloneNode(true));
+          template.remove();
+        }
+      }
+    });
+  }
+</script>
+{{else}}<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+  <meta charset="utf-8" />
+  <title>{{.Title}}</title>
+  <meta name="description" content="{{.Description}}" />
+  <style>{{template "style" .}}  </style>
+  {{template "spec" .}}</head>
+<body>{{template "card" .}}</body>
 </html>
-`
+{{end}}`
 
-// RenderHTML returns the tweet preview as HTML.
+const defaultHTMLClassPrefix = "xpost-"
+
+var htmlClassPrefixPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// RenderHTML returns the tweet preview as HTML, as a full page, a fragment
+// or a Web Component depending on RenderOptions.HTMLMode. Components use
+// declarative Shadow DOM; the bundled script attaches the shadow root in
+// browsers that do not support it.
 func RenderHTML(data TweetData, opts RenderOptions) (string, error) {
 	opts = normalizeOptions(opts)
 	fonts, err := loadFontSet(opts)
@@ -241,7 +361,7 @@ func RenderHTML(data TweetData, opts RenderOptions) (string, error) {
 
 	layout := computeLayout(data, opts, fonts)
 
-	avatar, err := avatarDataURI(data.Icon)
+	avatar, err := opts.cache.avatarDataURI(data.Icon)
 	if err != nil {
 		return "", err
 	}
@@ -257,14 +377,21 @@ func RenderHTML(data TweetData, opts RenderOptions) (string, error) {
 		Gap:           opts.Gap,
 		Name:          data.Name,
 		Handle:        buildHandleLine(data),
-		DateLine:      buildDateLine(data),
-		Text:          formatHTMLText(data.Text),
+		DateLine:      buildDateLine(data, opts),
+		DateText:      buildDateLine(TweetData{Date: data.Date, Time: data.Time, Lang: data.Lang}, opts),
+		Location:      data.Location,
+		Lang:          postLang(data),
+		Title:         descriptionAuthor(data, dateLocale(postLang(data)) == "ja"),
+		Description:   Description(data, opts),
 		CTA:           strings.TrimSpace(data.CTA),
 		Verified:      data.Verified,
 		ShowFooter:    !data.Simple,
 		AvatarDataURI: avatar,
 		Initials:      initials(data.Name),
-		FontFamily:    opts.FontFamily,
+		FontFamily:    cssFontFamily(opts.FontFamily),
+		PageBg:        "var(--bg)",
+		CardBorder:    "1.5px solid var(--border)",
+		CornerRadius:  20,
 		Background:    opts.Theme.Background,
 		Border:        opts.Theme.Border,
 		Divider:       opts.Theme.Divider,
@@ -278,6 +405,73 @@ func RenderHTML(data TweetData, opts RenderOptions) (string, error) {
 		InfoIcon:      icons.Info,
 	}
 	view.Actions = buildHTMLActions(data, icons)
+	if !data.Time.IsZero() {
+		view.DateTime = data.Time.Format(time.RFC3339)
+	}
+	links := buildCardLinks(data)
+	view.ProfileURL, view.PermalinkURL, view.CTAURL = links.Profile, links.Permalink, links.CTA
+	if opts.EmbedSpec {
+		spec, err := specJSON(data, opts)
+		if err != nil {
+			return "", err
+		}
+		view.Spec = template.JS(spec)
+	}
+	if embedsFonts(opts) {
+		faces, family, err := fontFaceCSS(data, layout, fonts)
+		if err != nil {
+			return "", err
+		}
+		view.FontFaces = template.CSS(faces)
+		view.FontFamily = cssFontFamily(withEmbeddedFont(family, opts.FontFamily))
+	}
+	if opts.Transparent {
+		view.PageBg = "transparent"
+	}
+	if opts.NoBorder {
+		view.CardBorder = "none"
+	}
+	if opts.CornerRadius != 0 {
+		view.CornerRadius = math.Max(0, opts.CornerRadius)
+	}
+	if opts.Canvas.Enabled() {
+		background, err := canvasCSSBackground(opts.Canvas, opts.cache)
+		if err != nil {
+			return "", err
+		}
+		fit, _, _ := canvasPlacement(opts.Canvas, layout.Width, layout.Height)
+		view.PageBg = "transparent"
+		view.Canvas = &htmlCanvas{
+			Width:        opts.Canvas.Width,
+			Height:       opts.Canvas.Height,
+			Background:   template.CSS(background),
+			Scale:        math.Round(fit*10000) / 10000,
+			Shadow:       !opts.Canvas.NoShadow,
+			ShadowOffset: math.Round(canvasShadowOffset/fit*100) / 100,
+			ShadowBlur:   math.Round(2*canvasShadowBlur/fit*100) / 100,
+			ShadowAlpha:  canvasShadowAlpha,
+		}
+	}
+
+	switch opts.HTMLMode {
+	case "", "page":
+		view.Mode, view.Root = "page", ":root"
+	case "fragment":
+		view.Mode, view.P = "fragment", opts.HTMLClassPrefix
+		if view.P == "" {
+			view.P = defaultHTMLClassPrefix
+		}
+		if !htmlClassPrefixPattern.MatchString(view.P) {
+			return "", fmt.Errorf("invalid html class prefix: %s", view.P)
+		}
+		view.Root = template.CSS("." + view.P + "root")
+	case "component":
+		view.Mode, view.Root = "component", ":host"
+		view.HostFontFaces, view.FontFaces = view.FontFaces, ""
+	default:
+		return "", fmt.Errorf("unknown html mode: %s", opts.HTMLMode)
+	}
+	view.Text = formatHTMLText(data.Text, data.Entities, view.P)
 
 	tmpl, err := template.New("tweet").Parse(htmlTemplate)
 	if err != nil {
@@ -290,30 +484,85 @@ func RenderHTML(data TweetData, opts RenderOptions) (string, error) {
 	return buf.String(), nil
 }
 
-func formatHTMLText(text string) template.HTML {
+// cssFontFamily marks a font-family list as trusted CSS after dropping the
+// characters that could end the declaration or the style element.
+func cssFontFamily(value string) template.CSS {
+	return template.CSS(strings.Map(func(r rune) rune {
+		if strings.ContainsRune("<>{};\\", r) {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+// formatHTMLText escapes the post text and wraps its entities, using the
+// class prefix p of fragments.
+func formatHTMLText(text string, entities []Entity, p string) template.HTML {
 	if strings.TrimSpace(text) == "" {
 		return template.HTML(template.HTMLEscapeString(text))
 	}
+	entities = normalizeEntities(text, entities)
+	mask := entityMask(text, entities)
+	urls := entityURLs(text, entities)
 	segments := strings.Split(text, "\n")
 	var builder strings.Builder
+	pos := 0
+	inEntity := false
+	link := ""
 	for i, segment := range segments {
 		if i > 0 {
 			builder.WriteByte('\n')
+			pos++
 		}
 		if segment == "" {
 			continue
 		}
 		tokens := budouxTokens(segment)
 		for j, token := range tokens {
-			builder.WriteString(template.HTMLEscapeString(token))
+			for _, r := range token {
+				highlighted := pos < len(mask) && mask[pos]
+				runLink := ""
+				if highlighted {
+					runLink = urls[pos]
+				}
+				if highlighted != inEntity || runLink != link {
+					if inEntity {
+						builder.WriteString(closeEntity(link))
+					}
+					if highlighted {
+						builder.WriteString(openEntity(runLink, p))
+					}
+					inEntity, link = highlighted, runLink
+				}
+				builder.WriteString(template.HTMLEscapeString(string(r)))
+				pos++
+			}
 			if j < len(tokens)-1 {
 				builder.WriteString("<wbr>")
 			}
 		}
 	}
+	if inEntity {
+		builder.WriteString(closeEntity(link))
+	}
 	return template.HTML(builder.String())
 }
 
+// openEntity starts an entity, as a link when it has one.
+func openEntity(link string, p string) string {
+	if link == "" {
+		return `<span class="` + p + `entity">`
+	}
+	return `<a class="` + p + `entity" href="` + template.HTMLEscapeString(link) + `">`
+}
+
+func closeEntity(link string) string {
+	if link == "" {
+		return "</span>"
+	}
+	return "</a>"
+}
+
 type htmlIcons struct {
 	Twitter  template.HTML
 	Verified template.HTML
@@ -0,0 +1,236 @@
+package render
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is an absolute path command: 'M' and 'L' use P[0], 'C' uses
+// P[0..2] as the two control points and the end point, 'Z' uses none.
+type pathSegment struct {
+	Op byte
+	P  [3][2]float64
+}
+
+type iconPath struct {
+	Fill     string
+	Segments []pathSegment
+}
+
+// iconShape is an icon parsed into plain path segments for backends that
+// draw vectors themselves (PDF).
+type iconShape struct {
+	MinX, MinY    float64
+	Width, Height float64
+	Paths         []iconPath
+}
+
+// loadIconShape parses an embedded icon. currentColor is replaced by color.
+func loadIconShape(name string, color string) (iconShape, error) {
+	svg, err := iconSVGWithColor(name, color)
+	if err != nil {
+		return iconShape{}, err
+	}
+	shape := iconShape{}
+	fills := []string{"#000000"}
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch el := token.(type) {
+		case xml.StartElement:
+			fill := fills[len(fills)-1]
+			for _, a := range el.Attr {
+				switch a.Name.Local {
+				case "fill":
+					fill = a.Value
+				case "viewBox":
+					var box [4]float64
+					for i, field := range strings.Fields(strings.ReplaceAll(a.Value, ",", " ")) {
+						if i < 4 {
+							box[i], _ = strconv.ParseFloat(field, 64)
+						}
+					}
+					shape.MinX, shape.MinY, shape.Width, shape.Height = box[0], box[1], box[2], box[3]
+				}
+			}
+			fills = append(fills, fill)
+			if el.Name.Local == "path" && fill != "none" {
+				for _, a := range el.Attr {
+					if a.Name.Local != "d" {
+						continue
+					}
+					segments, err := parsePathData(a.Value)
+					if err != nil {
+						return iconShape{}, fmt.Errorf("icon %s: %w", name, err)
+					}
+					shape.Paths = append(shape.Paths, iconPath{Fill: fill, Segments: segments})
+				}
+			}
+		case xml.EndElement:
+			fills = fills[:len(fills)-1]
+		}
+	}
+	if shape.Width <= 0 || shape.Height <= 0 {
+		return iconShape{}, fmt.Errorf("icon %s has no viewBox", name)
+	}
+	return shape, nil
+}
+
+// parsePathData converts SVG path data into absolute move/line/cubic
+// segments. Arcs and quadratic curves are not used by the bundled icons and
+// are rejected.
+func parsePathData(d string) ([]pathSegment, error) {
+	tokens := tokenizePath(d)
+	var segments []pathSegment
+	var cur, start, lastCtrl [2]float64
+	var cmd byte
+	prevCubic := false
+	for i := 0; i < len(tokens); {
+		if tok := tokens[i]; len(tok) == 1 && isPathCommand(tok[0]) {
+			cmd = tok[0]
+			i++
+			if cmd == 'z' || cmd == 'Z' {
+				segments = append(segments, pathSegment{Op: 'Z'})
+				cur = start
+				prevCubic = false
+				continue
+			}
+		}
+		if cmd == 0 {
+			return nil, fmt.Errorf("path data must start with a command")
+		}
+		argc := map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4}[upper(cmd)]
+		if argc == 0 {
+			return nil, fmt.Errorf("unsupported path command %q", cmd)
+		}
+		if i+argc > len(tokens) {
+			return nil, fmt.Errorf("path command %q is missing arguments", cmd)
+		}
+		args := make([]float64, argc)
+		for j := range args {
+			value, err := strconv.ParseFloat(tokens[i+j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path number %q", tokens[i+j])
+			}
+			args[j] = value
+		}
+		i += argc
+
+		relative := cmd >= 'a'
+		point := func(x, y float64) [2]float64 {
+			if relative {
+				return [2]float64{cur[0] + x, cur[1] + y}
+			}
+			return [2]float64{x, y}
+		}
+		isCubic := false
+		switch upper(cmd) {
+		case 'M':
+			cur = point(args[0], args[1])
+			start = cur
+			segments = append(segments, pathSegment{Op: 'M', P: [3][2]float64{cur}})
+			// Extra coordinate pairs after a moveto are implicit linetos.
+			if relative {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L':
+			cur = point(args[0], args[1])
+			segments = append(segments, pathSegment{Op: 'L', P: [3][2]float64{cur}})
+		case 'H':
+			if relative {
+				cur[0] += args[0]
+			} else {
+				cur[0] = args[0]
+			}
+			segments = append(segments, pathSegment{Op: 'L', P: [3][2]float64{cur}})
+		case 'V':
+			if relative {
+				cur[1] += args[0]
+			} else {
+				cur[1] = args[0]
+			}
+			segments = append(segments, pathSegment{Op: 'L', P: [3][2]float64{cur}})
+		case 'C':
+			c1, c2, end := point(args[0], args[1]), point(args[2], args[3]), point(args[4], args[5])
+			segments = append(segments, pathSegment{Op: 'C', P: [3][2]float64{c1, c2, end}})
+			lastCtrl, cur, isCubic = c2, end, true
+		case 'S':
+			c1 := cur
+			if prevCubic {
+				c1 = [2]float64{2*cur[0] - lastCtrl[0], 2*cur[1] - lastCtrl[1]}
+			}
+			c2, end := point(args[0], args[1]), point(args[2], args[3])
+			segments = append(segments, pathSegment{Op: 'C', P: [3][2]float64{c1, c2, end}})
+			lastCtrl, cur, isCubic = c2, end, true
+		}
+		prevCubic = isCubic
+	}
+	return segments, nil
+}
+
+func isPathCommand(c byte) bool {
+	return strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// tokenizePath splits path data into commands and numbers. Numbers may run
+// together as in "1.5.67" or "2-3".
+func tokenizePath(d string) []string {
+	var tokens []string
+	for i := 0; i < len(d); {
+		c := d[i]
+		switch {
+		case c == ' ' || c == ',' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isPathCommand(c):
+			tokens = append(tokens, d[i:i+1])
+			i++
+		default:
+			j := scanPathNumber(d, i)
+			tokens = append(tokens, d[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// scanPathNumber returns the end of the number starting at i.
+func scanPathNumber(d string, i int) int {
+	j := i
+	if d[j] == '-' || d[j] == '+' {
+		j++
+	}
+	seenDot, seenExp := false, false
+	for ; j < len(d); j++ {
+		ch := d[j]
+		switch {
+		case ch >= '0' && ch <= '9':
+		case ch == '.' && !seenDot && !seenExp:
+			seenDot = true
+		case (ch == 'e' || ch == 'E') && !seenExp:
+			seenExp = true
+			if j+1 < len(d) && (d[j+1] == '-' || d[j+1] == '+') {
+				j++
+			}
+		default:
+			if j == i {
+				return i + 1
+			}
+			return j
+		}
+	}
+	return j
+}
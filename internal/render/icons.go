@@ -5,6 +5,7 @@ import (
 	"embed"
 	"fmt"
 	"image"
+	"math"
 	"strings"
 
 	"github.com/srwiley/oksvg"
@@ -30,7 +31,9 @@ func iconSVGWithColor(name string, color string) (string, error) {
 	return strings.ReplaceAll(svg, "currentColor", color), nil
 }
 
-func rasterizeIcon(name string, color string, size int) (image.Image, error) {
+// rasterizeIcon renders an icon size pixels wide, shifted by a sub-pixel
+// offset (0 <= offset < 1) so it can be placed at fractional positions.
+func rasterizeIcon(name string, color string, size float64, offsetX float64, offsetY float64) (image.Image, error) {
 	svg, err := iconSVGWithColor(name, color)
 	if err != nil {
 		return nil, err
@@ -39,11 +42,12 @@ func rasterizeIcon(name string, color string, size int) (image.Image, error) {
 	if err != nil {
 		return nil, err
 	}
-	w := float64(size)
-	icon.SetTarget(0, 0, w, w)
-	img := image.NewRGBA(image.Rect(0, 0, size, size))
-	scanner := rasterx.NewScannerGV(size, size, img, img.Bounds())
-	raster := rasterx.NewDasher(size, size, scanner)
+	icon.SetTarget(offsetX, offsetY, size, size)
+	w := int(math.Ceil(offsetX + size))
+	h := int(math.Ceil(offsetY + size))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	raster := rasterx.NewDasher(w, h, scanner)
 	icon.Draw(raster, 1.0)
 	return img, nil
 }
@@ -1,34 +1,97 @@
 package render
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
 	imagedraw "image/draw"
-	"image/gif"
-	"image/jpeg"
 	"image/png"
 	"io"
 	"math"
-	"net/http"
-	"os"
 	"strings"
 
 	"github.com/fogleman/gg"
 	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 )
 
 // RenderImage renders the tweet preview into an RGBA image.
 func RenderImage(data TweetData, opts RenderOptions) (*image.RGBA, error) {
 	opts = normalizeOptions(opts)
+	if opts.Canvas.Enabled() {
+		return renderCanvasImage(data, opts)
+	}
+	return renderCardImage(data, opts)
+}
+
+func renderCardImage(data TweetData, opts RenderOptions) (*image.RGBA, error) {
+	painter, err := newCardPainter(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer painter.Close()
+	return painter.paint(data, fullCardFrame)
+}
+
+// cardFrame is the state of an animation frame. Chars limits how many runes
+// of the post text are drawn (-1 draws all) and CTAAlpha fades the CTA.
+type cardFrame struct {
+	Chars    int
+	CTAAlpha float64
+}
+
+var fullCardFrame = cardFrame{Chars: -1, CTAAlpha: 1}
+
+// cardPainter holds the fonts and avatar for drawing a card, so animations
+// load them once for all frames.
+type cardPainter struct {
+	opts   RenderOptions
+	fonts  FontSet
+	faces  FontSet
+	avatar image.Image
+}
+
+func newCardPainter(data TweetData, opts RenderOptions) (*cardPainter, error) {
 	fonts, err := loadFontSet(opts)
 	if err != nil {
 		return nil, err
 	}
-	defer fonts.Close()
+	faces := fonts
+	if opts.Scale != 1 {
+		faces, err = fonts.scaled(opts.Scale)
+		if err != nil {
+			fonts.Close()
+			return nil, err
+		}
+	}
+	painter := &cardPainter{opts: opts, fonts: fonts, faces: faces}
+	if data.Icon != "" {
+		if img, err := opts.cache.loadImage(data.Icon); err == nil {
+			painter.avatar = cropSquare(img)
+		}
+	}
+	return painter, nil
+}
 
+func (p *cardPainter) Close() {
+	p.fonts.Close()
+	if p.opts.Scale != 1 {
+		p.faces.Close()
+	}
+}
+
+func (p *cardPainter) paint(data TweetData, frame cardFrame) (*image.RGBA, error) {
+	opts, fonts, faces := p.opts, p.fonts, p.faces
 	layout := computeLayout(data, opts, fonts)
-	ctx := gg.NewContext(layout.Width, layout.Height)
+	scale := opts.Scale
+	canvas := &imageCanvas{
+		ctx:   gg.NewContext(int(math.Ceil(float64(layout.Width)*scale)), int(math.Ceil(float64(layout.Height)*scale))),
+		scale: scale,
+	}
+	ctx := canvas.ctx
+	ctx.Scale(scale, scale)
 
 	bg, err := colorFromHex(opts.Theme.Background)
 	if err != nil {
@@ -63,87 +126,88 @@ func RenderImage(data TweetData, opts RenderOptions) (*image.RGBA, error) {
 		return nil, err
 	}
 
+	corner := cardCornerRadius(opts, layout.Height)
 	ctx.SetColor(bg)
-	ctx.Clear()
+	if opts.Transparent {
+		inset := cardInset(opts)
+		ctx.DrawRoundedRectangle(inset, inset, float64(layout.Width)-2*inset, float64(layout.Height)-2*inset, corner)
+		ctx.Fill()
+	} else {
+		ctx.Clear()
+	}
 
-	ctx.SetColor(border)
-	ctx.SetLineWidth(2)
-	corner := math.Min(20, float64(layout.Height)/12)
-	ctx.DrawRoundedRectangle(1, 1, float64(layout.Width-2), float64(layout.Height-2), corner)
-	ctx.Stroke()
+	if !opts.NoBorder {
+		ctx.SetColor(border)
+		ctx.SetLineWidth(2 * scale)
+		ctx.DrawRoundedRectangle(1, 1, float64(layout.Width-2), float64(layout.Height-2), corner)
+		ctx.Stroke()
+	}
 
-	drawAvatar(ctx, data, layout, fonts, avatarBg, avatarText)
+	canvas.drawAvatar(data, p.avatar, layout, fonts, faces, avatarBg, avatarText)
 
-	ctx.SetFontFace(fonts.Name)
 	ctx.SetColor(text)
-	ctx.DrawString(layout.NameLine, layout.NameX, layout.NameY)
+	canvas.drawString(layout.NameLine, layout.NameX, layout.NameY, fonts.Name, faces.Name)
 
 	if layout.Verified {
-		icon, err := rasterizeIcon("verified", opts.Theme.Accent, int(layout.VerifiedSize))
-		if err == nil {
-			ctx.DrawImage(icon, int(layout.VerifiedX), int(layout.VerifiedY))
-		}
+		canvas.drawIcon("verified", opts.Theme.Accent, layout.VerifiedX, layout.VerifiedY, layout.VerifiedSize)
 	}
 
-	ctx.SetFontFace(fonts.Handle)
 	ctx.SetColor(muted)
-	ctx.DrawString(layout.HandleLine, layout.HandleX, layout.HandleY)
+	canvas.drawString(layout.HandleLine, layout.HandleX, layout.HandleY, fonts.Handle, faces.Handle)
 
-	ctx.SetFontFace(fonts.Text)
-	ctx.SetColor(text)
 	y := layout.TextY
-	for _, line := range layout.TextLines {
-		ctx.DrawString(line, layout.TextX, y)
+	remaining := frame.Chars
+	for _, runs := range layout.TextRuns {
+		for _, run := range runs {
+			if run.Entity {
+				ctx.SetColor(accent)
+			} else {
+				ctx.SetColor(text)
+			}
+			label := run.Text
+			if remaining >= 0 {
+				label = truncateRunes(label, remaining)
+				remaining -= len([]rune(label))
+			}
+			canvas.drawString(label, run.X, y, fonts.Text, faces.Text)
+		}
 		y += layout.TextLineHeight
 	}
 
 	if layout.ShowFooter && layout.DateLine != "" {
-		ctx.SetFontFace(fonts.Meta)
 		ctx.SetColor(muted)
-		ctx.DrawString(layout.DateLine, layout.DateX, layout.DateY)
-
-		infoIcon, err := rasterizeIcon("info", opts.Theme.Muted, int(layout.InfoSize))
-		if err == nil {
-			ctx.DrawImage(infoIcon, int(layout.InfoX), int(layout.InfoY))
-		}
+		canvas.drawString(layout.DateLine, layout.DateX, layout.DateY, fonts.Meta, faces.Meta)
+		canvas.drawIcon("info", opts.Theme.Muted, layout.InfoX, layout.InfoY, layout.InfoSize)
 	}
 
 	if layout.ShowFooter {
 		ctx.SetColor(divider)
-		ctx.SetLineWidth(1)
+		ctx.SetLineWidth(scale)
 		ctx.DrawLine(layout.Padding, layout.DividerY, float64(layout.Width)-layout.Padding, layout.DividerY)
 		ctx.Stroke()
 	}
 
 	if layout.ShowFooter {
 		for _, action := range layout.Actions {
-			icon, err := rasterizeIcon(action.IconName, opts.Theme.Muted, int(action.IconSize))
-			if err == nil {
-				ctx.DrawImage(icon, int(action.IconX), int(action.IconY))
-			}
-			ctx.SetFontFace(fonts.Action)
+			canvas.drawIcon(action.IconName, opts.Theme.Muted, action.IconX, action.IconY, action.IconSize)
 			ctx.SetColor(muted)
-			ctx.DrawString(action.Label, action.LabelX, action.LabelY)
+			canvas.drawString(action.Label, action.LabelX, action.LabelY, fonts.Action, faces.Action)
 		}
 	}
 
-	if layout.ShowFooter && layout.CTA != "" {
-		ctx.SetColor(bg)
+	if layout.ShowFooter && layout.CTA != "" && frame.CTAAlpha > 0 {
+		ctx.SetColor(fade(bg, frame.CTAAlpha))
 		ctx.DrawRoundedRectangle(layout.CtaX, layout.CtaY, layout.CtaWidth, layout.CtaHeight, layout.CtaHeight/2)
 		ctx.FillPreserve()
-		ctx.SetColor(divider)
-		ctx.SetLineWidth(1)
+		ctx.SetColor(fade(divider, frame.CTAAlpha))
+		ctx.SetLineWidth(scale)
 		ctx.Stroke()
 
-		ctx.SetFontFace(fonts.CTA)
-		ctx.SetColor(accent)
-		ctx.DrawString(layout.CTA, layout.CtaTextX, layout.CtaTextY)
+		ctx.SetColor(fade(accent, frame.CTAAlpha))
+		canvas.drawString(layout.CTA, layout.CtaTextX, layout.CtaTextY, fonts.CTA, faces.CTA)
 	}
 
-	twitterIcon, err := rasterizeIcon("twitter", opts.Theme.Accent, int(layout.TwitterSize))
-	if err == nil {
-		ctx.DrawImage(twitterIcon, int(layout.TwitterX), int(layout.TwitterY))
-	}
+	canvas.drawIcon("twitter", opts.Theme.Accent, layout.TwitterX, layout.TwitterY, layout.TwitterSize)
 
 	img := ctx.Image()
 	rgba := image.NewRGBA(img.Bounds())
@@ -157,62 +221,103 @@ func EncodeImage(w io.Writer, img image.Image, format string) error {
 	case "png":
 		return png.Encode(w, img)
 	case "jpg", "jpeg":
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+		return EncodeJPEG(w, img, JPEGOptions{})
 	case "gif":
-		return gif.Encode(w, img, &gif.Options{NumColors: 256})
+		return EncodeGIF(w, img, GIFOptions{})
+	case "webp":
+		return EncodeWebP(w, img, WebPOptions{})
 	default:
 		return fmt.Errorf("unsupported image format: %s", format)
 	}
 }
 
-func drawAvatar(ctx *gg.Context, data TweetData, layout Layout, fonts FontSet, bg color.Color, fg color.Color) {
-	if data.Icon != "" {
-		img, err := loadImage(data.Icon)
-		if err == nil {
-			square := cropSquare(img)
-			size := int(layout.AvatarSize)
-			resized := image.NewRGBA(image.Rect(0, 0, size, size))
-			xdraw.CatmullRom.Scale(resized, resized.Bounds(), square, square.Bounds(), xdraw.Over, nil)
-
-			ctx.Push()
-			ctx.DrawCircle(layout.AvatarX+layout.AvatarSize/2, layout.AvatarY+layout.AvatarSize/2, layout.AvatarSize/2)
-			ctx.Clip()
-			ctx.DrawImage(resized, int(layout.AvatarX), int(layout.AvatarY))
-			ctx.Pop()
-			ctx.ResetClip()
-			return
+// imageCanvas draws in logical layout coordinates onto a canvas that is
+// scale times larger. Vector shapes go through the context matrix; glyphs,
+// icons, and the avatar are bitmaps, so they are rendered at device
+// resolution and placed with the matrix reset instead of being resampled.
+type imageCanvas struct {
+	ctx   *gg.Context
+	scale float64
+}
+
+// drawString draws text with its baseline at (x, y). Glyphs are advanced by
+// the layout face so they land where the layout measured them, and drawn
+// with the device face.
+func (c *imageCanvas) drawString(text string, x, y float64, layoutFace font.Face, deviceFace font.Face) {
+	c.ctx.Push()
+	defer c.ctx.Pop()
+	c.ctx.Identity()
+	c.ctx.SetFontFace(deviceFace)
+
+	var dot fixed.Int26_6
+	prev := rune(-1)
+	for _, r := range text {
+		if prev >= 0 {
+			dot += layoutFace.Kern(prev, r)
 		}
+		c.ctx.DrawString(string(r), (x+float64(dot)/64)*c.scale, y*c.scale)
+		if advance, ok := layoutFace.GlyphAdvance(r); ok {
+			dot += advance
+		}
+		prev = r
+	}
+}
+
+// drawIcon rasterizes an icon at device resolution, keeping the sub-pixel
+// part of its position.
+func (c *imageCanvas) drawIcon(name string, color string, x, y, size float64) {
+	dx, dy := x*c.scale, y*c.scale
+	originX, originY := math.Floor(dx), math.Floor(dy)
+	icon, err := rasterizeIcon(name, color, size*c.scale, dx-originX, dy-originY)
+	if err != nil {
+		return
+	}
+	c.ctx.Push()
+	defer c.ctx.Pop()
+	c.ctx.Identity()
+	c.ctx.DrawImage(icon, int(originX), int(originY))
+}
+
+// drawAvatar draws the avatar image, already cropped square, or the
+// initials when there is none.
+func (c *imageCanvas) drawAvatar(data TweetData, avatar image.Image, layout Layout, fonts FontSet, faces FontSet, bg color.Color, fg color.Color) {
+	ctx := c.ctx
+	if avatar != nil {
+		size := int(math.Round(layout.AvatarSize * c.scale))
+		resized := image.NewRGBA(image.Rect(0, 0, size, size))
+		xdraw.CatmullRom.Scale(resized, resized.Bounds(), avatar, avatar.Bounds(), xdraw.Over, nil)
+
+		ctx.Push()
+		ctx.DrawCircle(layout.AvatarX+layout.AvatarSize/2, layout.AvatarY+layout.AvatarSize/2, layout.AvatarSize/2)
+		ctx.Clip()
+		ctx.Identity()
+		ctx.DrawImage(resized, int(math.Round(layout.AvatarX*c.scale)), int(math.Round(layout.AvatarY*c.scale)))
+		ctx.Pop()
+		ctx.ResetClip()
+		return
 	}
 
 	ctx.SetColor(bg)
 	ctx.DrawCircle(layout.AvatarX+layout.AvatarSize/2, layout.AvatarY+layout.AvatarSize/2, layout.AvatarSize/2)
 	ctx.Fill()
 
-	ctx.SetFontFace(fonts.Initials)
+	label := initials(data.Name)
+	width := float64(font.MeasureString(fonts.Initials, label)) / 64
+	height := float64(fonts.Initials.Metrics().Height) / 64
 	ctx.SetColor(fg)
-	ctx.DrawStringAnchored(initials(data.Name), layout.AvatarX+layout.AvatarSize/2, layout.AvatarY+layout.AvatarSize/2, 0.5, 0.5)
+	c.drawString(label, layout.AvatarX+(layout.AvatarSize-width)/2, layout.AvatarY+(layout.AvatarSize+height)/2, fonts.Initials, faces.Initials)
 }
 
 func loadImage(pathOrURL string) (image.Image, error) {
-	if strings.HasPrefix(pathOrURL, "http://") || strings.HasPrefix(pathOrURL, "https://") {
-		resp, err := http.Get(pathOrURL)
-		if err != nil {
-			return nil, err
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return nil, fmt.Errorf("failed to fetch icon: %s", resp.Status)
-		}
-		img, _, err := image.Decode(resp.Body)
-		return img, err
-	}
-
-	file, err := os.Open(pathOrURL)
+	data, _, err := readAsset(pathOrURL)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	img, _, err := image.Decode(file)
+	return decodeImage(data)
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
 	return img, err
 }
 
@@ -0,0 +1,76 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+)
+
+type imageMapArea struct {
+	Coords string
+	URL    string
+	Alt    string
+}
+
+type imageMapView struct {
+	Src    string
+	Width  int
+	Height int
+	Alt    string
+	Name   string
+	Areas  []imageMapArea
+}
+
+const imageMapTemplate = `<img src="{{.Src}}" width="{{.Width}}" height="{{.Height}}" alt="{{.Alt}}" usemap="#{{.Name}}" />
+<map name="{{.Name}}">
+{{- range .Areas}}
+  <area shape="rect" coords="{{.Coords}}" href="{{.URL}}" alt="{{.Alt}}" />
+{{- end}}
+</map>
+`
+
+// RenderImageMap returns an HTML <img> with a <map> whose areas cover the
+// links of the card, for use next to raster output stored at src. The
+// image is sized in logical pixels so the coordinates hold at any -scale.
+func RenderImageMap(data TweetData, opts RenderOptions, src string) (string, error) {
+	doc, err := RenderLayout(data, opts)
+	if err != nil {
+		return "", err
+	}
+	view := imageMapView{
+		Src:    src,
+		Width:  doc.Width,
+		Height: doc.Height,
+		Alt:    Description(data, opts),
+		Name:   "xpost-card",
+	}
+	for _, el := range doc.Elements {
+		if el.URL == "" {
+			continue
+		}
+		b := el.Box
+		alt := el.Text
+		if alt == "" {
+			alt = data.Name
+			if el.Type == "icon" && el.Name == "twitter" {
+				alt = "Post"
+			}
+		}
+		view.Areas = append(view.Areas, imageMapArea{
+			Coords: fmt.Sprintf("%d,%d,%d,%d", int(math.Floor(b.X)), int(math.Floor(b.Y)), int(math.Ceil(b.X+b.Width)), int(math.Ceil(b.Y+b.Height))),
+			URL:    el.URL,
+			Alt:    alt,
+		})
+	}
+
+	tmpl, err := template.New("imagemap").Parse(imageMapTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
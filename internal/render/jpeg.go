@@ -0,0 +1,344 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// JPEGOptions controls the JPEG encoder.
+type JPEGOptions struct {
+	// Quality (1-100, 90 when zero) trades file size for fidelity.
+	Quality int
+	// Chroma is the chroma subsampling: "420" (default) stores color at
+	// half resolution, "444" at full resolution, which keeps colored text
+	// such as links and hashtags sharp at the cost of size.
+	Chroma string
+}
+
+// EncodeJPEG writes img as a baseline JPEG.
+func EncodeJPEG(w io.Writer, img image.Image, opts JPEGOptions) error {
+	quality := opts.Quality
+	if quality == 0 {
+		quality = 90
+	}
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("jpeg quality must be between 1 and 100: %d", quality)
+	}
+	switch opts.Chroma {
+	case "", "420":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "444":
+		return encodeJPEG444(w, img, quality)
+	default:
+		return fmt.Errorf("unknown jpeg chroma subsampling: %s", opts.Chroma)
+	}
+}
+
+// jpegQuant holds the example tables of the JPEG spec (Annex K) for
+// luminance and chrominance, in zig-zag order.
+var jpegQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26, 26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegHuffman holds the typical Huffman tables of Annex K as code counts
+// per length and symbols: luminance DC and AC, then chrominance DC and AC.
+var jpegHuffman = [4]struct {
+	counts  [16]byte
+	symbols []byte
+}{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// jpegCode is a Huffman code: the bits in the low len bits of code.
+type jpegCode struct {
+	code uint32
+	len  uint8
+}
+
+// jpegZigzag maps zig-zag positions to natural (row-major) block indexes.
+var jpegZigzag = func() [64]int {
+	var order [64]int
+	i := 0
+	for sum := 0; sum < 15; sum++ {
+		lo, hi := max(0, sum-7), min(sum, 7)
+		for k := lo; k <= hi; k++ {
+			row := k
+			if sum%2 == 0 {
+				// Even diagonals run from bottom-left to top-right.
+				row = hi - (k - lo)
+			}
+			order[i] = row*8 + sum - row
+			i++
+		}
+	}
+	return order
+}()
+
+// jpegCosine is the 1-D DCT basis with the normalization folded in.
+var jpegCosine = func() [8][8]float64 {
+	var c [8][8]float64
+	for u := 0; u < 8; u++ {
+		scale := 0.5
+		if u == 0 {
+			scale = 0.5 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			c[u][x] = scale * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return c
+}()
+
+// jpegWriter writes entropy-coded data, stuffing a zero after 0xFF bytes.
+type jpegWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nbits uint8
+}
+
+func (e *jpegWriter) emit(bits uint32, n uint8) {
+	e.bits = e.bits<<n | bits&(1<<n-1)
+	e.nbits += n
+	for e.nbits >= 8 {
+		b := byte(e.bits >> (e.nbits - 8))
+		_ = e.w.WriteByte(b)
+		if b == 0xFF {
+			_ = e.w.WriteByte(0)
+		}
+		e.nbits -= 8
+	}
+}
+
+// flush pads the last byte with one bits.
+func (e *jpegWriter) flush() {
+	if e.nbits > 0 {
+		e.emit(0x7F, 8-e.nbits)
+	}
+}
+
+// encodeJPEG444 writes a baseline JPEG without chroma subsampling, which
+// image/jpeg does not offer.
+func encodeJPEG444(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return fmt.Errorf("jpeg: image is too large or empty: %dx%d", b.Dx(), b.Dy())
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(b)
+		draw.Draw(rgba, b, img, b.Min, draw.Src)
+	}
+
+	// Scale the tables the way libjpeg does.
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]int
+	for t := range quant {
+		for i, q := range jpegQuant[t] {
+			quant[t][i] = min(255, max(1, (q*scale+50)/100))
+		}
+	}
+	var codes [4][256]jpegCode
+	for t, spec := range jpegHuffman {
+		code, k := uint32(0), 0
+		for length, count := range spec.counts {
+			for i := 0; i < int(count); i++ {
+				codes[t][spec.symbols[k]] = jpegCode{code: code, len: uint8(length + 1)}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	// SOI and DQT.
+	_, _ = bw.Write([]byte{0xFF, 0xD8, 0xFF, 0xDB, 0, 2 + 2*65})
+	for t := range quant {
+		_ = bw.WriteByte(byte(t))
+		for _, q := range quant[t] {
+			_ = bw.WriteByte(byte(q))
+		}
+	}
+	// SOF0: 8-bit samples and three components sampled 1x1, with the
+	// chrominance components sharing the second table.
+	_, _ = bw.Write([]byte{
+		0xFF, 0xC0, 0, 17, 8,
+		byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), 3,
+		1, 0x11, 0, 2, 0x11, 1, 3, 0x11, 1,
+	})
+	// DHT.
+	length := 2
+	for _, spec := range jpegHuffman {
+		length += 17 + len(spec.symbols)
+	}
+	_, _ = bw.Write([]byte{0xFF, 0xC4, byte(length >> 8), byte(length)})
+	for t, spec := range jpegHuffman {
+		// Class (DC 0, AC 1) in the high nibble, table id in the low one.
+		_ = bw.WriteByte(byte(t%2<<4 | t/2))
+		_, _ = bw.Write(spec.counts[:])
+		_, _ = bw.Write(spec.symbols)
+	}
+	// SOS.
+	_, _ = bw.Write([]byte{0xFF, 0xDA, 0, 12, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+
+	e := &jpegWriter{w: bw}
+	var prevDC [3]int
+	var samples [3][64]float64
+	for by := b.Min.Y; by < b.Max.Y; by += 8 {
+		for bx := b.Min.X; bx < b.Max.X; bx += 8 {
+			for i := 0; i < 64; i++ {
+				// Edge blocks repeat the last row and column.
+				x, y := min(bx+i%8, b.Max.X-1), min(by+i/8, b.Max.Y-1)
+				p := rgba.Pix[rgba.PixOffset(x, y):]
+				yy, cb, cr := color444(p[0], p[1], p[2])
+				samples[0][i], samples[1][i], samples[2][i] = yy-128, cb-128, cr-128
+			}
+			for c := range samples {
+				t := min(c, 1)
+				coeffs := forwardDCT(&samples[c])
+				var zz [64]int
+				for k, n := range jpegZigzag {
+					zz[k] = int(math.Round(coeffs[n] / float64(quant[t][k])))
+				}
+				diff := zz[0] - prevDC[c]
+				prevDC[c] = zz[0]
+				emitCoefficient(e, &codes[2*t], 0, diff)
+				run := 0
+				for k := 1; k < 64; k++ {
+					if zz[k] == 0 {
+						run++
+						continue
+					}
+					for run > 15 {
+						// ZRL: sixteen zeros.
+						emitCode(e, codes[2*t+1][0xF0])
+						run -= 16
+					}
+					emitCoefficient(e, &codes[2*t+1], run, zz[k])
+					run = 0
+				}
+				if run > 0 {
+					// EOB.
+					emitCode(e, codes[2*t+1][0x00])
+				}
+			}
+		}
+	}
+	e.flush()
+	_, _ = bw.Write([]byte{0xFF, 0xD9})
+	return bw.Flush()
+}
+
+// color444 converts RGB to the JFIF YCbCr color space.
+func color444(r, g, b uint8) (float64, float64, float64) {
+	rf, gf, bf := float64(r), float64(g), float64(b)
+	y := 0.299*rf + 0.587*gf + 0.114*bf
+	cb := -0.168736*rf - 0.331264*gf + 0.5*bf + 128
+	cr := 0.5*rf - 0.418688*gf - 0.081312*bf + 128
+	return y, cb, cr
+}
+
+// forwardDCT returns the 2-D DCT of a block in row-major order.
+func forwardDCT(block *[64]float64) [64]float64 {
+	var rows, out [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += jpegCosine[u][x] * block[y*8+x]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				sum += jpegCosine[v][y] * rows[y*8+u]
+			}
+			out[v*8+u] = sum
+		}
+	}
+	return out
+}
+
+func emitCode(e *jpegWriter, code jpegCode) {
+	e.emit(code.code, code.len)
+}
+
+// emitCoefficient writes the symbol of a run of zeros and the size of
+// value, followed by the bits of value (ones' complement when negative).
+func emitCoefficient(e *jpegWriter, codes *[256]jpegCode, run, value int) {
+	magnitude := value
+	if magnitude < 0 {
+		magnitude = -magnitude
+		value--
+	}
+	size := 0
+	for magnitude > 0 {
+		size++
+		magnitude >>= 1
+	}
+	emitCode(e, codes[run<<4|size])
+	if size > 0 {
+		e.emit(uint32(value), uint8(size))
+	}
+}
@@ -37,6 +37,7 @@ type Layout struct {
 	TextX          float64
 	TextY          float64
 	TextLines      []string
+	TextRuns       [][]TextRun
 	TextLineHeight float64
 	DateX          float64
 	DateY          float64
@@ -58,13 +59,37 @@ type Layout struct {
 	DateLine       string
 }
 
+// cardCornerRadius resolves RenderOptions.CornerRadius for a card of the
+// given height.
+func cardCornerRadius(opts RenderOptions, height int) float64 {
+	switch {
+	case opts.CornerRadius < 0:
+		return 0
+	case opts.CornerRadius > 0:
+		return opts.CornerRadius
+	default:
+		return math.Min(20, float64(height)/12)
+	}
+}
+
+// cardInset is how far the card outline sits inside the canvas: the border
+// is stroked one pixel in so its full width stays visible.
+func cardInset(opts RenderOptions) float64 {
+	if opts.NoBorder {
+		return 0
+	}
+	return 1
+}
+
 func buildHandleLine(data TweetData) string {
 	return normalizeHandle(data.Handle)
 }
 
-func buildDateLine(data TweetData) string {
+func buildDateLine(data TweetData, opts RenderOptions) string {
 	parts := []string{}
-	if data.Date != "" {
+	if !data.Time.IsZero() {
+		parts = append(parts, formatPostTime(data.Time, data.Lang, opts))
+	} else if data.Date != "" {
 		parts = append(parts, data.Date)
 	}
 	if data.Location != "" {
@@ -85,7 +110,16 @@ func buildActions(data TweetData) []ActionLayout {
 	}
 }
 
+// computeLayout lays out the card, reusing the layout from the render
+// cache when there is one.
 func computeLayout(data TweetData, opts RenderOptions, fonts FontSet) Layout {
+	if opts.cache != nil {
+		return opts.cache.layout(data, opts, fonts)
+	}
+	return layoutCard(data, opts, fonts)
+}
+
+func layoutCard(data TweetData, opts RenderOptions, fonts FontSet) Layout {
 	opts = normalizeOptions(opts)
 	padding := float64(opts.Padding)
 	avatarSize := float64(opts.AvatarSize)
@@ -131,6 +165,7 @@ func computeLayout(data TweetData, opts RenderOptions, fonts FontSet) Layout {
 	}
 
 	textLines := wrapText(data.Text, textAvailableWidth, fonts.Text)
+	textRuns := buildTextRuns(data.Text, textLines, normalizeEntities(data.Text, data.Entities), contentStartX, fonts.Text)
 
 	nameAscent, nameDescent := fontAscentDescent(fonts.Name)
 	handleAscent, handleDescent := fontAscentDescent(fonts.Handle)
@@ -191,6 +226,7 @@ func computeLayout(data TweetData, opts RenderOptions, fonts FontSet) Layout {
 			TextX:          contentStartX,
 			TextY:          textY,
 			TextLines:      textLines,
+			TextRuns:       textRuns,
 			TextLineHeight: textLineHeight,
 			ShowFooter:     false,
 			NameLine:       nameLine,
@@ -202,7 +238,7 @@ func computeLayout(data TweetData, opts RenderOptions, fonts FontSet) Layout {
 	if dateAvailableWidth < 1 {
 		dateAvailableWidth = textAvailableWidth
 	}
-	dateLine := ellipsize(buildDateLine(data), dateAvailableWidth, fonts.Meta)
+	dateLine := ellipsize(buildDateLine(data, opts), dateAvailableWidth, fonts.Meta)
 	dateY := 0.0
 	infoX := 0.0
 	infoY := 0.0
@@ -276,6 +312,7 @@ func computeLayout(data TweetData, opts RenderOptions, fonts FontSet) Layout {
 		TextX:          contentStartX,
 		TextY:          textY,
 		TextLines:      textLines,
+		TextRuns:       textRuns,
 		TextLineHeight: textLineHeight,
 		DateX:          contentStartX,
 		DateY:          dateY,
@@ -327,7 +364,7 @@ func computeTightWidth(data TweetData, opts RenderOptions, fonts FontSet) float6
 	maxWidth := math.Max(headerWidth, textBlockWidth)
 
 	if !data.Simple {
-		dateLine := buildDateLine(data)
+		dateLine := buildDateLine(data, opts)
 		if dateLine != "" {
 			dateWidth := measureString(fonts.Meta, dateLine)
 			dateRowWidth := padding + dateWidth + 8 + infoSize + padding
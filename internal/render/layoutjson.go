@@ -0,0 +1,130 @@
+package render
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	"golang.org/x/image/font"
+)
+
+// LayoutBox is a rectangle in logical output pixels. Raster output is
+// LayoutDocument.Scale times larger.
+type LayoutBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// LayoutElement is one positioned item of the card. Name tells apart
+// elements of the same type, such as the action icons. URL is set for
+// elements that are links.
+type LayoutElement struct {
+	Type string    `json:"type"`
+	Name string    `json:"name,omitempty"`
+	Text string    `json:"text,omitempty"`
+	URL  string    `json:"url,omitempty"`
+	Box  LayoutBox `json:"box"`
+}
+
+// LayoutDocument is the computed geometry of a card, as written by the
+// "layout" format.
+type LayoutDocument struct {
+	Width    int             `json:"width"`
+	Height   int             `json:"height"`
+	Scale    float64         `json:"scale"`
+	Elements []LayoutElement `json:"elements"`
+}
+
+// RenderLayout computes the card layout and returns the box of every
+// element. Text boxes span the font's ascent and descent around the
+// baseline. In canvas mode the boxes are placed on the canvas.
+func RenderLayout(data TweetData, opts RenderOptions) (LayoutDocument, error) {
+	opts = normalizeOptions(opts)
+	cardOpts := opts
+	if opts.Canvas.Enabled() {
+		cardOpts = canvasCardOptions(opts)
+	}
+	fonts, err := loadFontSet(cardOpts)
+	if err != nil {
+		return LayoutDocument{}, err
+	}
+	defer fonts.Close()
+	layout := computeLayout(data, cardOpts, fonts)
+
+	links := buildCardLinks(data)
+	doc := LayoutDocument{Width: layout.Width, Height: layout.Height, Scale: opts.Scale}
+	add := func(kind, name, text, link string, x, y, width, height float64) {
+		doc.Elements = append(doc.Elements, LayoutElement{Type: kind, Name: name, Text: text, URL: link, Box: LayoutBox{x, y, width, height}})
+	}
+	addText := func(kind, text, link string, face font.Face, x, baseline float64) {
+		if text == "" {
+			return
+		}
+		ascent, descent := fontAscentDescent(face)
+		add(kind, "", text, link, x, baseline-ascent, measureString(face, text), ascent+descent)
+	}
+
+	add("card", "", "", "", 0, 0, float64(layout.Width), float64(layout.Height))
+	add("avatar", "", "", links.Profile, layout.AvatarX, layout.AvatarY, layout.AvatarSize, layout.AvatarSize)
+	addText("name", layout.NameLine, links.Profile, fonts.Name, layout.NameX, layout.NameY)
+	if layout.Verified {
+		add("icon", "verified", "", links.Profile, layout.VerifiedX, layout.VerifiedY, layout.VerifiedSize, layout.VerifiedSize)
+	}
+	addText("handle", layout.HandleLine, links.Profile, fonts.Handle, layout.HandleX, layout.HandleY)
+	add("icon", "twitter", "", links.Permalink, layout.TwitterX, layout.TwitterY, layout.TwitterSize, layout.TwitterSize)
+	for i, line := range layout.TextLines {
+		baseline := layout.TextY + float64(i)*layout.TextLineHeight
+		addText("text", line, "", fonts.Text, layout.TextX, baseline)
+		for _, run := range layout.TextRuns[i] {
+			if run.Entity {
+				addText("entity", run.Text, run.URL, fonts.Text, run.X, baseline)
+			}
+		}
+	}
+	if layout.ShowFooter {
+		if layout.DateLine != "" {
+			addText("date", layout.DateLine, links.Permalink, fonts.Meta, layout.DateX, layout.DateY)
+			add("icon", "info", "", "", layout.InfoX, layout.InfoY, layout.InfoSize, layout.InfoSize)
+		}
+		add("divider", "", "", "", layout.Padding, layout.DividerY, float64(layout.Width)-2*layout.Padding, 0)
+		for _, action := range layout.Actions {
+			add("icon", action.IconName, "", "", action.IconX, action.IconY, action.IconSize, action.IconSize)
+			ascent, descent := fontAscentDescent(fonts.Action)
+			add("action", action.IconName, action.Label, "", action.LabelX, action.LabelY-ascent, measureString(fonts.Action, action.Label), ascent+descent)
+		}
+		if layout.CTA != "" {
+			add("cta", "", layout.CTA, links.CTA, layout.CtaX, layout.CtaY, layout.CtaWidth, layout.CtaHeight)
+			addText("cta-text", layout.CTA, "", fonts.CTA, layout.CtaTextX, layout.CtaTextY)
+		}
+	}
+
+	if opts.Canvas.Enabled() {
+		fit, x, y := canvasPlacement(opts.Canvas, layout.Width, layout.Height)
+		doc.Width, doc.Height = opts.Canvas.Width, opts.Canvas.Height
+		for i := range doc.Elements {
+			box := &doc.Elements[i].Box
+			box.X, box.Y = x+box.X*fit, y+box.Y*fit
+			box.Width, box.Height = box.Width*fit, box.Height*fit
+		}
+	}
+	for i := range doc.Elements {
+		box := &doc.Elements[i].Box
+		box.X, box.Y = roundLayout(box.X), roundLayout(box.Y)
+		box.Width, box.Height = roundLayout(box.Width), roundLayout(box.Height)
+	}
+	return doc, nil
+}
+
+// roundLayout rounds to two decimals so the JSON stays readable.
+func roundLayout(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// EncodeLayout writes the layout document as indented JSON.
+func EncodeLayout(w io.Writer, doc LayoutDocument) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,46 @@
+package render
+
+import (
+	"net/url"
+	"strings"
+)
+
+// safeLinkURL returns link if it is an absolute http, https or mailto URL,
+// and "" otherwise, so imported data cannot inject script URLs.
+func safeLinkURL(link string) string {
+	link = strings.TrimSpace(link)
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		if parsed.Host == "" {
+			return ""
+		}
+		return link
+	case "mailto":
+		return link
+	default:
+		return ""
+	}
+}
+
+// cardLinks are the safe links of a card.
+type cardLinks struct {
+	Profile   string
+	Permalink string
+	CTA       string
+}
+
+func buildCardLinks(data TweetData) cardLinks {
+	links := cardLinks{
+		Profile:   safeLinkURL(data.ProfileURL),
+		Permalink: safeLinkURL(data.Permalink),
+		CTA:       safeLinkURL(data.CTAURL),
+	}
+	if links.CTA == "" {
+		links.CTA = links.Permalink
+	}
+	return links
+}
@@ -0,0 +1,208 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// specKeyword names the PNG iTXt chunk and the id of the SVG and HTML
+// elements that hold the embedded spec.
+const specKeyword = "xpostgen-spec"
+
+const specVersion = 1
+
+// Spec is the input of a render as embedded into its output when
+// RenderOptions.EmbedSpec is set. Rendering Data with Options again gives
+// the same card, with local files (icon, fonts, canvas background image)
+// looked up by name next to the output.
+type Spec struct {
+	Version int           `json:"version"`
+	Data    TweetData     `json:"data"`
+	Options RenderOptions `json:"options"`
+}
+
+// specJSON encodes the spec for embedding. encoding/json escapes <, > and &,
+// so the result can be placed as is inside XML text or an HTML script.
+// Local file paths keep only their base name so published files do not
+// reveal the directories they were made in.
+func specJSON(data TweetData, opts RenderOptions) (string, error) {
+	spec := Spec{Version: specVersion, Data: data, Options: normalizeOptions(opts)}
+	spec.Data.Icon = specPath(spec.Data.Icon)
+	spec.Options.FontPath = specPath(spec.Options.FontPath)
+	spec.Options.BoldFontPath = specPath(spec.Options.BoldFontPath)
+	if background, err := parseCanvasBackground(spec.Options.Canvas.Background); err == nil && background.Image != "" {
+		spec.Options.Canvas.Background = specPath(background.Image)
+	}
+	out, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// specPath returns the base name of a local file path; URLs and data URIs
+// are kept.
+func specPath(path string) string {
+	if path == "" || strings.Contains(path, "://") || strings.HasPrefix(path, "data:") {
+		return path
+	}
+	return filepath.Base(path)
+}
+
+// writePNGWithSpec runs encode and adds the card description as a
+// "Description" iTXt chunk right after IHDR, followed by the spec as a
+// compressed iTXt chunk when opts.EmbedSpec is set. The description is
+// alt text, so it is kept without the spec.
+func writePNGWithSpec(w io.Writer, data TweetData, opts RenderOptions, encode func(io.Writer) error) error {
+	var encoded bytes.Buffer
+	if err := encode(&encoded); err != nil {
+		return err
+	}
+	description, err := pngTextChunk("Description", postLang(data), Description(data, opts), false)
+	if err != nil {
+		return err
+	}
+	chunks := [][]byte{description}
+	if opts.EmbedSpec {
+		spec, err := specJSON(data, opts)
+		if err != nil {
+			return err
+		}
+		specChunk, err := pngTextChunk(specKeyword, "", spec, true)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, specChunk)
+	}
+	out, err := insertPNGChunks(encoded.Bytes(), chunks...)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// pngTextChunk returns the data of an iTXt chunk: the keyword, the
+// compression flag and method, the language tag, an empty translated
+// keyword and the text, zlib-compressed if compress is set.
+func pngTextChunk(keyword, lang, text string, compress bool) ([]byte, error) {
+	chunk := append([]byte(keyword), 0, 0, 0)
+	chunk = append(append(chunk, lang...), 0, 0)
+	if !compress {
+		return append(chunk, text...), nil
+	}
+	chunk[len(keyword)+1] = 1
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := io.WriteString(zw, text); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return append(chunk, buf.Bytes()...), nil
+}
+
+// insertPNGChunks adds iTXt chunks after IHDR.
+func insertPNGChunks(png []byte, chunks ...[]byte) ([]byte, error) {
+	var raw bytes.Buffer
+	for _, chunk := range chunks {
+		writePNGChunk(&raw, "iTXt", chunk)
+	}
+	return spliceAfterIHDR(png, raw.Bytes())
+}
+
+// spliceAfterIHDR inserts encoded chunks right after IHDR.
+func spliceAfterIHDR(png []byte, raw []byte) ([]byte, error) {
+	// The signature is followed by IHDR with its 13 bytes of data.
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	if len(png) < ihdrEnd || !bytes.HasPrefix(png, pngSignature) || string(png[12:16]) != "IHDR" {
+		return nil, fmt.Errorf("invalid png stream")
+	}
+	var out bytes.Buffer
+	out.Grow(len(png) + len(raw))
+	out.Write(png[:ihdrEnd])
+	out.Write(raw)
+	out.Write(png[ihdrEnd:])
+	return out.Bytes(), nil
+}
+
+// ExtractSpec returns the spec embedded in PNG, SVG or HTML output. It also
+// accepts the JSON of a Spec itself, as written by the extract command.
+func ExtractSpec(content []byte) (Spec, error) {
+	var raw []byte
+	switch trimmed := bytes.TrimSpace(content); {
+	case bytes.HasPrefix(content, pngSignature):
+		text, err := pngSpecText(content)
+		if err != nil {
+			return Spec{}, err
+		}
+		raw = text
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		raw = trimmed
+	default:
+		text := string(content)
+		start := strings.Index(text, `id="`+specKeyword+`">`)
+		if start < 0 {
+			return Spec{}, fmt.Errorf("no embedded spec found")
+		}
+		text = text[start+len(specKeyword)+6:]
+		end := strings.Index(text, "<")
+		if end < 0 {
+			return Spec{}, fmt.Errorf("unterminated embedded spec")
+		}
+		raw = []byte(text[:end])
+	}
+	var spec Spec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return Spec{}, fmt.Errorf("failed to parse embedded spec: %w", err)
+	}
+	if spec.Version != specVersion {
+		return Spec{}, fmt.Errorf("unsupported spec version: %d", spec.Version)
+	}
+	return spec, nil
+}
+
+// pngSpecText finds the spec iTXt chunk and returns its text.
+func pngSpecText(png []byte) ([]byte, error) {
+	chunks, err := pngChunks(png)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		data := chunk.data
+		if chunk.kind != "iTXt" || !bytes.HasPrefix(data, append([]byte(specKeyword), 0)) {
+			continue
+		}
+		// Skip the keyword, compression flag and method, language tag and
+		// translated keyword.
+		rest := data[len(specKeyword)+1:]
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("invalid spec chunk")
+		}
+		compressed := rest[0] == 1
+		rest = rest[2:]
+		for i := 0; i < 2; i++ {
+			end := bytes.IndexByte(rest, 0)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid spec chunk")
+			}
+			rest = rest[end+1:]
+		}
+		if !compressed {
+			return rest, nil
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("no embedded spec found")
+}
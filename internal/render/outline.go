@@ -0,0 +1,86 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// glyphOutliner converts text into SVG path data using the font programs the
+// layout was measured with, so the output does not depend on installed fonts.
+type glyphOutliner struct {
+	buf sfnt.Buffer
+}
+
+// path returns a <path> element for text with its baseline at (x, y). Glyphs
+// are advanced by the layout face, as in the raster backend, and their
+// outlines are taken unhinted from file at size pixels per em. It returns an
+// empty string when the text has no visible glyphs.
+func (o *glyphOutliner) path(file fontFile, size float64, layoutFace font.Face, text string, x, y float64, fill string) (string, error) {
+	var d strings.Builder
+	ppem := fixed.Int26_6(size * 64)
+	var dot fixed.Int26_6
+	prev := rune(-1)
+	for _, r := range text {
+		if prev >= 0 {
+			dot += layoutFace.Kern(prev, r)
+		}
+		gid, err := file.Font.GlyphIndex(&o.buf, r)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up glyph: %w", err)
+		}
+		if gid != 0 {
+			segments, err := file.Font.LoadGlyph(&o.buf, gid, ppem, nil)
+			if err != nil {
+				return "", fmt.Errorf("failed to load glyph outline: %w", err)
+			}
+			writePathSegments(&d, segments, x+float64(dot)/64, y)
+		}
+		if advance, ok := layoutFace.GlyphAdvance(r); ok {
+			dot += advance
+		}
+		prev = r
+	}
+	if d.Len() == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(`<path d="%s" fill="%s" />`, d.String(), fill), nil
+}
+
+// writePathSegments appends glyph segments, whose y axis already points
+// down, translated to (x, y).
+func writePathSegments(d *strings.Builder, segments sfnt.Segments, x, y float64) {
+	point := func(p fixed.Point26_6) string {
+		return pathNum(x+float64(p.X)/64) + " " + pathNum(y+float64(p.Y)/64)
+	}
+	open := false
+	for _, seg := range segments {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			if open {
+				d.WriteString("Z")
+			}
+			d.WriteString("M" + point(seg.Args[0]))
+			open = true
+		case sfnt.SegmentOpLineTo:
+			d.WriteString("L" + point(seg.Args[0]))
+		case sfnt.SegmentOpQuadTo:
+			d.WriteString("Q" + point(seg.Args[0]) + " " + point(seg.Args[1]))
+		case sfnt.SegmentOpCubeTo:
+			d.WriteString("C" + point(seg.Args[0]) + " " + point(seg.Args[1]) + " " + point(seg.Args[2]))
+		}
+	}
+	if open {
+		d.WriteString("Z")
+	}
+}
+
+// pathNum formats a coordinate with at most two decimals.
+func pathNum(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
@@ -9,13 +9,46 @@ import (
 // RenderToWriter dispatches rendering based on the format.
 func RenderToWriter(w io.Writer, data TweetData, opts RenderOptions, format string) error {
 	format = normalizeFormat(format)
+	if format == "apng" && !opts.Animation.Enabled() {
+		opts.Animation.Duration = defaultAnimationDuration
+	}
+	if opts.Animation.Enabled() {
+		return renderAnimationToWriter(w, data, opts, format)
+	}
 	switch format {
-	case "png", "jpg", "jpeg", "gif":
+	case "png":
+		img, err := RenderImage(data, opts)
+		if err != nil {
+			return err
+		}
+		return writePNGWithSpec(w, data, opts, func(w io.Writer) error {
+			return EncodeImage(w, img, format)
+		})
+	case "jpg", "jpeg":
+		img, err := RenderImage(data, opts)
+		if err != nil {
+			return err
+		}
+		return EncodeJPEG(w, img, opts.JPEG)
+	case "gif":
 		img, err := RenderImage(data, opts)
 		if err != nil {
 			return err
 		}
-		return EncodeImage(w, img, format)
+		return EncodeGIF(w, img, opts.GIF, themePalette(opts.Theme)...)
+	case "webp":
+		img, err := RenderImage(data, opts)
+		if err != nil {
+			return err
+		}
+		return EncodeWebP(w, img, opts.WebP)
+	case "pdf":
+		pdf, err := RenderPDF(data, opts)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(pdf)
+		return err
 	case "svg":
 		svg, err := RenderSVG(data, opts)
 		if err != nil {
@@ -30,6 +63,19 @@ func RenderToWriter(w io.Writer, data TweetData, opts RenderOptions, format stri
 		}
 		_, err = io.WriteString(w, html)
 		return err
+	case "tsx":
+		tsx, err := RenderTSX(data, opts)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, tsx)
+		return err
+	case "layout":
+		doc, err := RenderLayout(data, opts)
+		if err != nil {
+			return err
+		}
+		return EncodeLayout(w, doc)
 	default:
 		return fmt.Errorf("unsupported format: %s", format)
 	}
@@ -45,3 +91,28 @@ func normalizeFormat(format string) string {
 	}
 	return lower
 }
+
+func renderAnimationToWriter(w io.Writer, data TweetData, opts RenderOptions, format string) error {
+	switch format {
+	case "gif", "png", "apng":
+	case "svg":
+		svg, err := RenderSVG(data, opts)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, svg)
+		return err
+	default:
+		return fmt.Errorf("%s output does not support animation (use gif, apng or svg)", format)
+	}
+	frames, err := RenderAnimation(data, opts)
+	if err != nil {
+		return err
+	}
+	if format == "gif" {
+		return EncodeAnimatedGIF(w, frames, opts.Animation.Once, themePalette(opts.Theme)...)
+	}
+	return writePNGWithSpec(w, data, opts, func(w io.Writer) error {
+		return EncodeAPNG(w, frames, opts.Animation.Once)
+	})
+}
@@ -0,0 +1,596 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfAvatarResolution is how many image pixels the avatar gets per point, so
+// it stays sharp when the PDF is printed or zoomed.
+const pdfAvatarResolution = 4
+
+// bezierCircle is the control point distance for a quarter circle of radius 1.
+const bezierCircle = 0.5522847498
+
+// RenderPDF renders the tweet preview as a single-page vector PDF. Text is
+// set in embedded subsets of the regular and bold fonts so it stays
+// selectable, and icons are drawn as vector paths. One layout pixel is one
+// PDF point.
+func RenderPDF(data TweetData, opts RenderOptions) ([]byte, error) {
+	opts = normalizeOptions(opts)
+	if opts.Canvas.Enabled() {
+		return nil, fmt.Errorf("pdf output does not support canvas mode")
+	}
+	fonts, err := loadFontSet(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer fonts.Close()
+
+	layout := computeLayout(data, opts, fonts)
+	palette, err := newPDFPalette(opts.Theme)
+	if err != nil {
+		return nil, err
+	}
+
+	regular := newPDFFont("F1", fonts.regular)
+	bold := newPDFFont("F2", fonts.bold)
+	canvas := &pdfCanvas{}
+	width, height := float64(layout.Width), float64(layout.Height)
+	canvas.op("1 0 0 -1 0 %s cm", pdfNum(height))
+
+	corner := cardCornerRadius(opts, layout.Height)
+	canvas.fill(palette.bg)
+	if opts.Transparent {
+		inset := cardInset(opts)
+		canvas.roundedRect(inset, inset, width-2*inset, height-2*inset, corner)
+		canvas.op("f")
+	} else {
+		canvas.op("0 0 %s %s re f", pdfNum(width), pdfNum(height))
+	}
+
+	if !opts.NoBorder {
+		canvas.stroke(palette.border)
+		canvas.op("2 w")
+		canvas.roundedRect(1, 1, width-2, height-2, corner)
+		canvas.op("S")
+	}
+
+	var avatar image.Image
+	if data.Icon != "" {
+		if img, err := opts.cache.loadImage(data.Icon); err == nil {
+			avatar = pdfAvatar(img, layout.AvatarSize)
+		}
+	}
+	cx, cy, radius := layout.AvatarX+layout.AvatarSize/2, layout.AvatarY+layout.AvatarSize/2, layout.AvatarSize/2
+	if avatar != nil {
+		canvas.op("q")
+		canvas.circle(cx, cy, radius)
+		canvas.op("W n")
+		canvas.op("%s 0 0 %s %s %s cm", pdfNum(layout.AvatarSize), pdfNum(-layout.AvatarSize), pdfNum(layout.AvatarX), pdfNum(layout.AvatarY+layout.AvatarSize))
+		canvas.op("/Im1 Do")
+		canvas.op("Q")
+	} else {
+		canvas.fill(palette.avatarBg)
+		canvas.circle(cx, cy, radius)
+		canvas.op("f")
+		label := initials(data.Name)
+		labelWidth := float64(font.MeasureString(fonts.Initials, label)) / 64
+		labelHeight := float64(fonts.Initials.Metrics().Height) / 64
+		canvas.text(bold, fonts.Initials, initialsFontSize, label, cx-labelWidth/2, cy+labelHeight/2, palette.avatarText)
+	}
+
+	canvas.text(bold, fonts.Name, nameFontSize, layout.NameLine, layout.NameX, layout.NameY, palette.text)
+	if layout.Verified {
+		if err := canvas.icon("verified", opts.Theme.Accent, layout.VerifiedX, layout.VerifiedY, layout.VerifiedSize); err != nil {
+			return nil, err
+		}
+	}
+	canvas.text(regular, fonts.Handle, handleFontSize, layout.HandleLine, layout.HandleX, layout.HandleY, palette.muted)
+
+	y := layout.TextY
+	for _, runs := range layout.TextRuns {
+		for _, run := range runs {
+			fill := palette.text
+			if run.Entity {
+				fill = palette.accent
+			}
+			canvas.text(regular, fonts.Text, textFontSize, run.Text, run.X, y, fill)
+		}
+		y += layout.TextLineHeight
+	}
+
+	if layout.ShowFooter && layout.DateLine != "" {
+		canvas.text(regular, fonts.Meta, metaFontSize, layout.DateLine, layout.DateX, layout.DateY, palette.muted)
+		if err := canvas.icon("info", opts.Theme.Muted, layout.InfoX, layout.InfoY, layout.InfoSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if layout.ShowFooter {
+		canvas.stroke(palette.divider)
+		canvas.op("1 w")
+		canvas.op("%s %s m %s %s l S", pdfNum(layout.Padding), pdfNum(layout.DividerY), pdfNum(width-layout.Padding), pdfNum(layout.DividerY))
+
+		for _, action := range layout.Actions {
+			if err := canvas.icon(action.IconName, opts.Theme.Muted, action.IconX, action.IconY, action.IconSize); err != nil {
+				return nil, err
+			}
+			canvas.text(regular, fonts.Action, actionFontSize, action.Label, action.LabelX, action.LabelY, palette.muted)
+		}
+	}
+
+	if layout.ShowFooter && layout.CTA != "" {
+		canvas.fill(palette.bg)
+		canvas.stroke(palette.divider)
+		canvas.op("1 w")
+		canvas.roundedRect(layout.CtaX, layout.CtaY, layout.CtaWidth, layout.CtaHeight, layout.CtaHeight/2)
+		canvas.op("B")
+		canvas.text(bold, fonts.CTA, ctaFontSize, layout.CTA, layout.CtaTextX, layout.CtaTextY, palette.accent)
+	}
+
+	if err := canvas.icon("twitter", opts.Theme.Accent, layout.TwitterX, layout.TwitterY, layout.TwitterSize); err != nil {
+		return nil, err
+	}
+
+	info := pdfInfo{
+		Title:   descriptionAuthor(data, dateLocale(postLang(data)) == "ja"),
+		Subject: Description(data, opts),
+		Lang:    postLang(data),
+	}
+	return writePDF(canvas.buf.Bytes(), width, height, []*pdfFont{regular, bold}, avatar, info)
+}
+
+type pdfPalette struct {
+	bg, border, divider, text, muted, accent, avatarBg, avatarText color.NRGBA
+}
+
+func newPDFPalette(theme Theme) (pdfPalette, error) {
+	var palette pdfPalette
+	for _, entry := range []struct {
+		dst *color.NRGBA
+		hex string
+	}{
+		{&palette.bg, theme.Background},
+		{&palette.border, theme.Border},
+		{&palette.divider, theme.Divider},
+		{&palette.text, theme.Text},
+		{&palette.muted, theme.Muted},
+		{&palette.accent, theme.Accent},
+		{&palette.avatarBg, theme.AvatarBg},
+		{&palette.avatarText, theme.AvatarText},
+	} {
+		c, err := colorFromHex(entry.hex)
+		if err != nil {
+			return pdfPalette{}, err
+		}
+		*entry.dst = c
+	}
+	return palette, nil
+}
+
+// pdfAvatar crops the avatar to a square and resamples it to at most
+// pdfAvatarResolution pixels per point.
+func pdfAvatar(img image.Image, size float64) image.Image {
+	square := cropSquare(img)
+	side := square.Bounds().Dx()
+	if limit := int(size * pdfAvatarResolution); side > limit {
+		side = limit
+	}
+	resized := image.NewNRGBA(image.Rect(0, 0, side, side))
+	xdraw.CatmullRom.Scale(resized, resized.Bounds(), square, square.Bounds(), xdraw.Src, nil)
+	return resized
+}
+
+// pdfCanvas collects content stream operators. The page is flipped so that
+// coordinates match the top-left origin of Layout.
+type pdfCanvas struct {
+	buf bytes.Buffer
+}
+
+func (c *pdfCanvas) op(format string, args ...any) {
+	fmt.Fprintf(&c.buf, format, args...)
+	c.buf.WriteByte('\n')
+}
+
+func (c *pdfCanvas) fill(col color.NRGBA) {
+	c.op("%s rg", pdfColor(col))
+}
+
+func (c *pdfCanvas) stroke(col color.NRGBA) {
+	c.op("%s RG", pdfColor(col))
+}
+
+func (c *pdfCanvas) moveTo(x, y float64) {
+	c.op("%s %s m", pdfNum(x), pdfNum(y))
+}
+
+func (c *pdfCanvas) lineTo(x, y float64) {
+	c.op("%s %s l", pdfNum(x), pdfNum(y))
+}
+
+func (c *pdfCanvas) curveTo(x1, y1, x2, y2, x3, y3 float64) {
+	c.op("%s %s %s %s %s %s c", pdfNum(x1), pdfNum(y1), pdfNum(x2), pdfNum(y2), pdfNum(x3), pdfNum(y3))
+}
+
+func (c *pdfCanvas) circle(cx, cy, r float64) {
+	k := r * bezierCircle
+	c.moveTo(cx+r, cy)
+	c.curveTo(cx+r, cy+k, cx+k, cy+r, cx, cy+r)
+	c.curveTo(cx-k, cy+r, cx-r, cy+k, cx-r, cy)
+	c.curveTo(cx-r, cy-k, cx-k, cy-r, cx, cy-r)
+	c.curveTo(cx+k, cy-r, cx+r, cy-k, cx+r, cy)
+	c.op("h")
+}
+
+func (c *pdfCanvas) roundedRect(x, y, w, h, r float64) {
+	r = math.Min(r, math.Min(w, h)/2)
+	k := r * bezierCircle
+	x1, y1 := x+w, y+h
+	c.moveTo(x+r, y)
+	c.lineTo(x1-r, y)
+	c.curveTo(x1-r+k, y, x1, y+r-k, x1, y+r)
+	c.lineTo(x1, y1-r)
+	c.curveTo(x1, y1-r+k, x1-r+k, y1, x1-r, y1)
+	c.lineTo(x+r, y1)
+	c.curveTo(x+r-k, y1, x, y1-r+k, x, y1-r)
+	c.lineTo(x, y+r)
+	c.curveTo(x, y+r-k, x+r-k, y, x+r, y)
+	c.op("h")
+}
+
+// text draws a single line with its baseline at y. The text matrix flips
+// the glyphs back upright inside the flipped page.
+func (c *pdfCanvas) text(f *pdfFont, face font.Face, size float64, text string, x, y float64, col color.NRGBA) {
+	if text == "" {
+		return
+	}
+	c.fill(col)
+	c.op("BT /%s %s Tf 1 0 0 -1 %s %s Tm %s TJ ET", f.resource, pdfNum(size), pdfNum(x), pdfNum(y), f.show(face, size, text))
+}
+
+// icon draws an embedded icon as filled vector paths.
+func (c *pdfCanvas) icon(name string, fill string, x, y, size float64) error {
+	shape, err := loadIconShape(name, fill)
+	if err != nil {
+		return err
+	}
+	c.op("q")
+	c.op("%s 0 0 %s %s %s cm", pdfNum(size/shape.Width), pdfNum(size/shape.Height), pdfNum(x-shape.MinX*size/shape.Width), pdfNum(y-shape.MinY*size/shape.Height))
+	for _, path := range shape.Paths {
+		col, err := colorFromHex(path.Fill)
+		if err != nil {
+			return fmt.Errorf("icon %s: %w", name, err)
+		}
+		c.fill(col)
+		for _, seg := range path.Segments {
+			switch seg.Op {
+			case 'M':
+				c.moveTo(seg.P[0][0], seg.P[0][1])
+			case 'L':
+				c.lineTo(seg.P[0][0], seg.P[0][1])
+			case 'C':
+				c.curveTo(seg.P[0][0], seg.P[0][1], seg.P[1][0], seg.P[1][1], seg.P[2][0], seg.P[2][1])
+			case 'Z':
+				c.op("h")
+			}
+		}
+		c.op("f")
+	}
+	c.op("Q")
+	return nil
+}
+
+// pdfFont tracks the glyphs used from one font so only those are embedded.
+type pdfFont struct {
+	resource string
+	file     fontFile
+	buf      sfnt.Buffer
+	used     map[sfnt.GlyphIndex]rune
+}
+
+func newPDFFont(resource string, file fontFile) *pdfFont {
+	return &pdfFont{resource: resource, file: file, used: map[sfnt.GlyphIndex]rune{}}
+}
+
+func (f *pdfFont) unitsPerEm() float64 {
+	return float64(f.file.Font.UnitsPerEm())
+}
+
+// width returns the unhinted advance of a glyph in 1/1000 em.
+func (f *pdfFont) width(gid sfnt.GlyphIndex) float64 {
+	upem := f.file.Font.UnitsPerEm()
+	adv, err := f.file.Font.GlyphAdvance(&f.buf, gid, fixed.I(int(upem)), font.HintingNone)
+	if err != nil {
+		return 0
+	}
+	return float64(adv) / 64 * 1000 / float64(upem)
+}
+
+// show encodes text as a TJ array of glyph IDs. Positioning adjustments
+// make every glyph land where the raster renderer puts it, including
+// hinted advances and kerning.
+func (f *pdfFont) show(face font.Face, size float64, text string) string {
+	var b strings.Builder
+	b.WriteString("[<")
+	prev := rune(-1)
+	var prevGID sfnt.GlyphIndex
+	for _, r := range text {
+		gid, err := f.file.Font.GlyphIndex(&f.buf, r)
+		if err != nil {
+			gid = 0
+		}
+		// Missing characters use .notdef (0), which is embedded and gets
+		// its width declared like any other glyph so the adjustments
+		// below hold; it copies as U+FFFD.
+		if _, seen := f.used[gid]; !seen {
+			f.used[gid] = r
+			if gid == 0 {
+				f.used[gid] = unicode.ReplacementChar
+			}
+		}
+		if prev >= 0 {
+			advance, _ := face.GlyphAdvance(prev)
+			want := float64(advance+face.Kern(prev, r)) / 64
+			adjust := f.width(prevGID) - want*1000/size
+			if math.Abs(adjust) >= 0.01 {
+				fmt.Fprintf(&b, "> %s <", pdfNum(adjust))
+			}
+		}
+		fmt.Fprintf(&b, "%04X", uint16(gid))
+		prev, prevGID = r, gid
+	}
+	b.WriteString(">]")
+	return b.String()
+}
+
+// subsetName builds the "ABCDEF+PostScriptName" name PDF uses for subsets.
+func (f *pdfFont) subsetName(gids []sfnt.GlyphIndex) string {
+	name, err := f.file.Font.Name(&f.buf, sfnt.NameIDPostScript)
+	if err != nil || name == "" {
+		name = "Font" + f.resource
+	}
+	name = strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("()<>[]{}/%#", r) {
+			return r
+		}
+		return -1
+	}, name)
+
+	hash := sha1.New()
+	for _, gid := range gids {
+		fmt.Fprintf(hash, "%d,", gid)
+	}
+	sum := hash.Sum(nil)
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + sum[i]%26
+	}
+	return string(tag) + "+" + name
+}
+
+type pdfDocument struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+// reserve allocates an object number before its content is known.
+func (d *pdfDocument) reserve() int {
+	d.offsets = append(d.offsets, 0)
+	return len(d.offsets)
+}
+
+func (d *pdfDocument) object(id int, body string) {
+	d.offsets[id-1] = d.buf.Len()
+	fmt.Fprintf(&d.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+// stream writes a Flate-compressed stream object. dict holds the extra
+// dictionary entries.
+func (d *pdfDocument) stream(id int, dict string, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+
+	d.offsets[id-1] = d.buf.Len()
+	fmt.Fprintf(&d.buf, "%d 0 obj\n<< %s /Filter /FlateDecode /Length %d >>\nstream\n", id, dict, compressed.Len())
+	d.buf.Write(compressed.Bytes())
+	d.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// pdfInfo is the document information shown by viewers and read by
+// assistive technology.
+type pdfInfo struct {
+	Title   string
+	Subject string
+	Lang    string
+}
+
+func writePDF(content []byte, width, height float64, fonts []*pdfFont, avatar image.Image, info pdfInfo) ([]byte, error) {
+	doc := &pdfDocument{}
+	doc.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	catalog, pages, page, contents, infoRef := doc.reserve(), doc.reserve(), doc.reserve(), doc.reserve(), doc.reserve()
+
+	var fontRefs []string
+	for _, f := range fonts {
+		if len(f.used) == 0 {
+			continue
+		}
+		ref, err := writePDFFont(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		fontRefs = append(fontRefs, fmt.Sprintf("/%s %d 0 R", f.resource, ref))
+	}
+
+	resources := "/ProcSet [/PDF /Text /ImageC]"
+	if len(fontRefs) > 0 {
+		resources += " /Font << " + strings.Join(fontRefs, " ") + " >>"
+	}
+	if avatar != nil {
+		resources += fmt.Sprintf(" /XObject << /Im1 %d 0 R >>", writePDFImage(doc, avatar))
+	}
+
+	doc.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Lang %s /ViewerPreferences << /DisplayDocTitle true >> >>", pages, pdfTextString(info.Lang)))
+	doc.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", page))
+	doc.object(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << %s >> /Contents %d 0 R >>",
+		pages, pdfNum(width), pdfNum(height), resources, contents))
+	doc.stream(contents, "", content)
+	doc.object(infoRef, fmt.Sprintf("<< /Title %s /Subject %s /Creator (xpostgen) >>", pdfTextString(info.Title), pdfTextString(info.Subject)))
+
+	xref := doc.buf.Len()
+	fmt.Fprintf(&doc.buf, "xref\n0 %d\n0000000000 65535 f \n", len(doc.offsets)+1)
+	for _, offset := range doc.offsets {
+		fmt.Fprintf(&doc.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&doc.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(doc.offsets)+1, catalog, infoRef, xref)
+	return doc.buf.Bytes(), nil
+}
+
+// writePDFFont embeds a font as a Type0 font with Identity-H encoding, so
+// content streams address glyphs by their original glyph IDs.
+func writePDFFont(doc *pdfDocument, f *pdfFont) (int, error) {
+	gids := make([]sfnt.GlyphIndex, 0, len(f.used))
+	keep := map[uint16]bool{}
+	for gid := range f.used {
+		gids = append(gids, gid)
+		keep[uint16(gid)] = true
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+
+	program, err := subsetTrueType(f.file.Data, keep)
+	if errors.Is(err, errNotTrueType) {
+		return 0, fmt.Errorf("pdf output needs a TrueType (glyf) font; CFF-based .otf fonts are not supported")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to subset font: %w", err)
+	}
+
+	upem := f.unitsPerEm()
+	ppem := fixed.I(int(f.file.Font.UnitsPerEm()))
+	scale := func(v fixed.Int26_6) string {
+		return pdfNum(math.Round(float64(v) / 64 * 1000 / upem))
+	}
+	bounds, err := f.file.Font.Bounds(&f.buf, ppem, font.HintingNone)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read font bounds: %w", err)
+	}
+	metrics, err := f.file.Font.Metrics(&f.buf, ppem, font.HintingNone)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read font metrics: %w", err)
+	}
+
+	name := f.subsetName(gids)
+	type0, cidFont, descriptor, file, toUnicode := doc.reserve(), doc.reserve(), doc.reserve(), doc.reserve(), doc.reserve()
+
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%s] ", gid, pdfNum(math.Round(f.width(gid))))
+	}
+
+	doc.object(type0, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		name, cidFont, toUnicode))
+	doc.object(cidFont, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW 1000 /W [%s] /CIDToGIDMap /Identity >>",
+		name, descriptor, strings.TrimSpace(widths.String())))
+	doc.object(descriptor, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 4 /FontBBox [%s %s %s %s] /ItalicAngle 0 /Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %d 0 R >>",
+		name, scale(bounds.Min.X), scale(-bounds.Max.Y), scale(bounds.Max.X), scale(-bounds.Min.Y),
+		scale(metrics.Ascent), scale(-metrics.Descent), scale(metrics.CapHeight), file))
+	doc.stream(file, fmt.Sprintf("/Length1 %d", len(program)), program)
+	doc.stream(toUnicode, "", toUnicodeCMap(gids, f.used))
+	return type0, nil
+}
+
+// toUnicodeCMap maps glyph IDs back to text for copy and search.
+func toUnicodeCMap(gids []sfnt.GlyphIndex, runes map[sfnt.GlyphIndex]rune) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		end := min(start+100, len(gids))
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			fmt.Fprintf(&b, "<%04X> <", uint16(gid))
+			for _, unit := range utf16.Encode([]rune{runes[gid]}) {
+				fmt.Fprintf(&b, "%04X", unit)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// writePDFImage embeds an image as an RGB XObject, with a soft mask when it
+// has transparency.
+func writePDFImage(doc *pdfDocument, img image.Image) int {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rgb := make([]byte, 0, w*h*3)
+	alpha := make([]byte, 0, w*h)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xff {
+				opaque = false
+			}
+		}
+	}
+
+	id := doc.reserve()
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8", w, h)
+	if !opaque {
+		mask := doc.reserve()
+		doc.stream(mask, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8", w, h), alpha)
+		dict += fmt.Sprintf(" /SMask %d 0 R", mask)
+	}
+	doc.stream(id, dict, rgb)
+	return id
+}
+
+func pdfColor(c color.NRGBA) string {
+	return fmt.Sprintf("%s %s %s", pdfNum(float64(c.R)/255), pdfNum(float64(c.G)/255), pdfNum(float64(c.B)/255))
+}
+
+// pdfNum formats a number with at most three decimals.
+func pdfNum(v float64) string {
+	v = math.Round(v*1000) / 1000
+	if v == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// pdfTextString encodes s as a PDF text string in UTF-16BE with a byte
+// order mark, written in hex so no escaping is needed.
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	b.WriteString(">")
+	return b.String()
+}
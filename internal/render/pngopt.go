@@ -0,0 +1,140 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sort"
+)
+
+// pngChunk is a chunk of a PNG stream; raw is the whole chunk including
+// its length, type and CRC.
+type pngChunk struct {
+	kind string
+	data []byte
+	raw  []byte
+}
+
+// pngChunks splits a PNG stream into its chunks up to IEND.
+func pngChunks(stream []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(stream, pngSignature) {
+		return nil, fmt.Errorf("invalid png stream")
+	}
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+8 <= len(stream) {
+		length := int(binary.BigEndian.Uint32(stream[pos:]))
+		if length < 0 || pos+12+length > len(stream) {
+			return nil, fmt.Errorf("invalid png stream")
+		}
+		chunk := pngChunk{
+			kind: string(stream[pos+4 : pos+8]),
+			data: stream[pos+8 : pos+8+length],
+			raw:  stream[pos : pos+12+length],
+		}
+		chunks = append(chunks, chunk)
+		pos += 12 + length
+		if chunk.kind == "IEND" {
+			return chunks, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid png stream")
+}
+
+// OptimizePNG re-encodes a PNG stream losslessly to make it smaller.
+// Images with at most 256 colors, counting transparency, become
+// paletted; opaque images lose their alpha channel; the pixels are
+// compressed at the highest level. Text chunks such as the embedded spec
+// are kept. The output depends only on the input, and the input is
+// returned as is when it is already smaller or animated.
+func OptimizePNG(stream []byte) ([]byte, error) {
+	chunks, err := pngChunks(stream)
+	if err != nil {
+		return nil, err
+	}
+	var ancillary [][]byte
+	for _, chunk := range chunks {
+		switch chunk.kind {
+		case "acTL":
+			return stream, nil
+		case "IHDR", "PLTE", "tRNS", "IDAT", "IEND":
+		default:
+			ancillary = append(ancillary, chunk.raw)
+		}
+	}
+	img, err := png.Decode(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	// The encoder writes RGB without alpha for opaque images by itself.
+	if paletted := palettize(img); paletted != nil {
+		err = encoder.Encode(&out, paletted)
+	} else {
+		err = encoder.Encode(&out, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	optimized, err := spliceAfterIHDR(out.Bytes(), bytes.Join(ancillary, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(optimized) >= len(stream) {
+		return stream, nil
+	}
+	return optimized, nil
+}
+
+// palettize returns img as a paletted image when it has at most 256
+// distinct colors, and nil otherwise. Translucent colors come first so
+// the tRNS chunk stays short; the rest are ordered by frequency and then
+// by value, which keeps the palette deterministic.
+func palettize(img image.Image) *image.Paletted {
+	b := img.Bounds()
+	counts := map[color.NRGBA]int{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			counts[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)]++
+			if len(counts) > 256 {
+				return nil
+			}
+		}
+	}
+	colors := make([]color.NRGBA, 0, len(counts))
+	for c := range counts {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		a, b := colors[i], colors[j]
+		if (a.A < 255) != (b.A < 255) {
+			return a.A < 255
+		}
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return nrgbaKey(a) < nrgbaKey(b)
+	})
+	palette := make(color.Palette, len(colors))
+	index := make(map[color.NRGBA]uint8, len(colors))
+	for i, c := range colors {
+		palette[i] = c
+		index[c] = uint8(i)
+	}
+	out := image.NewPaletted(b, palette)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.Pix[out.PixOffset(x, y)] = index[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)]
+		}
+	}
+	return out
+}
+
+func nrgbaKey(c color.NRGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
@@ -0,0 +1,296 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// colorBox is a box of the RGB color space in median cut.
+type colorBox struct {
+	colors []weightedColor
+	count  int
+}
+
+type weightedColor struct {
+	rgb   [3]uint8
+	count int
+}
+
+// medianCutPalette builds a palette of at most maxColors colors shared by
+// all images. Seed colors found in the images are kept exactly and the
+// rest of the palette is cut from the other pixels, so flat theme colors do
+// not drift towards their anti-aliased edges. Pixels with alpha below 128
+// are not sampled; when there are any, the last palette entry is
+// transparent.
+func medianCutPalette(images []*image.RGBA, maxColors int, seed ...color.Color) color.Palette {
+	histogram := map[[3]uint8]int{}
+	transparent := false
+	for _, img := range images {
+		b := img.Bounds()
+		// Large frames are sampled on a grid; flat UI colors survive it.
+		step := 1
+		for b.Dx()/step*(b.Dy()/step) > 250000 {
+			step++
+		}
+		for y := b.Min.Y; y < b.Max.Y; y += step {
+			for x := b.Min.X; x < b.Max.X; x += step {
+				i := img.PixOffset(x, y)
+				if img.Pix[i+3] < 128 {
+					transparent = true
+					continue
+				}
+				histogram[unpremultiply(img.Pix[i:i+4])]++
+			}
+		}
+	}
+	if transparent {
+		maxColors--
+	}
+
+	palette := color.Palette{}
+	for _, c := range seed {
+		if len(palette) == maxColors-1 {
+			// Leave room for at least one cut color.
+			break
+		}
+		nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+		rgb := [3]uint8{nrgba.R, nrgba.G, nrgba.B}
+		if histogram[rgb] == 0 {
+			continue
+		}
+		delete(histogram, rgb)
+		palette = append(palette, color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255})
+	}
+	maxColors -= len(palette)
+
+	box := colorBox{}
+	for rgb, count := range histogram {
+		box.colors = append(box.colors, weightedColor{rgb: rgb, count: count})
+		box.count += count
+	}
+	// Map iteration order is random; sort so the palette is deterministic.
+	sort.Slice(box.colors, func(i, j int) bool {
+		a, b := box.colors[i].rgb, box.colors[j].rgb
+		return a[0] < b[0] || a[0] == b[0] && (a[1] < b[1] || a[1] == b[1] && a[2] < b[2])
+	})
+	boxes := []colorBox{box}
+	for len(boxes) < maxColors {
+		// Split the box with the most pixels that still has two colors.
+		// The second half of the splits weighs pixels by the volume of
+		// the box, so small but colorful areas such as the avatar get
+		// colors too.
+		byVolume := len(boxes) >= maxColors/2
+		best, bestScore := -1, 0
+		for i, b := range boxes {
+			if len(b.colors) < 2 {
+				continue
+			}
+			score := b.count
+			if byVolume {
+				score *= b.volume()
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+		low, high := boxes[best].split()
+		boxes[best] = low
+		boxes = append(boxes, high)
+	}
+
+	for _, b := range boxes {
+		if len(b.colors) == 0 {
+			continue
+		}
+		var sum [3]int
+		for _, c := range b.colors {
+			for ch := 0; ch < 3; ch++ {
+				sum[ch] += int(c.rgb[ch]) * c.count
+			}
+		}
+		palette = append(palette, color.RGBA{
+			R: uint8((sum[0] + b.count/2) / b.count),
+			G: uint8((sum[1] + b.count/2) / b.count),
+			B: uint8((sum[2] + b.count/2) / b.count),
+			A: 255,
+		})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.RGBA{A: 255})
+	}
+	if transparent {
+		palette = append(palette, color.RGBA{})
+	}
+	return palette
+}
+
+// volume is the size of the box's bounding box in the color space.
+func (b colorBox) volume() int {
+	v := 1
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, c := range b.colors {
+			lo, hi = min(lo, int(c.rgb[ch])), max(hi, int(c.rgb[ch]))
+		}
+		v *= hi - lo + 1
+	}
+	return v
+}
+
+// split cuts the box at the weighted median of its widest channel.
+func (b colorBox) split() (colorBox, colorBox) {
+	channel, widest := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, c := range b.colors {
+			lo, hi = min(lo, int(c.rgb[ch])), max(hi, int(c.rgb[ch]))
+		}
+		if hi-lo > widest {
+			channel, widest = ch, hi-lo
+		}
+	}
+	colors := append([]weightedColor(nil), b.colors...)
+	sort.SliceStable(colors, func(i, j int) bool { return colors[i].rgb[channel] < colors[j].rgb[channel] })
+
+	cut, seen := 1, 0
+	for i, c := range colors[:len(colors)-1] {
+		seen += c.count
+		cut = i + 1
+		if seen*2 >= b.count {
+			break
+		}
+	}
+	low, high := colorBox{colors: colors[:cut]}, colorBox{colors: colors[cut:]}
+	for _, c := range low.colors {
+		low.count += c.count
+	}
+	high.count = b.count - low.count
+	return low, high
+}
+
+func unpremultiply(p []uint8) [3]uint8 {
+	a := int(p[3])
+	if a == 255 || a == 0 {
+		return [3]uint8{p[0], p[1], p[2]}
+	}
+	return [3]uint8{
+		uint8(min(255, (int(p[0])*255+a/2)/a)),
+		uint8(min(255, (int(p[1])*255+a/2)/a)),
+		uint8(min(255, (int(p[2])*255+a/2)/a)),
+	}
+}
+
+// paletteMapper maps pixels to their nearest palette entry, caching lookups
+// since cards have few distinct colors.
+type paletteMapper struct {
+	palette     color.Palette
+	transparent int
+	cache       map[[3]uint8]uint8
+}
+
+func newPaletteMapper(palette color.Palette) *paletteMapper {
+	m := &paletteMapper{palette: palette, transparent: -1, cache: map[[3]uint8]uint8{}}
+	if _, _, _, a := palette[len(palette)-1].RGBA(); a == 0 {
+		m.transparent = len(palette) - 1
+	}
+	return m
+}
+
+func (m *paletteMapper) index(p []uint8) uint8 {
+	if p[3] < 128 && m.transparent >= 0 {
+		return uint8(m.transparent)
+	}
+	return m.nearest(unpremultiply(p))
+}
+
+// nearest returns the opaque palette entry closest to rgb.
+func (m *paletteMapper) nearest(rgb [3]uint8) uint8 {
+	if i, ok := m.cache[rgb]; ok {
+		return i
+	}
+	best, bestDist := 0, -1
+	for i, c := range m.palette {
+		if i == m.transparent {
+			continue
+		}
+		pc := c.(color.RGBA)
+		dr, dg, db := int(pc.R)-int(rgb[0]), int(pc.G)-int(rgb[1]), int(pc.B)-int(rgb[2])
+		if dist := dr*dr + dg*dg + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	m.cache[rgb] = uint8(best)
+	return uint8(best)
+}
+
+// paletted converts the r part of img using the mapper.
+func (m *paletteMapper) paletted(img *image.RGBA, r image.Rectangle) *image.Paletted {
+	out := image.NewPaletted(r, m.palette)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			out.Pix[out.PixOffset(x, y)] = m.index(img.Pix[i : i+4])
+		}
+	}
+	return out
+}
+
+// dithered converts img using the mapper with Floyd–Steinberg dithering:
+// each pixel's quantization error is spread to its unvisited neighbors.
+// Transparent pixels neither take nor pass on error.
+func (m *paletteMapper) dithered(img *image.RGBA) *image.Paletted {
+	r := img.Bounds()
+	out := image.NewPaletted(r, m.palette)
+	width := r.Dx()
+	// Errors of the current and the next row, with a pixel of margin on
+	// both sides.
+	cur, next := make([][3]int32, width+2), make([][3]int32, width+2)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			o := out.PixOffset(x, y)
+			if p[3] < 128 && m.transparent >= 0 {
+				out.Pix[o] = uint8(m.transparent)
+				continue
+			}
+			e := cur[x-r.Min.X+1]
+			rgb := unpremultiply(p[:4])
+			var want [3]int32
+			for ch := range rgb {
+				// Errors are in 1/16 steps.
+				want[ch] = min(255, max(0, int32(rgb[ch])+(e[ch]+8)>>4))
+			}
+			i := m.nearest([3]uint8{uint8(want[0]), uint8(want[1]), uint8(want[2])})
+			out.Pix[o] = i
+			got := m.palette[i].(color.RGBA)
+			diff := [3]int32{want[0] - int32(got.R), want[1] - int32(got.G), want[2] - int32(got.B)}
+			c := x - r.Min.X + 1
+			for ch := range diff {
+				cur[c+1][ch] += diff[ch] * 7
+				next[c-1][ch] += diff[ch] * 3
+				next[c][ch] += diff[ch] * 5
+				next[c+1][ch] += diff[ch]
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+	return out
+}
+
+// Quantize converts img to a paletted image of at most maxColors colors
+// chosen by median cut.
+func Quantize(img image.Image, maxColors int) *image.Paletted {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(img.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	mapper := newPaletteMapper(medianCutPalette([]*image.RGBA{rgba}, maxColors))
+	return mapper.paletted(rgba, rgba.Bounds())
+}
@@ -1,9 +1,31 @@
 package render
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/webp"
 )
 
 func TestWrapTextBreaksLines(t *testing.T) {
@@ -94,7 +116,7 @@ func TestIconsLoad(t *testing.T) {
 	if !strings.Contains(icon, "<svg") {
 		t.Fatalf("icon svg missing <svg>")
 	}
-	img, err := rasterizeIcon("like", "#000000", 16)
+	img, err := rasterizeIcon("like", "#000000", 16, 0, 0)
 	if err != nil {
 		t.Fatalf("rasterizeIcon: %v", err)
 	}
@@ -195,3 +217,1408 @@ func extractHTMLWidth(html string) (int, bool) {
 	}
 	return width, true
 }
+
+func TestEntityRunsHighlightSpans(t *testing.T) {
+	fonts, err := loadFontSet(DefaultOptions())
+	if err != nil {
+		t.Fatalf("loadFontSet: %v", err)
+	}
+	defer fonts.Close()
+
+	text := "hi @jack and #go"
+	entities := []Entity{
+		{Type: "mention", Start: 3, End: 8},
+		{Type: "hashtag", Start: 13, End: 16},
+	}
+	runs := buildTextRuns(text, []string{text}, entities, 10, fonts.Text)
+	if len(runs) != 1 || len(runs[0]) != 4 {
+		t.Fatalf("unexpected runs: %v", runs)
+	}
+	if !runs[0][1].Entity || runs[0][1].Text != "@jack" || runs[0][1].X <= 10 {
+		t.Fatalf("unexpected mention run: %+v", runs[0][1])
+	}
+
+	html := formatHTMLText(text, entities, "")
+	if !strings.Contains(string(html), `<span class="entity">@jack</span>`) {
+		t.Fatalf("expected highlighted mention in html, got %s", html)
+	}
+}
+
+func TestFormatPostTime(t *testing.T) {
+	posted := time.Date(2017, 12, 6, 1, 55, 0, 0, time.UTC)
+	opts := DefaultOptions()
+	opts.TimeZone = "Asia/Tokyo"
+
+	if got := formatPostTime(posted, "en", opts); got != "10:55 AM · Dec 6, 2017" {
+		t.Fatalf("unexpected english date: %s", got)
+	}
+	if got := formatPostTime(posted, "ja-JP", opts); got != "午前10:55 · 2017年12月6日" {
+		t.Fatalf("unexpected japanese date: %s", got)
+	}
+
+	restore := timeNow
+	defer func() { timeNow = restore }()
+	opts.DateStyle = "relative"
+	timeNow = func() time.Time { return posted.Add(2*time.Hour + 5*time.Minute) }
+	if got := formatPostTime(posted, "en", opts); got != "2h" {
+		t.Fatalf("unexpected relative date: %s", got)
+	}
+	timeNow = func() time.Time { return posted.AddDate(0, 0, 14) }
+	if got := formatPostTime(posted, "ja", opts); got != "12月6日" {
+		t.Fatalf("unexpected relative japanese date: %s", got)
+	}
+	timeNow = func() time.Time { return posted.AddDate(1, 0, 0) }
+	if got := formatPostTime(posted, "en", opts); got != "Dec 6, 2017" {
+		t.Fatalf("unexpected relative date across years: %s", got)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	fromUnix, err := ParseTimestamp("1512525300")
+	if err != nil {
+		t.Fatalf("ParseTimestamp: %v", err)
+	}
+	fromRFC, err := ParseTimestamp("2017-12-06T10:55:00+09:00")
+	if err != nil {
+		t.Fatalf("ParseTimestamp: %v", err)
+	}
+	if !fromUnix.Equal(fromRFC) {
+		t.Fatalf("expected equal instants, got %v and %v", fromUnix, fromRFC)
+	}
+	if _, err := ParseTimestamp("yesterday"); err == nil {
+		t.Fatalf("expected error for invalid timestamp")
+	}
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	data := TweetData{
+		Text:   "WebP preview with @mention and https://example.com",
+		Name:   "Example User",
+		Handle: "example",
+	}
+	img, err := RenderImage(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, "webp"); err != nil {
+		t.Fatalf("EncodeImage: %v", err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("webp.Decode: %v", err)
+	}
+	if decoded.Bounds().Size() != img.Bounds().Size() {
+		t.Fatalf("unexpected size: %v", decoded.Bounds())
+	}
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			want := color.NRGBAModel.Convert(img.At(x, y))
+			got := color.NRGBAModel.Convert(decoded.At(x-img.Bounds().Min.X, y-img.Bounds().Min.Y))
+			if want != got {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeWebPLargeImage(t *testing.T) {
+	// Over a million pixels, with the first pixels repeated at the end so
+	// the best match lies beyond the VP8L distance window.
+	img := image.NewNRGBA(image.Rect(0, 0, 1100, 1000))
+	seed := uint32(1)
+	for i := 0; i < len(img.Pix); i += 4 {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(seed>>24), uint8(seed>>16), uint8(seed>>8), 0xFF
+	}
+	copy(img.Pix[len(img.Pix)-800:], img.Pix[:800])
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, img, WebPOptions{}); err != nil {
+		t.Fatalf("EncodeWebP: %v", err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("webp.Decode: %v", err)
+	}
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 1100; x++ {
+			if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != img.NRGBAAt(x, y) {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, img.NRGBAAt(x, y))
+			}
+		}
+	}
+}
+
+func TestEncodeWebPNearLosslessAndAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 11))
+	for y := 0; y < 11; y++ {
+		for x := 0; x < 37; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 23), B: uint8(x * y), A: uint8(255 - x)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, img, WebPOptions{NearLossless: true, Quality: 50}); err != nil {
+		t.Fatalf("EncodeWebP: %v", err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("webp.Decode: %v", err)
+	}
+	for y := 0; y < 11; y++ {
+		for x := 0; x < 37; x++ {
+			want := img.NRGBAAt(x, y)
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			if absDiff(want.R, got.R) > 4 || absDiff(want.G, got.G) > 4 || absDiff(want.B, got.B) > 4 || absDiff(want.A, got.A) > 4 {
+				t.Fatalf("pixel (%d,%d) = %v, want about %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeWebPLossy(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 70, 45))
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 70; x++ {
+			c := color.NRGBA{R: uint8(x * 3), G: uint8(y * 5), B: 200, A: 255}
+			if x >= 20 && x < 50 && y >= 10 && y < 30 {
+				c = color.NRGBA{R: 20, G: 30, B: 40, A: uint8(255 - y)}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	for _, transparent := range []bool{false, true} {
+		src := img
+		if !transparent {
+			src = &image.NRGBA{Pix: append([]uint8(nil), img.Pix...), Stride: img.Stride, Rect: img.Rect}
+			for i := 3; i < len(src.Pix); i += 4 {
+				src.Pix[i] = 0xff
+			}
+		}
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, src, WebPOptions{Lossy: true, Quality: 90}); err != nil {
+			t.Fatalf("EncodeWebP: %v", err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte("VP8 ")) || bytes.Contains(buf.Bytes(), []byte("ALPH")) != transparent {
+			t.Fatalf("unexpected chunks (transparent=%v)", transparent)
+		}
+		decoded, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("webp.Decode: %v", err)
+		}
+		var ycc *image.YCbCr
+		switch m := decoded.(type) {
+		case *image.YCbCr:
+			ycc = m
+		case *image.NYCbCrA:
+			ycc = &m.YCbCr
+			for y := 0; y < 45; y++ {
+				for x := 0; x < 70; x++ {
+					if got, want := m.A[m.AOffset(x, y)], src.NRGBAAt(x, y).A; got != want {
+						t.Fatalf("alpha at (%d,%d) = %d, want %d", x, y, got, want)
+					}
+				}
+			}
+		}
+		if ycc == nil || (decoded.ColorModel() == color.NYCbCrAModel) != transparent {
+			t.Fatalf("decoded %T (transparent=%v)", decoded, transparent)
+		}
+		// VP8 uses studio-range BT.601, unlike image.YCbCr.
+		var total int
+		for y := 0; y < 45; y++ {
+			for x := 0; x < 70; x++ {
+				yy := 1.164 * (float64(ycc.Y[ycc.YOffset(x, y)]) - 16)
+				cb := float64(ycc.Cb[ycc.COffset(x, y)]) - 128
+				cr := float64(ycc.Cr[ycc.COffset(x, y)]) - 128
+				got := [3]float64{yy + 1.596*cr, yy - 0.392*cb - 0.813*cr, yy + 2.017*cb}
+				want := src.NRGBAAt(x, y)
+				for i, v := range [3]uint8{want.R, want.G, want.B} {
+					total += int(math.Abs(math.Round(math.Max(0, math.Min(255, got[i]))) - float64(v)))
+				}
+			}
+		}
+		if mean := float64(total) / (70 * 45 * 3); mean > 3 {
+			t.Fatalf("mean error %.2f (transparent=%v)", mean, transparent)
+		}
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestRenderPDF(t *testing.T) {
+	data := TweetData{
+		Text:     "PDF preview with @mention",
+		Name:     "Example User",
+		Handle:   "example",
+		Verified: true,
+		Date:     "10:55 AM · Dec 6, 2017",
+	}
+	pdf, err := RenderPDF(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderPDF: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatalf("output is not a pdf")
+	}
+	for _, want := range []string{"/FontFile2", "/ToUnicode", "/CIDToGIDMap /Identity"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Fatalf("expected %s in pdf", want)
+		}
+	}
+	if bytes.Contains(pdf, []byte("/W [0 [")) {
+		t.Fatalf("unexpected .notdef width without missing glyphs")
+	}
+	// A character the font lacks renders as .notdef with its real width.
+	missing := data
+	missing.Text = "Missing \u6f22 glyph"
+	notdef, err := RenderPDF(missing, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderPDF: %v", err)
+	}
+	if !bytes.Contains(notdef, []byte("/W [0 [")) {
+		t.Fatalf("expected the .notdef width in the W array")
+	}
+
+	xref := bytes.LastIndex(pdf, []byte("\nxref\n")) + 1
+	var count int
+	if _, err := fmt.Sscanf(string(pdf[xref:]), "xref\n0 %d\n", &count); err != nil {
+		t.Fatalf("failed to read xref: %v", err)
+	}
+	entries := strings.Split(string(pdf[xref:]), "\n")[3 : 3+count-1]
+	for i, entry := range entries {
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil {
+			t.Fatalf("bad xref entry %q", entry)
+		}
+		if want := fmt.Sprintf("%d 0 obj", i+1); !bytes.HasPrefix(pdf[offset:], []byte(want)) {
+			t.Fatalf("xref entry %d does not point at its object", i+1)
+		}
+	}
+}
+
+func TestSubsetTrueType(t *testing.T) {
+	original, err := sfnt.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatalf("sfnt.Parse: %v", err)
+	}
+	var buf sfnt.Buffer
+	keepGID, _ := original.GlyphIndex(&buf, 'A')
+	dropGID, _ := original.GlyphIndex(&buf, 'B')
+
+	subset, err := subsetTrueType(goregular.TTF, map[uint16]bool{uint16(keepGID): true})
+	if err != nil {
+		t.Fatalf("subsetTrueType: %v", err)
+	}
+	if len(subset) >= len(goregular.TTF)/2 {
+		t.Fatalf("subset is not smaller: %d bytes", len(subset))
+	}
+	parsed, err := sfnt.Parse(subset)
+	if err != nil {
+		t.Fatalf("sfnt.Parse subset: %v", err)
+	}
+	ppem := fixed.I(int(parsed.UnitsPerEm()))
+	kept, err := parsed.LoadGlyph(&buf, keepGID, ppem, nil)
+	if err != nil || len(kept) == 0 {
+		t.Fatalf("kept glyph lost its outline: %v", err)
+	}
+	dropped, err := parsed.LoadGlyph(&buf, dropGID, ppem, nil)
+	if err != nil || len(dropped) != 0 {
+		t.Fatalf("dropped glyph still has an outline: %v", err)
+	}
+	if _, err := subsetTrueType([]byte("OTTO\x00\x00\x00\x00\x00\x00\x00\x00"), nil); err != errNotTrueType {
+		t.Fatalf("expected errNotTrueType, got %v", err)
+	}
+}
+
+func TestParsePathData(t *testing.T) {
+	segments, err := parsePathData("M1.5.5l2-1h1v2s1 1 2 0z")
+	if err != nil {
+		t.Fatalf("parsePathData: %v", err)
+	}
+	ops := ""
+	for _, seg := range segments {
+		ops += string(seg.Op)
+	}
+	if ops != "MLLLCZ" {
+		t.Fatalf("unexpected ops: %s", ops)
+	}
+	if end := segments[4].P[2]; end != [2]float64{6.5, 1.5} {
+		t.Fatalf("unexpected curve end: %v", end)
+	}
+	if _, err := parsePathData("M0 0A1 1 0 0 1 2 2"); err == nil {
+		t.Fatalf("expected arcs to be rejected")
+	}
+}
+
+func TestRenderImageScale(t *testing.T) {
+	data := TweetData{
+		Text:     "HiDPI preview",
+		Name:     "Example User",
+		Handle:   "example",
+		Verified: true,
+	}
+	base, err := RenderImage(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.Scale = 2
+	scaled, err := RenderImage(data, opts)
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	if scaled.Bounds().Dx() != base.Bounds().Dx()*2 || scaled.Bounds().Dy() != base.Bounds().Dy()*2 {
+		t.Fatalf("expected %v doubled, got %v", base.Bounds(), scaled.Bounds())
+	}
+
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if want := fmt.Sprintf("width=\"%d\"", base.Bounds().Dx()); !strings.Contains(svg, want) {
+		t.Fatalf("svg should keep logical size %s", want)
+	}
+}
+
+func TestTransparentRoundedCorners(t *testing.T) {
+	data := TweetData{Text: "Transparent", Name: "Example User", Handle: "example"}
+	opts := DefaultOptions()
+	opts.Transparent = true
+	img, err := RenderImage(data, opts)
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	b := img.Bounds()
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Fatalf("expected transparent corner, got alpha %d", a)
+	}
+	if _, _, _, a := img.At(b.Dx()/2, b.Dy()/2).RGBA(); a != 0xffff {
+		t.Fatalf("expected opaque card, got alpha %d", a)
+	}
+
+	opaque, err := RenderImage(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	if _, _, _, a := opaque.At(0, 0).RGBA(); a != 0xffff {
+		t.Fatalf("default output should stay opaque")
+	}
+
+	// SVG fills the corners like raster output unless it is transparent.
+	background := `<rect width="960" height="`
+	svg, err := RenderSVG(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if !strings.Contains(svg, background) {
+		t.Fatalf("expected a full background rect in svg")
+	}
+	transparent, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if strings.Contains(transparent, background) {
+		t.Fatalf("transparent svg should not have a background rect")
+	}
+}
+
+func TestBorderAndCornerOptions(t *testing.T) {
+	data := TweetData{Text: "Square", Name: "Example User", Handle: "example"}
+	opts := DefaultOptions()
+	opts.NoBorder = true
+	opts.CornerRadius = -1
+	opts.Transparent = true
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if !strings.Contains(svg, `rx="0"`) || strings.Contains(svg, `stroke="`+opts.Theme.Border+`"`) {
+		t.Fatalf("svg should have square corners without a border")
+	}
+	html, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	for _, want := range []string{"background: transparent;", "border: none;", "border-radius: 0px;"} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected %q in html", want)
+		}
+	}
+	img, err := RenderImage(data, opts)
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0xffff {
+		t.Fatalf("square corners should be filled")
+	}
+}
+
+func TestParseCanvasSize(t *testing.T) {
+	cases := map[string][2]int{"og": {1200, 630}, "Square": {1080, 1080}, "story": {1080, 1920}, "1600x900": {1600, 900}}
+	for value, want := range cases {
+		width, height, err := ParseCanvasSize(value)
+		if err != nil || width != want[0] || height != want[1] {
+			t.Fatalf("ParseCanvasSize(%q) = %d, %d, %v", value, width, height, err)
+		}
+	}
+	for _, value := range []string{"", "big", "0x100", "100"} {
+		if _, _, err := ParseCanvasSize(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestCanvasMode(t *testing.T) {
+	data := TweetData{Text: "On a canvas", Name: "Example User", Handle: "example"}
+	opts := DefaultOptions()
+	opts.Canvas.Width, opts.Canvas.Height = 1200, 630
+	opts.Canvas.Background = "#FF0000"
+
+	img, err := RenderImage(data, opts)
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 1200 || b.Dy() != 630 {
+		t.Fatalf("unexpected canvas size %v", b)
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0xffff || g != 0 || b != 0 {
+		t.Fatalf("expected background color at the corner")
+	}
+	if r, g, b, _ := img.At(600, 315).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Fatalf("expected the card in the center")
+	}
+
+	opts.Canvas.Background = "#1DA1F2,#794BC4"
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	for _, want := range []string{`width="1200" height="630"`, "<linearGradient", "<feDropShadow", `<svg x="`} {
+		if !strings.Contains(svg, want) {
+			t.Fatalf("expected %q in svg", want)
+		}
+	}
+	html, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	for _, want := range []string{`class="canvas"`, "linear-gradient(135deg, #1DA1F2, #794BC4)", "transform: scale("} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected %q in html", want)
+		}
+	}
+	if _, err := RenderPDF(data, opts); err == nil {
+		t.Fatalf("pdf should reject canvas mode")
+	}
+}
+
+func TestSVGTextPaths(t *testing.T) {
+	data := TweetData{Text: "Outlined text", Name: "Example User", Handle: "example", Date: "Jan 1, 2024"}
+	opts := DefaultOptions()
+	opts.SVGText = "paths"
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if strings.Contains(svg, "<text") || strings.Contains(svg, "font-family") {
+		t.Fatalf("paths mode should not emit text elements")
+	}
+	if !strings.Contains(svg, `<path d="M`) || !strings.Contains(svg, `fill="`+opts.Theme.Text+`"`) {
+		t.Fatalf("expected outlined glyphs in svg")
+	}
+
+	opts.SVGText = "glyphs"
+	if _, err := RenderSVG(data, opts); err == nil {
+		t.Fatalf("expected error for unknown svg text mode")
+	}
+}
+
+func TestEmbeddedFonts(t *testing.T) {
+	fontPath := filepath.Join(t.TempDir(), "regular.ttf")
+	if err := os.WriteFile(fontPath, goregular.TTF, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	data := TweetData{Text: "Embedded", Name: "Example User", Handle: "example"}
+	opts := DefaultOptions()
+	opts.FontPath = fontPath
+
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	html, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	for name, out := range map[string]string{"svg": svg, "html": html} {
+		if strings.Count(out, "@font-face") != 2 {
+			t.Fatalf("%s: expected regular and bold @font-face rules", name)
+		}
+		if !regexp.MustCompile(`xpost-embedded-[0-9a-f]{12}, `).MatchString(out) {
+			t.Fatalf("%s: font-family should start with the embedded font", name)
+		}
+	}
+
+	match := regexp.MustCompile(`data:font/ttf;base64,([A-Za-z0-9+/=]+)`).FindStringSubmatch(svg)
+	if match == nil {
+		t.Fatalf("expected a TrueType data URI")
+	}
+	subset, err := base64.StdEncoding.DecodeString(match[1])
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	if len(subset) >= len(goregular.TTF) {
+		t.Fatalf("embedded font should be subset: %d bytes", len(subset))
+	}
+	if _, err := sfnt.Parse(subset); err != nil {
+		t.Fatalf("embedded font does not parse: %v", err)
+	}
+
+	// Cards sharing a document need distinct families for their subsets.
+	fragments := opts
+	fragments.HTMLMode = "fragment"
+	other, err := RenderHTML(TweetData{Text: "Other glyphs", Name: "Example User", Handle: "example"}, fragments)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	family := regexp.MustCompile(`font-family: (xpost-embedded-[0-9a-f]+);`)
+	if family.FindStringSubmatch(html)[1] == family.FindStringSubmatch(other)[1] {
+		t.Fatalf("cards with different glyphs should not share a font-family")
+	}
+	collage, err := RenderCollageSVG([]TweetData{data, {Text: "Other glyphs", Name: "Example User", Handle: "example"}}, opts, CollageOptions{})
+	if err != nil {
+		t.Fatalf("RenderCollageSVG: %v", err)
+	}
+	names := map[string]bool{}
+	for _, m := range family.FindAllStringSubmatch(collage, -1) {
+		names[m[1]] = true
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected one font-family per collage card, got %d", len(names))
+	}
+
+	plain, err := RenderHTML(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	if strings.Contains(plain, "@font-face") || strings.Contains(plain, "ZgotmplZ") {
+		t.Fatalf("default html should keep the plain font-family")
+	}
+}
+
+func TestRenderAnimation(t *testing.T) {
+	data := TweetData{Text: "Typing in", Name: "Example User", Handle: "example", LikeCount: "1,200"}
+	opts := DefaultOptions()
+	opts.Animation = AnimationOptions{FPS: 10, Duration: 2 * time.Second}
+	frames, err := RenderAnimation(data, opts)
+	if err != nil {
+		t.Fatalf("RenderAnimation: %v", err)
+	}
+	var total time.Duration
+	for _, frame := range frames {
+		total += frame.Delay
+	}
+	if total != 2*time.Second || len(frames) >= 20 {
+		t.Fatalf("expected held frames to merge: %d frames, %s", len(frames), total)
+	}
+	final, err := RenderImage(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	if !bytes.Equal(frames[len(frames)-1].Image.Pix, final.Pix) {
+		t.Fatalf("last frame should match the still image")
+	}
+	if changedBounds(frames[0].Image, final).Empty() {
+		t.Fatalf("first frame should differ from the final card")
+	}
+
+	var gifBuf bytes.Buffer
+	if err := EncodeAnimatedGIF(&gifBuf, frames, false); err != nil {
+		t.Fatalf("EncodeAnimatedGIF: %v", err)
+	}
+	decoded, err := gif.DecodeAll(&gifBuf)
+	if err != nil {
+		t.Fatalf("gif.DecodeAll: %v", err)
+	}
+	if len(decoded.Image) != len(frames) {
+		t.Fatalf("expected %d gif frames, got %d", len(frames), len(decoded.Image))
+	}
+
+	var apngBuf bytes.Buffer
+	if err := EncodeAPNG(&apngBuf, frames, false); err != nil {
+		t.Fatalf("EncodeAPNG: %v", err)
+	}
+	if !bytes.Contains(apngBuf.Bytes(), []byte("acTL")) || !bytes.Contains(apngBuf.Bytes(), []byte("fdAT")) {
+		t.Fatalf("expected animation chunks in apng")
+	}
+	// Decoders without APNG support show the first frame.
+	first, err := png.Decode(&apngBuf)
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	if first.Bounds() != final.Bounds() {
+		t.Fatalf("unexpected apng size %v", first.Bounds())
+	}
+}
+
+func TestAnimationHelpers(t *testing.T) {
+	if got := truncateRunes("日本語テキスト", 3); got != "日本語" {
+		t.Fatalf("truncateRunes = %q", got)
+	}
+	value, format, ok := parseLikeCount("12,345")
+	if !ok || value != 12345 || format(1234) != "1,234" {
+		t.Fatalf("parseLikeCount failed: %d %v", value, ok)
+	}
+	if _, _, ok := parseLikeCount("1.2K"); ok {
+		t.Fatalf("abbreviated counts should not be countable")
+	}
+}
+
+func TestAnimatedSVG(t *testing.T) {
+	data := TweetData{Text: "First line\nSecond line", Name: "Example User", Handle: "example", CTA: "Read more"}
+	opts := DefaultOptions()
+	opts.Animation = AnimationOptions{Duration: 3 * time.Second}
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	prefix := regexp.MustCompile(`@keyframes (xpost-[0-9a-f]{8}-)line-0 `).FindStringSubmatch(svg)
+	if prefix == nil {
+		t.Fatalf("expected prefixed keyframes in animated svg")
+	}
+	p := prefix[1]
+	for _, want := range []string{
+		"@keyframes " + p + "line-1", `class="` + p + `anim ` + p + `like-pop"`, `class="` + p + `anim ` + p + `cta-fade"`,
+		"animation-iteration-count: infinite", "prefers-reduced-motion: reduce",
+	} {
+		if !strings.Contains(svg, want) {
+			t.Fatalf("expected %q in animated svg", want)
+		}
+	}
+	if regexp.MustCompile(`[ ."](anim|line-0|like-pop|cta-fade)[ "{]`).MatchString(svg) {
+		t.Fatalf("found an unprefixed animation name")
+	}
+	if strings.Contains(svg, "<script") {
+		t.Fatalf("animated svg should not use scripts")
+	}
+
+	opts.Animation.Once = true
+	once, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if !strings.Contains(once, "animation-iteration-count: 1;") {
+		t.Fatalf("expected a single iteration")
+	}
+	if strings.Contains(once, p+"anim") {
+		t.Fatalf("cards with different animations should not share names")
+	}
+
+	static, err := RenderSVG(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if strings.Contains(static, "@keyframes") || strings.Contains(static, "-anim ") {
+		t.Fatalf("static svg should not be animated")
+	}
+}
+
+func TestRenderLayout(t *testing.T) {
+	data := TweetData{
+		Text:     strings.Repeat("Layout boxes must stay inside the card. ", 6),
+		Name:     "Example User",
+		Handle:   "example",
+		Date:     "Dec 6, 2017",
+		CTA:      "Read more",
+		Verified: true,
+	}
+	opts := DefaultOptions()
+	doc, err := RenderLayout(data, opts)
+	if err != nil {
+		t.Fatalf("RenderLayout: %v", err)
+	}
+	counts := map[string]int{}
+	for _, el := range doc.Elements {
+		counts[el.Type]++
+		b := el.Box
+		if b.X < 0 || b.Y < 0 || b.X+b.Width > float64(doc.Width) || b.Y+b.Height > float64(doc.Height) {
+			t.Fatalf("%s %q overflows the card: %+v", el.Type, el.Text, b)
+		}
+	}
+	if counts["text"] < 2 || counts["action"] != 3 || counts["cta"] != 1 || counts["date"] != 1 {
+		t.Fatalf("unexpected elements: %v", counts)
+	}
+
+	var buf bytes.Buffer
+	if err := RenderToWriter(&buf, data, opts, "layout"); err != nil {
+		t.Fatalf("RenderToWriter: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"type": "handle"`) || !strings.Contains(buf.String(), `"text": "@example"`) {
+		t.Fatalf("unexpected layout json:\n%s", buf.String())
+	}
+
+	opts.Canvas.Width, opts.Canvas.Height = 1200, 630
+	canvasDoc, err := RenderLayout(data, opts)
+	if err != nil {
+		t.Fatalf("RenderLayout: %v", err)
+	}
+	card := canvasDoc.Elements[0].Box
+	if canvasDoc.Width != 1200 || card.X <= 0 || card.X+card.Width > 1200 || card.Height > 630 {
+		t.Fatalf("unexpected card box on canvas: %+v", card)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	data := TweetData{
+		Text:       "Hello @jack and #go",
+		Name:       "Example User",
+		Handle:     "example",
+		Date:       "Dec 6, 2017",
+		CTA:        "Open",
+		Permalink:  "https://x.com/example/status/1",
+		ProfileURL: "https://x.com/example",
+		Entities: []Entity{
+			{Type: "mention", Start: 6, End: 11, URL: "https://x.com/jack"},
+			{Type: "hashtag", Start: 16, End: 19, URL: "javascript:alert(1)"},
+		},
+	}
+	opts := DefaultOptions()
+	// The embedded spec keeps the raw URLs; TestEmbedSpecUnsafeURLs covers it.
+	opts.EmbedSpec = false
+
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	for _, want := range []string{`<a href="https://x.com/example">`, `<a href="https://x.com/jack"><tspan`, `<a href="https://x.com/example/status/1">`} {
+		if !strings.Contains(svg, want) {
+			t.Fatalf("expected %q in svg", want)
+		}
+	}
+	if strings.Contains(svg, "javascript:") {
+		t.Fatalf("unsafe link in svg")
+	}
+	if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+		t.Fatalf("svg is not well-formed: %v", err)
+	}
+	opts.SVGText = "paths"
+	outlined, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if !strings.Contains(outlined, `<a href="https://x.com/jack"><path`) {
+		t.Fatalf("expected entity link around outlined text")
+	}
+	opts.SVGText = ""
+
+	html, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	for _, want := range []string{`<a class="entity" href="https://x.com/jack">@jack</a>`, `<span class="entity">#go</span>`, `<a class="link" href="https://x.com/example">`} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected %q in html", want)
+		}
+	}
+
+	markup, err := RenderImageMap(data, opts, "card.png")
+	if err != nil {
+		t.Fatalf("RenderImageMap: %v", err)
+	}
+	if !strings.Contains(markup, `<img src="card.png" width="960"`) || strings.Count(markup, "<area ") != 7 {
+		t.Fatalf("unexpected image map:\n%s", markup)
+	}
+	if !strings.Contains(markup, `href="https://x.com/jack" alt="@jack"`) {
+		t.Fatalf("expected an area for the mention:\n%s", markup)
+	}
+}
+
+func TestHTMLModes(t *testing.T) {
+	data := TweetData{Text: "Embedded @jack", Name: "Example User", Handle: "example", Date: "Dec 6", Entities: []Entity{{Type: "mention", Start: 9, End: 14}}}
+	opts := DefaultOptions()
+
+	opts.HTMLMode = "fragment"
+	fragment, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	if strings.Contains(fragment, "<!doctype") || strings.Contains(fragment, "body {") || strings.Contains(fragment, ":root") {
+		t.Fatalf("fragment should not contain page-level markup or styles")
+	}
+	for _, want := range []string{`<div class="xpost-root">`, `<article class="xpost-card"`, ".xpost-card {", `class="xpost-action xpost-icon"`} {
+		if !strings.Contains(fragment, want) {
+			t.Fatalf("expected %q in fragment", want)
+		}
+	}
+	if regexp.MustCompile(`class="(card|text|header|entity)"`).MatchString(fragment) {
+		t.Fatalf("found an unprefixed class in fragment")
+	}
+	if !strings.Contains(fragment, `<span class="xpost-entity">@jack</span>`) {
+		t.Fatalf("expected a prefixed entity in fragment")
+	}
+
+	opts.HTMLClassPrefix = "promo_"
+	prefixed, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	if !strings.Contains(prefixed, `<article class="promo_card"`) || !strings.Contains(prefixed, ".promo_root {") {
+		t.Fatalf("expected custom class prefix")
+	}
+	opts.HTMLClassPrefix = "x{}"
+	if _, err := RenderHTML(data, opts); err == nil {
+		t.Fatalf("expected error for invalid class prefix")
+	}
+	opts.HTMLClassPrefix = ""
+
+	fontPath := filepath.Join(t.TempDir(), "regular.ttf")
+	if err := os.WriteFile(fontPath, goregular.TTF, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	opts.HTMLMode = "component"
+	opts.FontPath = fontPath
+	component, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	for _, want := range []string{`<x-post-card>`, `<template shadowrootmode="open">`, ":host {", `customElements.define("x-post-card"`, `<article class="card"`} {
+		if !strings.Contains(component, want) {
+			t.Fatalf("expected %q in component", want)
+		}
+	}
+	if strings.Index(component, "@font-face") > strings.Index(component, "<x-post-card>") {
+		t.Fatalf("@font-face rules must be outside the shadow root")
+	}
+
+	opts.HTMLMode = "iframe"
+	if _, err := RenderHTML(data, opts); err == nil {
+		t.Fatalf("expected error for unknown html mode")
+	}
+}
+
+func TestRenderTSX(t *testing.T) {
+	data := TweetData{
+		Text:      "Hi @jack </div> {x}",
+		Name:      "Example User",
+		Handle:    "example",
+		Date:      "Dec 6",
+		LikeCount: "12",
+		Verified:  true,
+		Permalink: "https://x.com/example/status/1",
+		Entities:  []Entity{{Type: "mention", Start: 3, End: 8, URL: "https://x.com/jack"}},
+	}
+	tsx, err := RenderTSX(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("RenderTSX: %v", err)
+	}
+	for _, want := range []string{
+		`import * as React from "react";`,
+		"export type XPostCardProps = {",
+		"likeCount?: number | string;",
+		"export default XPostCard;",
+		`{"text":"@jack","entity":true,"href":"https://x.com/jack"}`,
+		`permalink: "https://x.com/example/status/1"`,
+		`likeCount: "12"`,
+	} {
+		if !strings.Contains(tsx, want) {
+			t.Fatalf("expected %q in tsx", want)
+		}
+	}
+	if strings.Contains(tsx, "class=") || strings.Contains(tsx, "</path>") {
+		t.Fatalf("icons should be JSX without class attributes")
+	}
+	if strings.Contains(tsx, "</div> {x}") {
+		t.Fatalf("post text must be escaped as a string literal")
+	}
+	if !strings.Contains(tsx, `<div style={{ color: theme.accent }}>{icons.verified}</div>`) {
+		t.Fatalf("expected verified icon")
+	}
+
+	jsx, err := svgToJSX(`<svg viewBox="0 0 24 24" class="x"><g stroke-width="2"><path d="M0 0"/></g></svg>`, 22)
+	if err != nil {
+		t.Fatalf("svgToJSX: %v", err)
+	}
+	want := `<svg viewBox="0 0 24 24" width={22} height={22} style={{ display: "block" }} fill="currentColor"><g strokeWidth="2"><path d="M0 0" /></g></svg>`
+	if jsx != want {
+		t.Fatalf("svgToJSX = %s", jsx)
+	}
+}
+
+func TestEmbedSpec(t *testing.T) {
+	data := TweetData{
+		Text:     "Spec </metadata> & <script>",
+		Name:     "Example User",
+		Handle:   "example",
+		Time:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Verified: true,
+		Entities: []Entity{{Type: "hashtag", Start: 0, End: 4}},
+	}
+	opts := DefaultOptions()
+	opts.Theme = DarkTheme()
+	opts.Scale = 0.5
+
+	for _, format := range []string{"png", "svg", "html"} {
+		var buf bytes.Buffer
+		if err := RenderToWriter(&buf, data, opts, format); err != nil {
+			t.Fatalf("%s: RenderToWriter: %v", format, err)
+		}
+		spec, err := ExtractSpec(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: ExtractSpec: %v", format, err)
+		}
+		if spec.Data.Text != data.Text || !spec.Data.Time.Equal(data.Time) || len(spec.Data.Entities) != 1 {
+			t.Fatalf("%s: unexpected data %+v", format, spec.Data)
+		}
+		if spec.Options.Theme != DarkTheme() || spec.Options.Scale != 0.5 || !spec.Options.EmbedSpec {
+			t.Fatalf("%s: unexpected options %+v", format, spec.Options)
+		}
+		switch format {
+		case "png":
+			if _, err := png.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatalf("png with spec does not decode: %v", err)
+			}
+		case "svg":
+			if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+				t.Fatalf("svg is not well-formed: %v", err)
+			}
+		}
+	}
+
+	opts.EmbedSpec = false
+	var buf bytes.Buffer
+	if err := RenderToWriter(&buf, data, opts, "png"); err != nil {
+		t.Fatalf("RenderToWriter: %v", err)
+	}
+	if _, err := ExtractSpec(buf.Bytes()); err == nil {
+		t.Fatalf("expected no spec without EmbedSpec")
+	}
+
+	// Local paths keep only their names; URLs stay as they are.
+	local := TweetData{Icon: "/home/me/avatars/icon.png"}
+	paths := DefaultOptions()
+	paths.FontPath, paths.BoldFontPath = "/home/me/fonts/Regular.ttf", "https://example.com/Bold.ttf"
+	paths.Canvas.Background = "/home/me/backgrounds/sky.jpg"
+	encoded, err := specJSON(local, paths)
+	if err != nil {
+		t.Fatalf("specJSON: %v", err)
+	}
+	if strings.Contains(encoded, "/home/me") {
+		t.Fatalf("embedded spec leaks local paths: %s", encoded)
+	}
+	spec, err := ExtractSpec([]byte(encoded))
+	if err != nil {
+		t.Fatalf("ExtractSpec: %v", err)
+	}
+	if spec.Data.Icon != "icon.png" || spec.Options.FontPath != "Regular.ttf" || spec.Options.BoldFontPath != paths.BoldFontPath || spec.Options.Canvas.Background != "sky.jpg" {
+		t.Fatalf("unexpected paths in spec: %+v %+v", spec.Data, spec.Options)
+	}
+}
+
+func TestEmbedSpecUnsafeURLs(t *testing.T) {
+	data := TweetData{
+		Text:       "Click </script><script>alert(1)</script>",
+		Name:       "Example User",
+		Handle:     "example",
+		CTA:        "Open",
+		Permalink:  "javascript:alert(1)",
+		ProfileURL: "javascript:alert(2)",
+		Entities:   []Entity{{Type: "url", Start: 0, End: 5, URL: "javascript:alert(3)"}},
+	}
+	for _, format := range []string{"svg", "html"} {
+		var buf bytes.Buffer
+		if err := RenderToWriter(&buf, data, DefaultOptions(), format); err != nil {
+			t.Fatalf("%s: RenderToWriter: %v", format, err)
+		}
+		out := buf.String()
+		spec, err := ExtractSpec(buf.Bytes())
+		if err != nil || spec.Data.Permalink != data.Permalink || spec.Data.Text != data.Text {
+			t.Fatalf("%s: expected the raw spec back: %v", format, err)
+		}
+		// The payload is JSON with <, > and & escaped, so it cannot close
+		// its element, and nothing outside it carries the unsafe URLs.
+		start := strings.Index(out, `id="xpostgen-spec">`) + len(`id="xpostgen-spec">`)
+		end := start + strings.Index(out[start:], "<")
+		payload := out[start:end]
+		if !strings.Contains(payload, `\u003c/script\u003e`) || strings.ContainsAny(payload, "<>") {
+			t.Fatalf("%s: spec payload is not escaped: %s", format, payload)
+		}
+		if strings.Contains(out[:start]+out[end:], "javascript:") {
+			t.Fatalf("%s: unsafe url outside the spec payload", format)
+		}
+		if format == "html" && !strings.Contains(out, `<script type="application/json" id="xpostgen-spec">`) {
+			t.Fatalf("html spec must be an inert json script")
+		}
+		if format == "svg" {
+			if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+				t.Fatalf("svg is not well-formed: %v", err)
+			}
+		}
+	}
+}
+
+func TestDescription(t *testing.T) {
+	data := TweetData{
+		Text:      "just setting up\nmy <twttr>",
+		Name:      "jack",
+		Handle:    "jack",
+		Time:      time.Date(2006, 3, 21, 20, 50, 0, 0, time.UTC),
+		LikeCount: "262K",
+		Verified:  true,
+	}
+	opts := DefaultOptions()
+	want := "Post by jack @jack (verified): just setting up my <twttr> · 8:50 PM · Mar 21, 2006 · 262K likes"
+	if got := Description(data, opts); got != want {
+		t.Fatalf("Description = %q", got)
+	}
+	simple := data
+	simple.Simple = true
+	if got := Description(simple, opts); got != "Post by jack @jack (verified): just setting up my <twttr>" {
+		t.Fatalf("simple Description = %q", got)
+	}
+	ja := TweetData{Text: "今日はCLIを作りました", Name: "Example", Handle: "example", LikeCount: "3"}
+	if got := Description(ja, opts); got != "Example @example の投稿: 今日はCLIを作りました · いいね3件" {
+		t.Fatalf("ja Description = %q", got)
+	}
+
+	svg, err := RenderSVG(data, opts)
+	if err != nil {
+		t.Fatalf("RenderSVG: %v", err)
+	}
+	if !strings.Contains(svg, "<title>Post by jack @jack (verified)</title>") || !strings.Contains(svg, "my &lt;twttr&gt; · 8:50 PM") {
+		t.Fatalf("expected title and desc in svg")
+	}
+	if strings.Contains(svg, `aria-label="X post preview"`) {
+		t.Fatalf("svg still has the fixed label")
+	}
+
+	html, err := RenderHTML(data, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	for _, want := range []string{`<html lang="en">`, `<article class="card" lang="en"`, `<time datetime="2006-03-21T20:50:00Z">8:50 PM · Mar 21, 2006</time>`, `<ul class="actions">`, `<li class="action icon">`} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected %q in html", want)
+		}
+	}
+	jaHTML, err := RenderHTML(ja, opts)
+	if err != nil {
+		t.Fatalf("RenderHTML: %v", err)
+	}
+	if !strings.Contains(jaHTML, `<html lang="ja">`) {
+		t.Fatalf("expected lang detected from the text")
+	}
+
+	// The PNG description is alt text and stays without the spec.
+	noSpec := opts
+	noSpec.EmbedSpec = false
+	var stream bytes.Buffer
+	if err := RenderToWriter(&stream, data, noSpec, "png"); err != nil {
+		t.Fatalf("RenderToWriter: %v", err)
+	}
+	chunks, err := pngChunks(stream.Bytes())
+	if err != nil {
+		t.Fatalf("pngChunks: %v", err)
+	}
+	found := false
+	for _, chunk := range chunks {
+		if chunk.kind == "iTXt" && bytes.HasPrefix(chunk.data, []byte("Description\x00")) {
+			found = bytes.HasSuffix(chunk.data, []byte(want))
+		}
+		if chunk.kind == "iTXt" && bytes.HasPrefix(chunk.data, []byte(specKeyword+"\x00")) {
+			t.Fatalf("unexpected spec chunk without EmbedSpec")
+		}
+	}
+	if !found {
+		t.Fatalf("expected the description in png without EmbedSpec")
+	}
+
+	pdf, err := RenderPDF(data, opts)
+	if err != nil {
+		t.Fatalf("RenderPDF: %v", err)
+	}
+	if !bytes.Contains(pdf, []byte("/Lang <FEFF0065006E>")) || !bytes.Contains(pdf, []byte("/Info ")) {
+		t.Fatalf("expected document language and info in pdf")
+	}
+}
+
+func TestRenderToWriters(t *testing.T) {
+	var avatar bytes.Buffer
+	icon := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range icon.Pix {
+		icon.Pix[i] = 0x80
+	}
+	if err := png.Encode(&avatar, icon); err != nil {
+		t.Fatalf("encode avatar: %v", err)
+	}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(avatar.Bytes())
+	}))
+	defer server.Close()
+
+	data := TweetData{Text: "One load, many formats", Name: "Example User", Handle: "example", Icon: server.URL + "/avatar.png"}
+	opts := DefaultOptions()
+	formats := []string{"png", "svg", "html", "pdf", "tsx", "layout"}
+	outputs := make([]bytes.Buffer, len(formats))
+	targets := make([]Target, len(formats))
+	for i, format := range formats {
+		targets[i] = Target{Writer: &outputs[i], Format: format}
+	}
+	if err := RenderToWriters(targets, data, opts); err != nil {
+		t.Fatalf("RenderToWriters: %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("expected the avatar to be fetched once, got %d", fetches)
+	}
+
+	for i, format := range formats {
+		var single bytes.Buffer
+		if err := RenderToWriter(&single, data, opts, format); err != nil {
+			t.Fatalf("%s: RenderToWriter: %v", format, err)
+		}
+		if !bytes.Equal(outputs[i].Bytes(), single.Bytes()) {
+			t.Fatalf("%s: output differs from a single render", format)
+		}
+	}
+
+	err := RenderToWriters([]Target{{Writer: io.Discard, Format: "bmp"}}, data, opts)
+	if err == nil || !strings.HasPrefix(err.Error(), "bmp: ") {
+		t.Fatalf("expected an error naming the format, got %v", err)
+	}
+}
+
+func TestEncodeGIFPalette(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Canvas = CanvasOptions{Width: 800, Height: 600, Background: "#1D9BF0,#794BC4", Angle: 135}
+	img, err := RenderImage(TweetData{Text: "Palette #golang", Name: "Example User", Handle: "example"}, opts)
+	if err != nil {
+		t.Fatalf("RenderImage: %v", err)
+	}
+	for _, dither := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := EncodeGIF(&buf, img, GIFOptions{Dither: dither}, themePalette(opts.Theme)...); err != nil {
+			t.Fatalf("EncodeGIF: %v", err)
+		}
+		decoded, err := gif.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("gif.Decode: %v", err)
+		}
+		paletted := decoded.(*image.Paletted)
+		for _, hex := range []string{opts.Theme.Background, opts.Theme.Text} {
+			want, _ := parseHexColor(hex)
+			found := false
+			for _, c := range paletted.Palette {
+				if color.NRGBAModel.Convert(c) == want {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("dither=%v: theme color %s missing from palette", dither, hex)
+			}
+		}
+		// The card's flat background keeps its exact color, dithered or not.
+		center := img.Bounds().Size().Div(2)
+		if got := color.NRGBAModel.Convert(paletted.At(center.X, center.Y-60)); got != (color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}) {
+			t.Fatalf("dither=%v: background drifted to %v", dither, got)
+		}
+	}
+}
+
+func TestEncodeJPEGOptions(t *testing.T) {
+	// One-pixel red and white stripes lose most of their color with 4:2:0.
+	img := image.NewRGBA(image.Rect(0, 0, 37, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			if x%2 == 0 {
+				c = color.RGBA{R: 224, G: 36, B: 94, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	errorOf := func(chroma string) int {
+		var buf bytes.Buffer
+		if err := EncodeJPEG(&buf, img, JPEGOptions{Quality: 95, Chroma: chroma}); err != nil {
+			t.Fatalf("%s: EncodeJPEG: %v", chroma, err)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: jpeg.Decode: %v", chroma, err)
+		}
+		if decoded.Bounds() != img.Bounds() {
+			t.Fatalf("%s: unexpected bounds %v", chroma, decoded.Bounds())
+		}
+		total := 0
+		for y := 0; y < 21; y++ {
+			for x := 0; x < 37; x++ {
+				r1, g1, b1, _ := img.At(x, y).RGBA()
+				r2, g2, b2, _ := decoded.At(x, y).RGBA()
+				for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+					total += d * d
+				}
+			}
+		}
+		return total
+	}
+	if full, half := errorOf("444"), errorOf("420"); full*4 > half {
+		t.Fatalf("expected 4:4:4 to keep the stripes: error %d vs %d with 4:2:0", full, half)
+	}
+	if err := EncodeJPEG(io.Discard, img, JPEGOptions{Chroma: "411"}); err == nil {
+		t.Fatalf("expected an error for unknown chroma subsampling")
+	}
+	if err := EncodeJPEG(io.Discard, img, JPEGOptions{Quality: 101}); err == nil {
+		t.Fatalf("expected an error for quality above 100")
+	}
+}
+
+func TestOptimizePNG(t *testing.T) {
+	data := TweetData{Text: "Optimize me", Name: "Example User", Handle: "example"}
+	opts := DefaultOptions()
+	opts.Transparent = true
+	var buf bytes.Buffer
+	if err := RenderToWriter(&buf, data, opts, "png"); err != nil {
+		t.Fatalf("RenderToWriter: %v", err)
+	}
+	optimized, err := OptimizePNG(buf.Bytes())
+	if err != nil {
+		t.Fatalf("OptimizePNG: %v", err)
+	}
+	if len(optimized) >= buf.Len() {
+		t.Fatalf("expected a smaller png: %d -> %d bytes", buf.Len(), len(optimized))
+	}
+	again, err := OptimizePNG(buf.Bytes())
+	if err != nil || !bytes.Equal(again, optimized) {
+		t.Fatalf("expected deterministic output")
+	}
+	before, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decode original: %v", err)
+	}
+	after, err := png.Decode(bytes.NewReader(optimized))
+	if err != nil {
+		t.Fatalf("decode optimized: %v", err)
+	}
+	b := before.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.NRGBAModel.Convert(before.At(x, y)) != color.NRGBAModel.Convert(after.At(x, y)) {
+				t.Fatalf("pixel %d,%d changed", x, y)
+			}
+		}
+	}
+	if spec, err := ExtractSpec(optimized); err != nil || spec.Data.Text != data.Text {
+		t.Fatalf("expected the spec to survive: %v", err)
+	}
+
+	// Flat images become paletted with transparency; opaque ones lose alpha.
+	flat := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 8; y < 56; y++ {
+		for x := 8; x < 56; x++ {
+			flat.Set(x, y, color.RGBA{R: 0x1D, G: 0x9B, B: 0xF0, A: 0xFF})
+		}
+	}
+	colorType := func(img image.Image) (byte, []byte) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("png.Encode: %v", err)
+		}
+		out, err := OptimizePNG(buf.Bytes())
+		if err != nil {
+			t.Fatalf("OptimizePNG: %v", err)
+		}
+		return out[25], out
+	}
+	if kind, out := colorType(flat); kind != 3 || !bytes.Contains(out, []byte("tRNS")) {
+		t.Fatalf("expected a paletted png with tRNS, got color type %d", kind)
+	}
+	opaque := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := 0; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i], opaque.Pix[i+1], opaque.Pix[i+2], opaque.Pix[i+3] = uint8(i/4), uint8(i/16), 0x80, 0xFF
+	}
+	if kind, _ := colorType(opaque); kind != 2 {
+		t.Fatalf("expected an RGB png, got color type %d", kind)
+	}
+}
+
+func TestCollage(t *testing.T) {
+	positions, size := masonry([]image.Point{{100, 50}, {100, 80}, {80, 30}, {100, 20}}, 2, 10)
+	want := []image.Point{{10, 10}, {120, 10}, {20, 70}, {120, 100}}
+	for i := range want {
+		if positions[i] != want[i] {
+			t.Fatalf("card %d at %v, want %v", i, positions[i], want[i])
+		}
+	}
+	if size != image.Pt(230, 130) {
+		t.Fatalf("unexpected collage size %v", size)
+	}
+
+	icon := filepath.Join(t.TempDir(), "avatar.png")
+	avatar := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	var avatarPNG bytes.Buffer
+	if err := png.Encode(&avatarPNG, avatar); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	if err := os.WriteFile(icon, avatarPNG.Bytes(), 0o644); err != nil {
+		t.Fatalf("write avatar: %v", err)
+	}
+	posts := []TweetData{
+		{Text: "First post", Icon: icon, Name: "Example User", Handle: "example"},
+		{Text: strings.Repeat("A longer post that wraps over several lines. ", 6), Icon: icon, Name: "Example User", Handle: "example"},
+		{Text: "Third post", Icon: icon, Name: "Example User", Handle: "example"},
+	}
+	opts := DefaultOptions()
+	opts.Width = 400
+	opts.Scale = 2
+	collage := CollageOptions{Columns: 2, Gap: 20}
+	img, err := RenderCollage(posts, opts, collage)
+	if err != nil {
+		t.Fatalf("RenderCollage: %v", err)
+	}
+	if got := img.Bounds().Dx(); got != 2*(2*400+40)+40 {
+		t.Fatalf("unexpected collage width %d", got)
+	}
+	if c := img.RGBAAt(5, 5); c != (color.RGBA{R: 0xE6, G: 0xEC, B: 0xF0, A: 0xFF}) {
+		t.Fatalf("expected the divider color as background, got %v", c)
+	}
+
+	svg, err := RenderCollageSVG(posts, opts, collage)
+	if err != nil {
+		t.Fatalf("RenderCollageSVG: %v", err)
+	}
+	if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+		t.Fatalf("invalid collage svg: %v", err)
+	}
+	if !strings.Contains(svg, `width="860"`) || !strings.Contains(svg, "<title>3 posts</title>") {
+		t.Fatalf("unexpected collage svg header")
+	}
+	for i := 1; i <= len(posts); i++ {
+		if strings.Count(svg, fmt.Sprintf(`id="post-%d-avatar-clip"`, i)) != 1 {
+			t.Fatalf("expected a unique clip id for post %d", i)
+		}
+	}
+
+	// A zero gap is kept; only a negative one picks the default.
+	tight, err := RenderCollage(posts[:2], opts, CollageOptions{Columns: 2, Gap: 0})
+	if err != nil {
+		t.Fatalf("RenderCollage: %v", err)
+	}
+	if got := tight.Bounds().Dx(); got != 2*2*400 {
+		t.Fatalf("expected no gap, got width %d", got)
+	}
+	if got := normalizeCollage(CollageOptions{Gap: -1}).Gap; got != DefaultCollageOptions().Gap {
+		t.Fatalf("expected the default gap, got %d", got)
+	}
+
+	if _, err := RenderCollage(nil, opts, collage); err == nil {
+		t.Fatalf("expected an error without posts")
+	}
+	if _, err := RenderCollageSVG(posts, opts, CollageOptions{Background: "blue"}); err == nil {
+		t.Fatalf("expected an error for an invalid background")
+	}
+}
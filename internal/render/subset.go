@@ -0,0 +1,268 @@
+package render
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errNotTrueType is returned by subsetTrueType for fonts without glyf
+// outlines (CFF-based .otf files and collections).
+var errNotTrueType = errors.New("font is not a TrueType font")
+
+// subsetTables are the tables kept in a subset. Everything else (names,
+// layout features, kerning) is dropped.
+var subsetTables = map[string]bool{
+	"OS/2": true,
+	"cmap": true,
+	"cvt ": true,
+	"fpgm": true,
+	"glyf": true,
+	"head": true,
+	"hhea": true,
+	"hmtx": true,
+	"loca": true,
+	"maxp": true,
+	"post": true,
+	"prep": true,
+}
+
+type sfntTable struct {
+	tag  string
+	data []byte
+}
+
+// subsetTrueType returns a copy of a TrueType font in which every glyph
+// outside keep is emptied. Glyph IDs are preserved, so text encoded with the
+// original IDs still renders; glyphs referenced by kept composite glyphs are
+// kept as well. Glyph 0 (.notdef) is always kept.
+func subsetTrueType(data []byte, keep map[uint16]bool) ([]byte, error) {
+	tables, err := readSFNTTables(data)
+	if err != nil {
+		return nil, err
+	}
+	head, maxp, loca, glyf := tables["head"], tables["maxp"], tables["loca"], tables["glyf"]
+	if glyf == nil || loca == nil {
+		return nil, errNotTrueType
+	}
+	if len(head) < 54 || len(maxp) < 6 {
+		return nil, fmt.Errorf("font has truncated head or maxp table")
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	longLoca := binary.BigEndian.Uint16(head[50:]) == 1
+
+	offsets := make([]int, numGlyphs+1)
+	for i := range offsets {
+		switch {
+		case longLoca && len(loca) >= 4*i+4:
+			offsets[i] = int(binary.BigEndian.Uint32(loca[4*i:]))
+		case !longLoca && len(loca) >= 2*i+2:
+			offsets[i] = 2 * int(binary.BigEndian.Uint16(loca[2*i:]))
+		default:
+			return nil, fmt.Errorf("font has truncated loca table")
+		}
+	}
+	glyph := func(gid int) []byte {
+		start, end := offsets[gid], offsets[gid+1]
+		if start >= end || end > len(glyf) {
+			return nil
+		}
+		return glyf[start:end]
+	}
+
+	kept := map[int]bool{0: true}
+	pending := []int{0}
+	for gid := range keep {
+		if int(gid) < numGlyphs && !kept[int(gid)] {
+			kept[int(gid)] = true
+			pending = append(pending, int(gid))
+		}
+	}
+	for len(pending) > 0 {
+		gid := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, component := range compositeComponents(glyph(gid)) {
+			if component < numGlyphs && !kept[component] {
+				kept[component] = true
+				pending = append(pending, component)
+			}
+		}
+	}
+
+	var newGlyf []byte
+	newLoca := make([]byte, 4*(numGlyphs+1))
+	for gid := 0; gid < numGlyphs; gid++ {
+		binary.BigEndian.PutUint32(newLoca[4*gid:], uint32(len(newGlyf)))
+		if kept[gid] {
+			newGlyf = append(newGlyf, glyph(gid)...)
+			for len(newGlyf)%4 != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*numGlyphs:], uint32(len(newGlyf)))
+
+	newHead := append([]byte(nil), head...)
+	binary.BigEndian.PutUint32(newHead[8:], 0)
+	binary.BigEndian.PutUint16(newHead[50:], 1)
+
+	// A version 3 post table carries the metrics without glyph names.
+	var newPost []byte
+	if post := tables["post"]; len(post) >= 32 {
+		newPost = append([]byte(nil), post[:32]...)
+		binary.BigEndian.PutUint32(newPost, 0x00030000)
+	}
+
+	var out []sfntTable
+	for tag, table := range tables {
+		if !subsetTables[tag] {
+			continue
+		}
+		switch tag {
+		case "glyf":
+			table = newGlyf
+		case "loca":
+			table = newLoca
+		case "head":
+			table = newHead
+		case "post":
+			if newPost == nil {
+				continue
+			}
+			table = newPost
+		}
+		out = append(out, sfntTable{tag: tag, data: table})
+	}
+	font := writeSFNT(out)
+
+	// head.checkSumAdjustment makes the checksum of the whole file 0xB1B0AFBA.
+	for _, table := range readTableDirectory(font) {
+		if table.tag == "head" {
+			binary.BigEndian.PutUint32(font[table.offset+8:], 0xB1B0AFBA-sfntChecksum(font))
+		}
+	}
+	return font, nil
+}
+
+// compositeComponents lists the glyphs referenced by a composite glyph.
+func compositeComponents(glyph []byte) []int {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return nil
+	}
+	const (
+		argsAreWords    = 0x0001
+		hasScale        = 0x0008
+		moreComponents  = 0x0020
+		hasXYScale      = 0x0040
+		hasTwoByTwo     = 0x0080
+		componentHeader = 4
+	)
+	var components []int
+	for pos := 10; pos+componentHeader <= len(glyph); {
+		flags := binary.BigEndian.Uint16(glyph[pos:])
+		components = append(components, int(binary.BigEndian.Uint16(glyph[pos+2:])))
+		pos += componentHeader
+		if flags&argsAreWords != 0 {
+			pos += 4
+		} else {
+			pos += 2
+		}
+		switch {
+		case flags&hasScale != 0:
+			pos += 2
+		case flags&hasXYScale != 0:
+			pos += 4
+		case flags&hasTwoByTwo != 0:
+			pos += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return components
+}
+
+type sfntDirEntry struct {
+	tag    string
+	offset int
+	length int
+}
+
+func readTableDirectory(data []byte) []sfntDirEntry {
+	if len(data) < 12 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(data[4:]))
+	var entries []sfntDirEntry
+	for i := 0; i < count; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			break
+		}
+		entries = append(entries, sfntDirEntry{
+			tag:    string(data[record : record+4]),
+			offset: int(binary.BigEndian.Uint32(data[record+8:])),
+			length: int(binary.BigEndian.Uint32(data[record+12:])),
+		})
+	}
+	return entries
+}
+
+func readSFNTTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("font is too short")
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+	default:
+		return nil, errNotTrueType
+	}
+	tables := map[string][]byte{}
+	for _, entry := range readTableDirectory(data) {
+		if entry.offset+entry.length > len(data) {
+			return nil, fmt.Errorf("font table %q is out of bounds", entry.tag)
+		}
+		tables[entry.tag] = data[entry.offset : entry.offset+entry.length]
+	}
+	return tables, nil
+}
+
+func writeSFNT(tables []sfntTable) []byte {
+	sort.Slice(tables, func(i, j int) bool { return tables[i].tag < tables[j].tag })
+	count := len(tables)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= count {
+		entrySelector++
+	}
+	searchRange := (1 << entrySelector) * 16
+
+	out := make([]byte, 12+16*count)
+	binary.BigEndian.PutUint32(out, 0x00010000)
+	binary.BigEndian.PutUint16(out[4:], uint16(count))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(count*16-searchRange))
+	for i, table := range tables {
+		record := out[12+16*i:]
+		copy(record, table.tag)
+		binary.BigEndian.PutUint32(record[4:], sfntChecksum(table.data))
+		binary.BigEndian.PutUint32(record[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table.data)))
+		out = append(out, table.data...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
+
+func sfntChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
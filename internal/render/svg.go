@@ -3,15 +3,19 @@ package render
 import (
 	"bytes"
 	"encoding/xml"
-	"math"
+	"fmt"
 	"strings"
 	"text/template"
+
+	"golang.org/x/image/font"
 )
 
 type svgLine struct {
-	X    float64
-	Y    float64
-	Text string
+	X     float64
+	Y     float64
+	Runs  []TextRun
+	Paths []string
+	Class string
 }
 
 type svgAction struct {
@@ -19,6 +23,8 @@ type svgAction struct {
 	Label  string
 	LabelX float64
 	LabelY float64
+	Path   string
+	Class  string
 }
 
 type svgView struct {
@@ -35,7 +41,12 @@ type svgView struct {
 	AvatarBg      string
 	AvatarText    string
 	FontFamily    string
+	CardInset     float64
+	CardWidth     float64
+	CardHeight    float64
 	CornerRadius  float64
+	ShowBorder    bool
+	Opaque        bool // fill the corners outside the card, like raster output
 	StrokeWidth   float64
 	AvatarX       float64
 	AvatarY       float64
@@ -65,11 +76,39 @@ type svgView struct {
 	CtaTextY      float64
 	AvatarDataURI string
 	Initials      string
+	FontFaceCSS   string
+	AnimationCSS  string
+	CTAClass      string
+	// Outlines replaces every <text> with the *Path elements below.
+	Outlines     bool
+	InitialsPath string
+	NamePath     string
+	HandlePath   string
+	DatePath     string
+	CtaPath      string
+	// Links wrap the matching elements in <a> when set.
+	ProfileURL   string
+	PermalinkURL string
+	CTAURL       string
+	// Title and Description give the image its accessible name.
+	Title       string
+	Description string
+	// Spec is the embedded spec JSON, if any.
+	Spec string
 }
 
 const svgTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img" aria-label="X post preview">
-  <rect x="1" y="1" width="{{addInt .Width -2}}" height="{{addInt .Height -2}}" rx="{{.CornerRadius}}" ry="{{.CornerRadius}}" fill="{{.Background}}" stroke="{{.Border}}" stroke-width="{{.StrokeWidth}}" />
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img">
+  <title>{{escape .Title}}</title>
+  <desc>{{escape .Description}}</desc>
+  {{if .Spec}}<metadata id="xpostgen-spec">{{.Spec}}</metadata>{{end}}
+  {{if .FontFaceCSS}}<style>
+{{.FontFaceCSS}}  </style>{{end}}
+  {{if .AnimationCSS}}<style>
+{{.AnimationCSS}}  </style>{{end}}
+  {{if .Opaque}}<rect width="{{.Width}}" height="{{.Height}}" fill="{{.Background}}" />{{end}}
+  <rect x="{{.CardInset}}" y="{{.CardInset}}" width="{{.CardWidth}}" height="{{.CardHeight}}" rx="{{.CornerRadius}}" ry="{{.CornerRadius}}" fill="{{.Background}}"{{if .ShowBorder}} stroke="{{.Border}}" stroke-width="{{.StrokeWidth}}"{{end}} />
+  {{if .ProfileURL}}<a href="{{escape .ProfileURL}}">{{end}}
   {{if .AvatarDataURI}}
   <defs>
     <clipPath id="avatar-clip">
@@ -79,35 +118,36 @@ const svgTemplate = `<?xml version="1.0" encoding="UTF-8"?>
   <image href="{{.AvatarDataURI}}" x="{{.AvatarX}}" y="{{.AvatarY}}" width="{{.AvatarSize}}" height="{{.AvatarSize}}" clip-path="url(#avatar-clip)" preserveAspectRatio="xMidYMid slice" />
   {{else}}
   <circle cx="{{add .AvatarX (div .AvatarSize 2)}}" cy="{{add .AvatarY (div .AvatarSize 2)}}" r="{{div .AvatarSize 2}}" fill="{{.AvatarBg}}" />
-  <text x="{{add .AvatarX (div .AvatarSize 2)}}" y="{{add .AvatarY (div .AvatarSize 2)}}" fill="{{.AvatarText}}" font-family="{{.FontFamily}}" font-size="28" font-weight="700" text-anchor="middle" dominant-baseline="central">{{escape .Initials}}</text>
+  {{if .Outlines}}{{.InitialsPath}}{{else}}<text x="{{add .AvatarX (div .AvatarSize 2)}}" y="{{add .AvatarY (div .AvatarSize 2)}}" fill="{{.AvatarText}}" font-family="{{.FontFamily}}" font-size="28" font-weight="700" text-anchor="middle" dominant-baseline="central">{{escape .Initials}}</text>{{end}}
   {{end}}
 
-  <text x="{{.NameX}}" y="{{.NameY}}" fill="{{.TextColor}}" font-family="{{.FontFamily}}" font-size="28" font-weight="700">{{escape .NameLine}}</text>
+  {{if .Outlines}}{{.NamePath}}{{else}}<text x="{{.NameX}}" y="{{.NameY}}" fill="{{.TextColor}}" font-family="{{.FontFamily}}" font-size="28" font-weight="700">{{escape .NameLine}}</text>{{end}}
   {{if .VerifiedIcon}}{{.VerifiedIcon}}{{end}}
-  <text x="{{.HandleX}}" y="{{.HandleY}}" fill="{{.MutedColor}}" font-family="{{.FontFamily}}" font-size="22">{{escape .HandleLine}}</text>
+  {{if .Outlines}}{{.HandlePath}}{{else}}<text x="{{.HandleX}}" y="{{.HandleY}}" fill="{{.MutedColor}}" font-family="{{.FontFamily}}" font-size="22">{{escape .HandleLine}}</text>{{end}}
+  {{if .ProfileURL}}</a>{{end}}
 
-  {{.TwitterIcon}}
+  {{if .PermalinkURL}}<a href="{{escape .PermalinkURL}}">{{.TwitterIcon}}</a>{{else}}{{.TwitterIcon}}{{end}}
 
   {{range .TextLines}}
-  <text x="{{.X}}" y="{{.Y}}" fill="{{$.TextColor}}" font-family="{{$.FontFamily}}" font-size="28">{{escape .Text}}</text>
+  {{if .Class}}<g class="{{.Class}}">{{end}}{{if $.Outlines}}{{range .Paths}}{{.}}{{end}}{{else}}<text x="{{.X}}" y="{{.Y}}" fill="{{$.TextColor}}" font-family="{{$.FontFamily}}" font-size="28">{{range .Runs}}{{if .URL}}<a href="{{escape .URL}}"><tspan fill="{{$.AccentColor}}">{{escape .Text}}</tspan></a>{{else if .Entity}}<tspan fill="{{$.AccentColor}}">{{escape .Text}}</tspan>{{else}}{{escape .Text}}{{end}}{{end}}</text>{{end}}{{if .Class}}</g>{{end}}
   {{end}}
 
   {{if .ShowFooter}}
   {{if .DateLine}}
-  <text x="{{.DateX}}" y="{{.DateY}}" fill="{{.MutedColor}}" font-family="{{.FontFamily}}" font-size="22">{{escape .DateLine}}</text>
+  {{if .PermalinkURL}}<a href="{{escape .PermalinkURL}}">{{end}}{{if .Outlines}}{{.DatePath}}{{else}}<text x="{{.DateX}}" y="{{.DateY}}" fill="{{.MutedColor}}" font-family="{{.FontFamily}}" font-size="22">{{escape .DateLine}}</text>{{end}}{{if .PermalinkURL}}</a>{{end}}
   {{.InfoIcon}}
   {{end}}
 
   <line x1="{{.DividerX1}}" y1="{{.DividerY}}" x2="{{.DividerX2}}" y2="{{.DividerY}}" stroke="{{.Divider}}" stroke-width="1" />
 
   {{range .Actions}}
-  {{.Icon}}
-  <text x="{{.LabelX}}" y="{{.LabelY}}" fill="{{$.MutedColor}}" font-family="{{$.FontFamily}}" font-size="20">{{escape .Label}}</text>
+  {{if .Class}}<g class="{{.Class}}">{{.Icon}}</g>{{else}}{{.Icon}}{{end}}
+  {{if $.Outlines}}{{.Path}}{{else}}<text x="{{.LabelX}}" y="{{.LabelY}}" fill="{{$.MutedColor}}" font-family="{{$.FontFamily}}" font-size="20">{{escape .Label}}</text>{{end}}
   {{end}}
 
   {{if .CTA}}
-  <rect x="{{.CtaX}}" y="{{.CtaY}}" width="{{.CtaWidth}}" height="{{.CtaHeight}}" rx="{{div .CtaHeight 2}}" ry="{{div .CtaHeight 2}}" fill="{{.Background}}" stroke="{{.Divider}}" stroke-width="1" />
-  <text x="{{.CtaTextX}}" y="{{.CtaTextY}}" fill="{{.AccentColor}}" font-family="{{.FontFamily}}" font-size="20" font-weight="600">{{escape .CTA}}</text>
+  {{if .CTAURL}}<a href="{{escape .CTAURL}}">{{end}}{{if .CTAClass}}<g class="{{.CTAClass}}">{{end}}<rect x="{{.CtaX}}" y="{{.CtaY}}" width="{{.CtaWidth}}" height="{{.CtaHeight}}" rx="{{div .CtaHeight 2}}" ry="{{div .CtaHeight 2}}" fill="{{.Background}}" stroke="{{.Divider}}" stroke-width="1" />
+  {{if .Outlines}}{{.CtaPath}}{{else}}<text x="{{.CtaTextX}}" y="{{.CtaTextY}}" fill="{{.AccentColor}}" font-family="{{.FontFamily}}" font-size="20" font-weight="600">{{escape .CTA}}</text>{{end}}{{if .CTAClass}}</g>{{end}}{{if .CTAURL}}</a>{{end}}
   {{end}}
   {{end}}
 </svg>
@@ -116,6 +156,9 @@ const svgTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 // RenderSVG returns the tweet preview as SVG markup.
 func RenderSVG(data TweetData, opts RenderOptions) (string, error) {
 	opts = normalizeOptions(opts)
+	if opts.Canvas.Enabled() {
+		return renderCanvasSVG(data, opts)
+	}
 	fonts, err := loadFontSet(opts)
 	if err != nil {
 		return "", err
@@ -123,17 +166,17 @@ func RenderSVG(data TweetData, opts RenderOptions) (string, error) {
 	defer fonts.Close()
 
 	layout := computeLayout(data, opts, fonts)
-	avatar, err := avatarDataURI(data.Icon)
+	avatar, err := opts.cache.avatarDataURI(data.Icon)
 	if err != nil {
 		return "", err
 	}
 
-	lines := make([]svgLine, len(layout.TextLines))
-	for i, line := range layout.TextLines {
+	lines := make([]svgLine, len(layout.TextRuns))
+	for i, runs := range layout.TextRuns {
 		lines[i] = svgLine{
 			X:    layout.TextX,
 			Y:    layout.TextY + float64(i)*layout.TextLineHeight,
-			Text: line,
+			Runs: runs,
 		}
 	}
 
@@ -171,7 +214,8 @@ func RenderSVG(data TweetData, opts RenderOptions) (string, error) {
 		}
 	}
 
-	corner := math.Min(20, float64(layout.Height)/12)
+	corner := cardCornerRadius(opts, layout.Height)
+	inset := cardInset(opts)
 	view := svgView{
 		Width:         layout.Width,
 		Height:        layout.Height,
@@ -184,7 +228,12 @@ func RenderSVG(data TweetData, opts RenderOptions) (string, error) {
 		AvatarBg:      opts.Theme.AvatarBg,
 		AvatarText:    opts.Theme.AvatarText,
 		FontFamily:    sanitizeFontFamily(opts.FontFamily),
+		CardInset:     inset,
+		CardWidth:     float64(layout.Width) - 2*inset,
+		CardHeight:    float64(layout.Height) - 2*inset,
 		CornerRadius:  corner,
+		ShowBorder:    !opts.NoBorder,
+		Opaque:        !opts.Transparent,
 		StrokeWidth:   1.5,
 		AvatarX:       layout.AvatarX,
 		AvatarY:       layout.AvatarY,
@@ -217,16 +266,43 @@ func RenderSVG(data TweetData, opts RenderOptions) (string, error) {
 		AvatarDataURI: avatar,
 		Initials:      initials(data.Name),
 	}
+	links := buildCardLinks(data)
+	view.ProfileURL, view.PermalinkURL, view.CTAURL = links.Profile, links.Permalink, links.CTA
+	view.Title = descriptionAuthor(data, dateLocale(postLang(data)) == "ja")
+	view.Description = Description(data, opts)
+	if opts.EmbedSpec {
+		view.Spec, err = specJSON(data, opts)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch opts.SVGText {
+	case "", "text":
+		if embedsFonts(opts) {
+			var family string
+			view.FontFaceCSS, family, err = fontFaceCSS(data, layout, fonts)
+			if err != nil {
+				return "", err
+			}
+			view.FontFamily = sanitizeFontFamily(withEmbeddedFont(family, opts.FontFamily))
+		}
+	case "paths":
+		if err := outlineSVGText(&view, data, layout, fonts, opts.Theme); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown svg text mode: %s", opts.SVGText)
+	}
+
+	if opts.Animation.Enabled() {
+		applySVGAnimation(&view, layout, opts.Animation)
+	}
 
 	funcs := template.FuncMap{
-		"escape": func(s string) string {
-			var buf bytes.Buffer
-			_ = xml.EscapeText(&buf, []byte(s))
-			return buf.String()
-		},
+		"escape": escapeXML,
 		"div":    func(a float64, b float64) float64 { return a / b },
 		"add":    func(a float64, b float64) float64 { return a + b },
-		"addInt": func(a int, b int) int { return a + b },
 	}
 
 	tmpl, err := template.New("svg").Funcs(funcs).Parse(svgTemplate)
@@ -240,6 +316,63 @@ func RenderSVG(data TweetData, opts RenderOptions) (string, error) {
 	return buf.String(), nil
 }
 
+// outlineSVGText fills the *Path fields of view with glyph outlines for
+// every text element of the layout.
+func outlineSVGText(view *svgView, data TweetData, layout Layout, fonts FontSet, theme Theme) error {
+	var o glyphOutliner
+	var err error
+	view.Outlines = true
+	if view.AvatarDataURI == "" {
+		label := initials(data.Name)
+		width := float64(font.MeasureString(fonts.Initials, label)) / 64
+		height := float64(fonts.Initials.Metrics().Height) / 64
+		view.InitialsPath, err = o.path(fonts.bold, initialsFontSize, fonts.Initials, label, layout.AvatarX+(layout.AvatarSize-width)/2, layout.AvatarY+(layout.AvatarSize+height)/2, theme.AvatarText)
+		if err != nil {
+			return err
+		}
+	}
+	if view.NamePath, err = o.path(fonts.bold, nameFontSize, fonts.Name, layout.NameLine, layout.NameX, layout.NameY, theme.Text); err != nil {
+		return err
+	}
+	if view.HandlePath, err = o.path(fonts.regular, handleFontSize, fonts.Handle, layout.HandleLine, layout.HandleX, layout.HandleY, theme.Muted); err != nil {
+		return err
+	}
+	for i := range view.TextLines {
+		line := &view.TextLines[i]
+		for _, run := range line.Runs {
+			fill := theme.Text
+			if run.Entity {
+				fill = theme.Accent
+			}
+			path, err := o.path(fonts.regular, textFontSize, fonts.Text, run.Text, run.X, line.Y, fill)
+			if err != nil {
+				return err
+			}
+			if run.URL != "" {
+				path = `<a href="` + escapeXML(run.URL) + `">` + path + `</a>`
+			}
+			line.Paths = append(line.Paths, path)
+		}
+	}
+	if view.DatePath, err = o.path(fonts.regular, metaFontSize, fonts.Meta, layout.DateLine, layout.DateX, layout.DateY, theme.Muted); err != nil {
+		return err
+	}
+	for i := range view.Actions {
+		action := &view.Actions[i]
+		if action.Path, err = o.path(fonts.regular, actionFontSize, fonts.Action, action.Label, action.LabelX, action.LabelY, theme.Muted); err != nil {
+			return err
+		}
+	}
+	view.CtaPath, err = o.path(fonts.bold, ctaFontSize, fonts.CTA, layout.CTA, layout.CtaTextX, layout.CtaTextY, theme.Accent)
+	return err
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
 func sanitizeFontFamily(value string) string {
 	if value == "" {
 		return "sans-serif"
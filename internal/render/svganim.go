@@ -0,0 +1,69 @@
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// animationPrefixToken stands for the per-card prefix of the animation
+// classes and keyframes until the CSS is complete.
+const animationPrefixToken = "{prefix}"
+
+// applySVGAnimation marks the animated elements of view and adds the CSS
+// keyframes that drive them. The timeline follows the raster animation:
+// lines reveal one after another while the text types in, then the like
+// icon pops and the CTA fades in. Elements only get their hidden state from
+// the keyframes, so without animations (reduced motion) the card is static.
+// Class and keyframe names are prefixed with "xpost-" and a hash of the CSS
+// so cards inlined in a page neither collide with its styles nor with each
+// other.
+func applySVGAnimation(view *svgView, layout Layout, anim AnimationOptions) {
+	iterations := "infinite"
+	if anim.Once {
+		iterations = "1"
+	}
+	const p = animationPrefixToken
+	var css strings.Builder
+	fmt.Fprintf(&css, ".%sanim { animation-duration: %.3fs; animation-timing-function: ease-out; animation-iteration-count: %s; animation-fill-mode: both; }\n", p, anim.Duration.Seconds(), iterations)
+
+	for i := range view.TextLines {
+		start := typingEnd * float64(i) / float64(len(view.TextLines))
+		end := typingEnd * float64(i+1) / float64(len(view.TextLines))
+		view.TextLines[i].Class = fmt.Sprintf("%sanim %sline-%d", p, p, i)
+		fmt.Fprintf(&css, ".%[1]sline-%[2]d { animation-name: %[1]sline-%[2]d; }\n", p, i)
+		fmt.Fprintf(&css, "@keyframes %sline-%d { 0%%, %s { opacity: 0; transform: translateY(8px); } %s, 100%% { opacity: 1; transform: none; } }\n", p, i, percent(start), percent(end))
+	}
+
+	for i, action := range layout.Actions {
+		if action.IconName == "like" && i < len(view.Actions) {
+			view.Actions[i].Class = p + "anim " + p + "like-pop"
+		}
+	}
+	popPeak := (typingEnd + likesEnd) / 2
+	fmt.Fprintf(&css, ".%[1]slike-pop { animation-name: %[1]slike-pop; transform-box: fill-box; transform-origin: center; }\n", p)
+	fmt.Fprintf(&css, "@keyframes %slike-pop { 0%%, %s { transform: none; } %s { transform: scale(1.35); } %s, 100%% { transform: none; } }\n", p, percent(typingEnd), percent(popPeak), percent(likesEnd))
+
+	view.CTAClass = p + "anim " + p + "cta-fade"
+	fmt.Fprintf(&css, ".%[1]scta-fade { animation-name: %[1]scta-fade; }\n", p)
+	fmt.Fprintf(&css, "@keyframes %scta-fade { 0%%, %s { opacity: 0; } %s, 100%% { opacity: 1; } }\n", p, percent(likesEnd), percent(ctaEnd))
+
+	fmt.Fprintf(&css, "@media (prefers-reduced-motion: reduce) { .%sanim { animation: none; } }\n", p)
+
+	sum := sha256.Sum256([]byte(css.String()))
+	prefix := "xpost-" + hex.EncodeToString(sum[:4]) + "-"
+	for i := range view.TextLines {
+		view.TextLines[i].Class = strings.ReplaceAll(view.TextLines[i].Class, p, prefix)
+	}
+	for i := range view.Actions {
+		view.Actions[i].Class = strings.ReplaceAll(view.Actions[i].Class, p, prefix)
+	}
+	view.CTAClass = strings.ReplaceAll(view.CTAClass, p, prefix)
+	view.AnimationCSS = strings.ReplaceAll(css.String(), p, prefix)
+}
+
+// percent formats a fraction as a keyframe selector.
+func percent(v float64) string {
+	return fmt.Sprintf("%.1f%%", v*100)
+}
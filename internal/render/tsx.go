@@ -0,0 +1,566 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type tsxView struct {
+	Width         int
+	Padding       int
+	AvatarSize    int
+	Gap           int
+	CornerRadius  float64
+	Border        string
+	FontFamily    string
+	Theme         Theme
+	Text          string
+	Segments      []textSegment
+	Name          string
+	Handle        string
+	Date          string
+	DateTime      string
+	Lang          string
+	LikeCount     string
+	CTA           string
+	Avatar        string
+	Verified      bool
+	ShowFooter    bool
+	ProfileURL    string
+	PermalinkURL  string
+	CTAURL        string
+	Icons         map[string]string
+	Canvas        *tsxCanvas
+	ComponentName string
+}
+
+type tsxCanvas struct {
+	Width      int
+	Height     int
+	Background string
+	Scale      float64
+	Shadow     string
+}
+
+// textSegment is a run of post text in one style. Break marks a line break
+// opportunity after the segment, where HTML output places <wbr>.
+type textSegment struct {
+	Text   string `json:"text"`
+	Entity bool   `json:"entity,omitempty"`
+	URL    string `json:"href,omitempty"`
+	Break  bool   `json:"wbr,omitempty"`
+}
+
+const tsxTemplate = `import * as React from "react";
+
+export type {{.ComponentName}}Props = {
+  text?: string;
+  name?: string;
+  handle?: string;
+  date?: string;
+  likeCount?: number | string;
+  cta?: string;
+  avatarUrl?: string;
+};
+
+type Segment = { text: string; entity?: boolean; href?: string; wbr?: boolean };
+
+const theme = {
+  bg: {{js .Theme.Background}},
+  border: {{js .Theme.Border}},
+  divider: {{js .Theme.Divider}},
+  text: {{js .Theme.Text}},
+  muted: {{js .Theme.Muted}},
+  accent: {{js .Theme.Accent}},
+  avatarBg: {{js .Theme.AvatarBg}},
+  avatarText: {{js .Theme.AvatarText}}
+};
+
+const defaults = {
+  text: {{js .Text}},
+  name: {{js .Name}},
+  handle: {{js .Handle}},
+  date: {{js .Date}},
+  dateTime: {{js .DateTime}},
+  likeCount: {{js .LikeCount}},
+  cta: {{js .CTA}},
+  avatarUrl: {{js .Avatar}},
+  lang: {{js .Lang}}
+};
+
+const segments: Segment[] = {{js .Segments}};
+
+const links = {
+  profile: {{js .ProfileURL}},
+  permalink: {{js .PermalinkURL}},
+  cta: {{js .CTAURL}}
+};
+
+const styles: Record<string, React.CSSProperties> = {
+{{- with .Canvas}}
+  canvas: {
+    width: {{.Width}},
+    height: {{.Height}},
+    display: "flex",
+    alignItems: "center",
+    justifyContent: "center",
+    overflow: "hidden",
+    background: {{js .Background}}
+  },
+{{- end}}
+  card: {
+    width: {{.Width}},
+    boxSizing: "border-box",
+    padding: {{.Padding}},
+    border: {{js .Border}},
+    borderRadius: {{.CornerRadius}},
+    background: theme.bg,
+    color: theme.text,
+    fontFamily: {{js .FontFamily}}{{with .Canvas}},
+    flex: "none",
+    transform: "scale({{.Scale}})"{{if .Shadow}},
+    boxShadow: {{js .Shadow}}{{end}}{{end}}
+  },
+  header: { display: "flex", alignItems: "flex-start", justifyContent: "space-between" },
+  headerLeft: { display: "flex", gap: {{.Gap}}, alignItems: "flex-start" },
+  avatar: {
+    width: {{.AvatarSize}},
+    height: {{.AvatarSize}},
+    borderRadius: 999,
+    background: theme.avatarBg,
+    color: theme.avatarText,
+    display: "flex",
+    alignItems: "center",
+    justifyContent: "center",
+    fontSize: 28,
+    fontWeight: 700,
+    overflow: "hidden",
+    flex: "none"
+  },
+  avatarImage: { width: "100%", height: "100%", objectFit: "cover", display: "block" },
+  nameRow: { display: "flex", alignItems: "center", gap: 6 },
+  name: { fontSize: 28, fontWeight: 700, lineHeight: 1.2 },
+  handle: { fontSize: 22, color: theme.muted, marginTop: 4 },
+  text: {
+    marginTop: 16,
+    fontSize: 28,
+    lineHeight: 1.45,
+    whiteSpace: "pre-wrap",
+    wordBreak: "keep-all",
+    overflowWrap: "break-word"
+  },
+  entity: { color: theme.accent, textDecoration: "none" },
+  link: { display: "block", color: "inherit", textDecoration: "none" },
+  dateRow: {
+    marginTop: 16,
+    display: "flex",
+    alignItems: "center",
+    justifyContent: "space-between",
+    color: theme.muted,
+    fontSize: 22
+  },
+  divider: { marginTop: 12, borderTop: ` + "`1px solid ${theme.divider}`" + ` },
+  actions: {
+    margin: "14px 0 0",
+    padding: 0,
+    listStyle: "none",
+    display: "flex",
+    gap: 32,
+    alignItems: "center",
+    color: theme.muted,
+    fontSize: 20
+  },
+  action: { display: "inline-flex", alignItems: "center", gap: 8 },
+  cta: {
+    marginTop: 16,
+    height: 44,
+    borderRadius: 999,
+    border: ` + "`1px solid ${theme.divider}`" + `,
+    display: "flex",
+    alignItems: "center",
+    justifyContent: "center",
+    color: theme.accent,
+    fontWeight: 600,
+    fontSize: 20
+  }
+};
+
+const icons = {
+  twitter: (
+    {{index .Icons "twitter"}}
+  ),
+  verified: (
+    {{index .Icons "verified"}}
+  ),
+  info: (
+    {{index .Icons "info"}}
+  ),
+  like: (
+    {{index .Icons "like"}}
+  ),
+  reply: (
+    {{index .Icons "reply"}}
+  ),
+  link: (
+    {{index .Icons "link"}}
+  )
+};
+
+function Link({ href, children }: { href: string; children: React.ReactNode }) {
+  return href ? (
+    <a href={href} style={styles.link}>
+      {children}
+    </a>
+  ) : (
+    <>{children}</>
+  );
+}
+
+function initials(name: string): string {
+  const parts = name.trim().split(/\s+/).filter(Boolean);
+  if (parts.length === 0) {
+    return "?";
+  }
+  if (parts.length === 1) {
+    return Array.from(parts[0]).slice(0, 2).join("");
+  }
+  return Array.from(parts[0])[0] + Array.from(parts[1])[0];
+}
+
+function PostText({ text }: { text?: string }) {
+  if (text !== undefined) {
+    return <>{text}</>;
+  }
+  return (
+    <>
+      {segments.map((segment, i) => (
+        <React.Fragment key={i}>
+          {segment.href ? (
+            <a href={segment.href} style={styles.entity}>
+              {segment.text}
+            </a>
+          ) : segment.entity ? (
+            <span style={styles.entity}>{segment.text}</span>
+          ) : (
+            segment.text
+          )}
+          {segment.wbr ? <wbr /> : null}
+        </React.Fragment>
+      ))}
+    </>
+  );
+}
+
+export function {{.ComponentName}}(props: {{.ComponentName}}Props) {
+  const name = props.name ?? defaults.name;
+  const handle = props.handle ?? defaults.handle;
+  const date = props.date ?? defaults.date;
+  const likeCount =
+    typeof props.likeCount === "number"
+      ? props.likeCount.toLocaleString("en-US")
+      : props.likeCount ?? defaults.likeCount;
+  const cta = props.cta ?? defaults.cta;
+  const avatarUrl = props.avatarUrl ?? defaults.avatarUrl;
+
+  const card = (
+    <article style={styles.card} lang={defaults.lang}>
+      <div style={styles.header}>
+        <Link href={links.profile}>
+          <div style={styles.headerLeft}>
+            <div style={styles.avatar}>
+              {avatarUrl ? <img src={avatarUrl} alt="" style={styles.avatarImage} /> : initials(name)}
+            </div>
+            <div>
+              <div style={styles.nameRow}>
+                <div style={styles.name}>{name}</div>
+{{- if .Verified}}
+                <div style={{"{{"}} color: theme.accent {{"}}"}}>{icons.verified}</div>
+{{- end}}
+              </div>
+              <div style={styles.handle}>{handle}</div>
+            </div>
+          </div>
+        </Link>
+        <Link href={links.permalink}>
+          <div style={{"{{"}} color: theme.accent {{"}}"}}>{icons.twitter}</div>
+        </Link>
+      </div>
+      <div style={styles.text}>
+        <PostText text={props.text} />
+      </div>
+{{- if .ShowFooter}}
+      {date ? (
+        <>
+          <div style={styles.dateRow}>
+            <Link href={links.permalink}>
+              <div>
+                {props.date === undefined && defaults.dateTime ? (
+                  <time dateTime={defaults.dateTime}>{date}</time>
+                ) : (
+                  date
+                )}
+              </div>
+            </Link>
+            <div>{icons.info}</div>
+          </div>
+          <div style={styles.divider} />
+        </>
+      ) : (
+        <div style={{"{{"}} ...styles.divider, marginTop: 16 {{"}}"}} />
+      )}
+      <ul style={styles.actions}>
+        <li style={styles.action}>
+          {icons.like}
+          <span>{likeCount}</span>
+        </li>
+        <li style={styles.action}>
+          {icons.reply}
+          <span>Reply</span>
+        </li>
+        <li style={styles.action}>
+          {icons.link}
+          <span>Copy link</span>
+        </li>
+      </ul>
+      {cta ? (
+        <Link href={links.cta}>
+          <div style={styles.cta}>{cta}</div>
+        </Link>
+      ) : null}
+{{- end}}
+    </article>
+  );
+{{- if .Canvas}}
+  return <div style={styles.canvas}>{card}</div>;
+{{- else}}
+  return card;
+{{- end}}
+}
+
+export default {{.ComponentName}};
+`
+
+// tsxIconSizes matches the icon sizes of the HTML output.
+var tsxIconSizes = map[string]int{"twitter": 30, "verified": 20, "info": 20, "like": 22, "reply": 22, "link": 22}
+
+// RenderTSX returns a standalone React component in TypeScript that draws
+// the card like RenderHTML, with inline styles. Its props override the
+// text, author, date and counts baked in from data.
+func RenderTSX(data TweetData, opts RenderOptions) (string, error) {
+	opts = normalizeOptions(opts)
+	fonts, err := loadFontSet(opts)
+	if err != nil {
+		return "", err
+	}
+	defer fonts.Close()
+
+	layout := computeLayout(data, opts, fonts)
+
+	avatar, err := opts.cache.avatarDataURI(data.Icon)
+	if err != nil {
+		return "", err
+	}
+	links := buildCardLinks(data)
+	view := tsxView{
+		Width:         layout.Width,
+		Padding:       opts.Padding,
+		AvatarSize:    opts.AvatarSize,
+		Gap:           opts.Gap,
+		CornerRadius:  20,
+		Border:        "1.5px solid " + opts.Theme.Border,
+		FontFamily:    opts.FontFamily,
+		Theme:         opts.Theme,
+		Text:          data.Text,
+		Segments:      textSegments(data.Text, data.Entities),
+		Name:          data.Name,
+		Handle:        buildHandleLine(data),
+		Date:          buildDateLine(data, opts),
+		Lang:          postLang(data),
+		LikeCount:     strings.TrimSpace(data.LikeCount),
+		CTA:           strings.TrimSpace(data.CTA),
+		Avatar:        avatar,
+		Verified:      data.Verified,
+		ShowFooter:    !data.Simple,
+		ProfileURL:    links.Profile,
+		PermalinkURL:  links.Permalink,
+		CTAURL:        links.CTA,
+		Icons:         map[string]string{},
+		ComponentName: "XPostCard",
+	}
+	if !data.Time.IsZero() {
+		view.DateTime = data.Time.Format(time.RFC3339)
+	}
+	if view.LikeCount == "" {
+		view.LikeCount = "0"
+	}
+	if opts.NoBorder {
+		view.Border = "none"
+	}
+	if opts.CornerRadius != 0 {
+		view.CornerRadius = math.Max(0, opts.CornerRadius)
+	}
+	if opts.Canvas.Enabled() {
+		background, err := canvasCSSBackground(opts.Canvas, opts.cache)
+		if err != nil {
+			return "", err
+		}
+		fit, _, _ := canvasPlacement(opts.Canvas, layout.Width, layout.Height)
+		view.Canvas = &tsxCanvas{
+			Width:      opts.Canvas.Width,
+			Height:     opts.Canvas.Height,
+			Background: background,
+			Scale:      math.Round(fit*10000) / 10000,
+		}
+		if !opts.Canvas.NoShadow {
+			view.Canvas.Shadow = fmt.Sprintf("0 %gpx %gpx rgba(0, 0, 0, %g)", math.Round(canvasShadowOffset/fit*100)/100, math.Round(2*canvasShadowBlur/fit*100)/100, canvasShadowAlpha)
+		}
+	}
+	for name, size := range tsxIconSizes {
+		svg, err := iconSVG(name)
+		if err != nil {
+			return "", err
+		}
+		view.Icons[name], err = svgToJSX(svg, size)
+		if err != nil {
+			return "", fmt.Errorf("icon %s: %w", name, err)
+		}
+	}
+
+	funcs := template.FuncMap{
+		"js": func(v any) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+	}
+	tmpl, err := template.New("tsx").Funcs(funcs).Parse(tsxTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// textSegments splits the post text into styled runs, like formatHTMLText.
+func textSegments(text string, entities []Entity) []textSegment {
+	entities = normalizeEntities(text, entities)
+	mask := entityMask(text, entities)
+	urls := entityURLs(text, entities)
+	segments := []textSegment{}
+	pos := 0
+	add := func(r rune) {
+		highlighted := pos < len(mask) && mask[pos]
+		link := ""
+		if highlighted {
+			link = urls[pos]
+		}
+		last := len(segments) - 1
+		if last >= 0 && !segments[last].Break && segments[last].Entity == highlighted && segments[last].URL == link {
+			segments[last].Text += string(r)
+		} else {
+			segments = append(segments, textSegment{Text: string(r), Entity: highlighted, URL: link})
+		}
+		pos++
+	}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			add('\n')
+		}
+		if line == "" {
+			continue
+		}
+		tokens := budouxTokens(line)
+		for j, token := range tokens {
+			for _, r := range token {
+				add(r)
+			}
+			if j < len(tokens)-1 {
+				segments[len(segments)-1].Break = true
+			}
+		}
+	}
+	return segments
+}
+
+// svgToJSX rewrites an icon as JSX sized to size pixels. Class names from
+// the source are dropped, hyphenated attributes are camel-cased except
+// aria-*, and shapes without a fill use currentColor.
+func svgToJSX(svg string, size int) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	var out strings.Builder
+	depth := 0
+	open := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if open {
+				out.WriteString(">")
+			}
+			out.WriteString("<" + t.Name.Local)
+			hasFill := false
+			for _, attr := range t.Attr {
+				name := attr.Name.Local
+				switch {
+				case name == "class", name == "style", name == "xmlns":
+					continue
+				case name == "fill":
+					hasFill = true
+				case !strings.HasPrefix(name, "aria-") && strings.Contains(name, "-"):
+					name = jsxAttrName(name)
+				}
+				if strings.ContainsAny(attr.Value, "\"{}<>&\\") {
+					value, err := json.Marshal(attr.Value)
+					if err != nil {
+						return "", err
+					}
+					fmt.Fprintf(&out, " %s={%s}", name, value)
+				} else {
+					fmt.Fprintf(&out, " %s=\"%s\"", name, attr.Value)
+				}
+			}
+			if depth == 0 {
+				fmt.Fprintf(&out, " width={%d} height={%d} style={{ display: \"block\" }}", size, size)
+				if !hasFill {
+					out.WriteString(` fill="currentColor"`)
+				}
+			}
+			open = true
+			depth++
+		case xml.EndElement:
+			depth--
+			if open {
+				out.WriteString(" />")
+			} else {
+				out.WriteString("</" + t.Name.Local + ">")
+			}
+			open = false
+		}
+	}
+	return out.String(), nil
+}
+
+func jsxAttrName(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -1,6 +1,9 @@
 package render
 
-import "image/color"
+import (
+	"image/color"
+	"time"
+)
 
 // TweetData holds the values to render.
 type TweetData struct {
@@ -9,11 +12,29 @@ type TweetData struct {
 	Name      string
 	Handle    string
 	Date      string
+	Time      time.Time
+	Lang      string
 	Location  string
 	CTA       string
 	Verified  bool
 	Simple    bool
 	LikeCount string
+	// Permalink, ProfileURL and CTAURL turn the date, the author and the CTA
+	// into links in SVG and HTML output and in image maps. The CTA links to
+	// the permalink when CTAURL is empty.
+	Permalink  string
+	ProfileURL string
+	CTAURL     string
+	Entities   []Entity
+}
+
+// Entity marks a highlighted span of the post text such as a mention, link,
+// or hashtag. Start and End are rune offsets into TweetData.Text.
+type Entity struct {
+	Type  string
+	Start int
+	End   int
+	URL   string
 }
 
 // RenderOptions controls output sizes, fonts, and theme.
@@ -26,7 +47,40 @@ type RenderOptions struct {
 	BoldFontPath string
 	FontFamily   string
 	WidthMode    string
+	TimeZone     string
+	DateStyle    string
 	Theme        Theme
+	WebP         WebPOptions
+	JPEG         JPEGOptions
+	GIF          GIFOptions
+	// Scale multiplies the pixel density of raster output. Layout stays in
+	// logical pixels, so SVG/HTML/PDF dimensions do not change.
+	Scale float64
+	// Transparent leaves everything outside the rounded card transparent.
+	Transparent bool
+	NoBorder    bool
+	// CornerRadius is the card corner radius in pixels. 0 picks the default
+	// and negative values give square corners.
+	CornerRadius float64
+	Canvas       CanvasOptions
+	Animation    AnimationOptions
+	// SVGText is "text" (default) for <text> elements or "paths" to convert
+	// glyphs into outlines so the SVG looks the same without the fonts.
+	SVGText string
+	// HTMLMode is "page" (default) for a full document, "fragment" for the
+	// card alone with prefixed class names, or "component" for a
+	// self-registering <x-post-card> element that renders into Shadow DOM.
+	HTMLMode string
+	// HTMLClassPrefix prefixes the class names of fragments ("xpost-" when
+	// empty). Cards with different options on one page need distinct ones.
+	HTMLClassPrefix string
+	// EmbedSpec writes the data and options as JSON into PNG, SVG and HTML
+	// output, where ExtractSpec reads them back.
+	EmbedSpec bool
+
+	// cache shares fonts, images and layouts between the renders of
+	// RenderToWriters.
+	cache *renderCache
 }
 
 // Theme defines color values for the card.
@@ -50,7 +104,13 @@ func DefaultOptions() RenderOptions {
 		Gap:        16,
 		FontFamily: "\"Helvetica Neue\", \"SF Pro Text\", \"SF Pro Display\", \"Segoe UI\", Roboto, \"Noto Sans JP\", Arial, sans-serif",
 		WidthMode:  "fixed",
+		DateStyle:  "absolute",
 		Theme:      LightTheme(),
+		WebP:       WebPOptions{Quality: 75},
+		JPEG:       JPEGOptions{Quality: 90},
+		Scale:      1,
+		Canvas:     CanvasOptions{Angle: 135},
+		EmbedSpec:  true,
 	}
 }
 
@@ -102,9 +162,15 @@ func normalizeOptions(opts RenderOptions) RenderOptions {
 	if opts.WidthMode == "" {
 		opts.WidthMode = def.WidthMode
 	}
+	if opts.DateStyle == "" {
+		opts.DateStyle = def.DateStyle
+	}
 	if opts.Theme.Background == "" {
 		opts.Theme = def.Theme
 	}
+	if opts.Scale <= 0 {
+		opts.Scale = def.Scale
+	}
 	return opts
 }
 
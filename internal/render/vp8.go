@@ -0,0 +1,787 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// This file implements a lossy VP8 key frame encoder (RFC 6386) for WebP.
+// Every macroblock is predicted as a whole (16x16 luma and 8x8 chroma
+// with the DC, V, H and TM modes), its residual is transformed and
+// quantized with a single quantizer, and the tokens are coded with
+// probabilities adapted to the image. The loop filter is left off.
+
+const (
+	vp8Planes   = 4
+	vp8Bands    = 8
+	vp8Contexts = 3
+	vp8Probs    = 11
+
+	vp8PlaneYAfterY2 = 0
+	vp8PlaneY2       = 1
+	vp8PlaneUV       = 2
+
+	vp8MaxLevel = 2047
+	// vp8MaxFirstPartition is the largest first partition the 19-bit size
+	// in the frame tag can describe.
+	vp8MaxFirstPartition = 1<<19 - 1
+)
+
+// Intra prediction modes of 16x16 luma and 8x8 chroma blocks.
+const (
+	vp8PredDC = iota
+	vp8PredV
+	vp8PredH
+	vp8PredTM
+	vp8PredModes
+)
+
+type vp8TokenProbs [vp8Planes][vp8Bands][vp8Contexts][vp8Probs]uint8
+
+var (
+	vp8Zigzag     = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8CoeffBands = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	// vp8CatProbs are the probabilities of the extra bits of the
+	// DCT_CAT3 to DCT_CAT6 tokens.
+	vp8CatProbs = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+	vp8DCSteps = [128]int32{
+		4, 5, 6, 7, 8, 9, 10, 10, 11, 12, 13, 14, 15, 16, 17, 17,
+		18, 19, 20, 20, 21, 21, 22, 22, 23, 23, 24, 25, 25, 26, 27, 28,
+		29, 30, 31, 32, 33, 34, 35, 36, 37, 37, 38, 39, 40, 41, 42, 43,
+		44, 45, 46, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55, 56, 57, 58,
+		59, 60, 61, 62, 63, 64, 65, 66, 67, 68, 69, 70, 71, 72, 73, 74,
+		75, 76, 76, 77, 78, 79, 80, 81, 82, 83, 84, 85, 86, 87, 88, 89,
+		91, 93, 95, 96, 98, 100, 101, 102, 104, 106, 108, 110, 112, 114, 116, 118,
+		122, 124, 126, 128, 130, 132, 134, 136, 138, 140, 143, 145, 148, 151, 154, 157,
+	}
+	vp8ACSteps = [128]int32{
+		4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19,
+		20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35,
+		36, 37, 38, 39, 40, 41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
+		52, 53, 54, 55, 56, 57, 58, 60, 62, 64, 66, 68, 70, 72, 74, 76,
+		78, 80, 82, 84, 86, 88, 90, 92, 94, 96, 98, 100, 102, 104, 106, 108,
+		110, 112, 114, 116, 119, 122, 125, 128, 131, 134, 137, 140, 143, 146, 149, 152,
+		155, 158, 161, 164, 167, 170, 173, 177, 181, 185, 189, 193, 197, 201, 205, 209,
+		213, 217, 221, 225, 229, 234, 239, 245, 249, 254, 259, 264, 269, 274, 279, 284,
+	}
+)
+
+// vp8Quant holds the quantizer steps of a block type and the rounding
+// biases, in 1/256 of a step, applied before dividing.
+type vp8Quant struct {
+	dc, ac         int32
+	dcBias, acBias int32
+}
+
+func (q vp8Quant) step(i int) int32 {
+	if i == 0 {
+		return q.dc
+	}
+	return q.ac
+}
+
+// quantize returns the level of coefficient c at raster position i.
+func (q vp8Quant) quantize(c int32, i int) int32 {
+	step, bias := q.ac, q.acBias
+	if i == 0 {
+		step, bias = q.dc, q.dcBias
+	}
+	a := c
+	if a < 0 {
+		a = -a
+	}
+	level := (a*256 + step*bias) / (step * 256)
+	if level > vp8MaxLevel {
+		level = vp8MaxLevel
+	}
+	if c < 0 {
+		return -level
+	}
+	return level
+}
+
+// vp8QuantIndex maps quality 1-100 to a quantizer index (0 is the finest),
+// following the curve cwebp uses so that qualities compare.
+func vp8QuantIndex(quality int) int {
+	if quality <= 0 || quality > 100 {
+		quality = 75
+	}
+	c := float64(quality) / 100
+	if c < 0.75 {
+		c = c * 2 / 3
+	} else {
+		c = 2*c - 1
+	}
+	index := int(math.Round(127 * (1 - math.Cbrt(c))))
+	return min(max(index, 0), 127)
+}
+
+type vp8Macroblock struct {
+	yMode, uvMode int
+	// skip is set when every level is zero.
+	skip bool
+	// levels holds the quantized coefficients in zigzag order: 16 luma
+	// blocks, 4 U blocks, 4 V blocks and the luma DC (Y2) block.
+	levels [25][16]int16
+}
+
+type vp8Encoder struct {
+	mbw, mbh         int
+	yStride, cStride int
+	// Source and reconstructed planes, padded to whole macroblocks.
+	y, u, v    []uint8
+	ry, ru, rv []uint8
+	y1, y2, uv vp8Quant
+	mbs        []vp8Macroblock
+	probs      vp8TokenProbs
+	counts     [vp8Planes][vp8Bands][vp8Contexts][vp8Probs][2]uint32
+}
+
+// encodeVP8 returns the VP8 key frame of img.
+func encodeVP8(img *image.NRGBA, quality int) ([]byte, error) {
+	index := vp8QuantIndex(quality)
+	e := newVP8Encoder(img, index)
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.mbs = append(e.mbs, e.encodeMacroblock(mbx, mby))
+		}
+	}
+	skipped := 0
+	for i := range e.mbs {
+		if e.mbs[i].skip {
+			skipped++
+		}
+	}
+	useSkip := skipped > 0
+	e.putTokens(nil, useSkip)
+
+	header := newVP8BoolWriter()
+	header.putLiteral(0, 1) // color space
+	header.putLiteral(0, 1) // clamping type
+	header.putLiteral(0, 1) // no segmentation
+	header.putLiteral(0, 1) // filter type
+	header.putLiteral(0, 6) // loop filter off
+	header.putLiteral(0, 3) // sharpness
+	header.putLiteral(0, 1) // no loop filter deltas
+	header.putLiteral(0, 2) // one token partition
+	header.putLiteral(uint32(index), 7)
+	for i := 0; i < 5; i++ {
+		header.putLiteral(0, 1) // no quantizer deltas
+	}
+	header.putLiteral(0, 1) // refresh entropy probs
+	e.putProbUpdates(header)
+	skipProb := uint8(0)
+	if useSkip {
+		skipProb = uint8(min(max(255*(len(e.mbs)-skipped)/len(e.mbs), 1), 254))
+		header.putLiteral(1, 1)
+		header.putLiteral(uint32(skipProb), 8)
+	} else {
+		header.putLiteral(0, 1)
+	}
+	for i := range e.mbs {
+		mb := &e.mbs[i]
+		if useSkip {
+			header.put(skipProb, mb.skip)
+		}
+		header.put(145, true) // 16x16 luma prediction
+		switch mb.yMode {
+		case vp8PredDC:
+			header.put(156, false)
+			header.put(163, false)
+		case vp8PredV:
+			header.put(156, false)
+			header.put(163, true)
+		case vp8PredH:
+			header.put(156, true)
+			header.put(128, false)
+		case vp8PredTM:
+			header.put(156, true)
+			header.put(128, true)
+		}
+		header.put(142, mb.uvMode != vp8PredDC)
+		if mb.uvMode != vp8PredDC {
+			header.put(114, mb.uvMode != vp8PredV)
+			if mb.uvMode != vp8PredV {
+				header.put(183, mb.uvMode == vp8PredTM)
+			}
+		}
+	}
+	first := header.flush()
+	if len(first) > vp8MaxFirstPartition {
+		return nil, fmt.Errorf("webp: lossy header too large")
+	}
+	tokens := newVP8BoolWriter()
+	e.putTokens(tokens, useSkip)
+	rest := tokens.flush()
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	// Frame tag: key frame, version 0, shown, first partition size.
+	tag := uint32(1<<4 | len(first)<<5)
+	out := make([]byte, 0, 10+len(first)+len(rest))
+	out = append(out, byte(tag), byte(tag>>8), byte(tag>>16))
+	out = append(out, 0x9d, 0x01, 0x2a)
+	out = append(out, byte(width), byte(width>>8), byte(height), byte(height>>8))
+	out = append(out, first...)
+	return append(out, rest...), nil
+}
+
+// newVP8Encoder converts img to BT.601 Y'CbCr with 4:2:0 chroma, padding
+// the planes by repeating the last row and column.
+func newVP8Encoder(img *image.NRGBA, index int) *vp8Encoder {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	e := &vp8Encoder{mbw: (width + 15) / 16, mbh: (height + 15) / 16}
+	e.yStride, e.cStride = e.mbw*16, e.mbw*8
+	e.y = make([]uint8, e.yStride*e.mbh*16)
+	e.u = make([]uint8, e.cStride*e.mbh*8)
+	e.v = make([]uint8, e.cStride*e.mbh*8)
+	e.ry = make([]uint8, len(e.y))
+	e.ru = make([]uint8, len(e.u))
+	e.rv = make([]uint8, len(e.v))
+	pixel := func(x, y int) (r, g, b int32) {
+		p := img.Pix[min(y, height-1)*img.Stride+min(x, width-1)*4:]
+		return int32(p[0]), int32(p[1]), int32(p[2])
+	}
+	for y := 0; y < e.mbh*16; y++ {
+		for x := 0; x < e.yStride; x++ {
+			r, g, b := pixel(x, y)
+			e.y[y*e.yStride+x] = uint8((16839*r + 33059*g + 6420*b + 16<<16 + 1<<15) >> 16)
+		}
+	}
+	for y := 0; y < e.mbh*8; y++ {
+		for x := 0; x < e.cStride; x++ {
+			var r, g, b int32
+			for _, d := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := pixel(2*x+d[0], 2*y+d[1])
+				r, g, b = r+pr, g+pg, b+pb
+			}
+			e.u[y*e.cStride+x] = clampByte((-9719*r - 19081*g + 28800*b + 128<<18 + 1<<17) >> 18)
+			e.v[y*e.cStride+x] = clampByte((28800*r - 24116*g - 4684*b + 128<<18 + 1<<17) >> 18)
+		}
+	}
+
+	e.y1 = vp8Quant{dc: vp8DCSteps[index], ac: vp8ACSteps[index], dcBias: 96, acBias: 110}
+	e.y2 = vp8Quant{dc: vp8DCSteps[index] * 2, ac: max(vp8ACSteps[index]*155/100, 8), dcBias: 96, acBias: 108}
+	e.uv = vp8Quant{dc: vp8DCSteps[min(index, 117)], ac: vp8ACSteps[index], dcBias: 110, acBias: 115}
+	e.probs = vp8DefaultCoeffProbs
+	return e
+}
+
+func clampByte(v int32) uint8 {
+	return uint8(min(max(v, 0), 255))
+}
+
+// encodeMacroblock picks the prediction modes of a macroblock, quantizes
+// its residual and stores the reconstruction the decoder will see, which
+// later macroblocks are predicted from.
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) vp8Macroblock {
+	var mb vp8Macroblock
+	var pred [vp8PredModes][]uint8
+	best := -1
+	for mode := range pred {
+		pred[mode] = vp8Predict(mode, e.ry, e.yStride, mbx*16, mby*16, 16)
+		if best < 0 || vp8SSE(e.y, e.yStride, mbx*16, mby*16, 16, pred[mode]) < vp8SSE(e.y, e.yStride, mbx*16, mby*16, 16, pred[best]) {
+			best = mode
+		}
+	}
+	mb.yMode = best
+	e.encodeArea(e.y, e.ry, e.yStride, mbx*16, mby*16, 16, pred[best], e.y1, mb.levels[:16], &mb.levels[24])
+
+	var predU, predV [vp8PredModes][]uint8
+	best = -1
+	bestSSE := 0
+	for mode := range predU {
+		predU[mode] = vp8Predict(mode, e.ru, e.cStride, mbx*8, mby*8, 8)
+		predV[mode] = vp8Predict(mode, e.rv, e.cStride, mbx*8, mby*8, 8)
+		sse := vp8SSE(e.u, e.cStride, mbx*8, mby*8, 8, predU[mode]) + vp8SSE(e.v, e.cStride, mbx*8, mby*8, 8, predV[mode])
+		if best < 0 || sse < bestSSE {
+			best, bestSSE = mode, sse
+		}
+	}
+	mb.uvMode = best
+	e.encodeArea(e.u, e.ru, e.cStride, mbx*8, mby*8, 8, predU[best], e.uv, mb.levels[16:20], nil)
+	e.encodeArea(e.v, e.rv, e.cStride, mbx*8, mby*8, 8, predV[best], e.uv, mb.levels[20:24], nil)
+
+	mb.skip = true
+	for i := range mb.levels {
+		if mb.levels[i] != [16]int16{} {
+			mb.skip = false
+			break
+		}
+	}
+	return mb
+}
+
+// vp8Predict returns the prediction of the size x size block at (x0, y0)
+// from the reconstructed plane, using the edge values the decoder assumes
+// outside the frame: 127 above, 129 on the left.
+func vp8Predict(mode int, plane []uint8, stride, x0, y0, size int) []uint8 {
+	top := make([]int32, size)
+	left := make([]int32, size)
+	topLeft := int32(127)
+	for i := 0; i < size; i++ {
+		top[i], left[i] = 127, 129
+		if y0 > 0 {
+			top[i] = int32(plane[(y0-1)*stride+x0+i])
+		}
+		if x0 > 0 {
+			left[i] = int32(plane[(y0+i)*stride+x0-1])
+		}
+	}
+	if y0 > 0 {
+		topLeft = 129
+		if x0 > 0 {
+			topLeft = int32(plane[(y0-1)*stride+x0-1])
+		}
+	}
+
+	out := make([]uint8, size*size)
+	switch mode {
+	case vp8PredDC:
+		var sum, n int32
+		if y0 > 0 {
+			for _, v := range top {
+				sum += v
+			}
+			n += int32(size)
+		}
+		if x0 > 0 {
+			for _, v := range left {
+				sum += v
+			}
+			n += int32(size)
+		}
+		dc := uint8(128)
+		if n > 0 {
+			dc = uint8((sum + n/2) / n)
+		}
+		for i := range out {
+			out[i] = dc
+		}
+	case vp8PredV:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = uint8(top[i])
+			}
+		}
+	case vp8PredH:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = uint8(left[j])
+			}
+		}
+	case vp8PredTM:
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				out[j*size+i] = clampByte(left[j] + top[i] - topLeft)
+			}
+		}
+	}
+	return out
+}
+
+func vp8SSE(plane []uint8, stride, x0, y0, size int, pred []uint8) int {
+	sse := 0
+	for j := 0; j < size; j++ {
+		for i := 0; i < size; i++ {
+			d := int(plane[(y0+j)*stride+x0+i]) - int(pred[j*size+i])
+			sse += d * d
+		}
+	}
+	return sse
+}
+
+// encodeArea transforms and quantizes the residual of the 4x4 blocks of a
+// predicted area into levels and writes their reconstruction into recon.
+// With y2 set, the DC coefficients go through the Walsh-Hadamard (Y2)
+// block instead of the blocks themselves.
+func (e *vp8Encoder) encodeArea(src, recon []uint8, stride, x0, y0, size int, pred []uint8, q vp8Quant, levels [][16]int16, y2 *[16]int16) {
+	n := size / 4
+	coeffs := make([][16]int32, n*n)
+	for b := range coeffs {
+		bx, by := b%n*4, b/n*4
+		var residual [16]int32
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				residual[j*4+i] = int32(src[(y0+by+j)*stride+x0+bx+i]) - int32(pred[(by+j)*size+bx+i])
+			}
+		}
+		coeffs[b] = vp8ForwardDCT(residual)
+	}
+
+	first := 0
+	var dcs [16]int32
+	if y2 != nil {
+		first = 1
+		var in [16]int32
+		for b := range in {
+			in[b] = coeffs[b][0]
+		}
+		wht := vp8ForwardWHT(in)
+		var dequant [16]int32
+		for k, z := range vp8Zigzag {
+			level := e.y2.quantize(wht[z], z)
+			y2[k] = int16(level)
+			dequant[z] = level * e.y2.step(z)
+		}
+		dcs = vp8InverseWHT(dequant)
+	}
+	for b := range coeffs {
+		var dequant [16]int32
+		dequant[0] = dcs[b]
+		for k := first; k < 16; k++ {
+			z := vp8Zigzag[k]
+			level := q.quantize(coeffs[b][z], z)
+			levels[b][k] = int16(level)
+			dequant[z] = level * q.step(z)
+		}
+		bx, by := b%n*4, b/n*4
+		for j := 0; j < 4; j++ {
+			copy(recon[(y0+by+j)*stride+x0+bx:][:4], pred[(by+j)*size+bx:][:4])
+		}
+		vp8InverseDCT(dequant, recon[(y0+by)*stride+x0+bx:], stride)
+	}
+}
+
+// vp8ForwardDCT is the integer transform of libwebp, the counterpart of
+// vp8InverseDCT.
+func vp8ForwardDCT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := 0; i < 4; i++ {
+		d := in[i*4 : i*4+4]
+		a0, a1 := d[0]+d[3], d[1]+d[2]
+		a2, a3 := d[1]-d[2], d[0]-d[3]
+		tmp[i*4+0] = (a0 + a1) * 8
+		tmp[i*4+1] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[i*4+2] = (a0 - a1) * 8
+		tmp[i*4+3] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[i]+tmp[12+i], tmp[4+i]+tmp[8+i]
+		a2, a3 := tmp[4+i]-tmp[8+i], tmp[i]-tmp[12+i]
+		out[i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+	return out
+}
+
+// vp8InverseDCT adds the inverse transform of c to the 4x4 block at the
+// start of dst, exactly as decoders do.
+func vp8InverseDCT(c [16]int32, dst []uint8, stride int) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := c[i] + c[8+i]
+		b := c[i] - c[8+i]
+		cc := (c[4+i]*c2)>>16 - (c[12+i]*c1)>>16
+		d := (c[4+i]*c1)>>16 + (c[12+i]*c2)>>16
+		m[i] = [4]int32{a + d, b + cc, b - cc, a - d}
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		cc := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := dst[j*stride : j*stride+4]
+		for i, v := range [4]int32{a + d, b + cc, b - cc, a - d} {
+			row[i] = clampByte(int32(row[i]) + v>>3)
+		}
+	}
+}
+
+// vp8ForwardWHT transforms the DC coefficients of the 16 luma blocks.
+func vp8ForwardWHT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := 0; i < 4; i++ {
+		d := in[i*4 : i*4+4]
+		a0, a1 := d[0]+d[2], d[1]+d[3]
+		a2, a3 := d[1]-d[3], d[0]-d[2]
+		tmp[i*4+0] = a0 + a1
+		tmp[i*4+1] = a3 + a2
+		tmp[i*4+2] = a3 - a2
+		tmp[i*4+3] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[i]+tmp[8+i], tmp[4+i]+tmp[12+i]
+		a2, a3 := tmp[4+i]-tmp[12+i], tmp[i]-tmp[8+i]
+		out[i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+	return out
+}
+
+// vp8InverseWHT returns the DC coefficients of the 16 luma blocks, exactly
+// as decoders compute them.
+func vp8InverseWHT(in [16]int32) [16]int32 {
+	var m, out [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[i] - in[12+i]
+		m[i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[i*4] + 3
+		a0 := dc + m[i*4+3]
+		a1 := m[i*4+1] + m[i*4+2]
+		a2 := m[i*4+1] - m[i*4+2]
+		a3 := dc - m[i*4+3]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+	return out
+}
+
+// putTokens writes the coefficient tokens of all macroblocks, or only
+// counts the token tree branches into e.counts when bw is nil.
+func (e *vp8Encoder) putTokens(bw *vp8BoolWriter, useSkip bool) {
+	// Whether the neighboring blocks have non-zero levels: 4 luma, 2 U,
+	// 2 V and Y2 per macroblock column above and for the left macroblock.
+	top := make([][9]uint8, e.mbw)
+	for mby := 0; mby < e.mbh; mby++ {
+		var left [9]uint8
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			mb := &e.mbs[mby*e.mbw+mbx]
+			above := &top[mbx]
+			if useSkip && mb.skip {
+				*above, left = [9]uint8{}, [9]uint8{}
+				continue
+			}
+			nz := e.putBlock(bw, vp8PlaneY2, left[8]+above[8], 0, &mb.levels[24])
+			left[8], above[8] = nz, nz
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					nz := e.putBlock(bw, vp8PlaneYAfterY2, left[y]+above[x], 1, &mb.levels[y*4+x])
+					left[y], above[x] = nz, nz
+				}
+			}
+			for c := 0; c < 2; c++ {
+				for y := 0; y < 2; y++ {
+					for x := 0; x < 2; x++ {
+						l, a := 4+c*2+y, 4+c*2+x
+						nz := e.putBlock(bw, vp8PlaneUV, left[l]+above[a], 0, &mb.levels[16+c*4+y*2+x])
+						left[l], above[a] = nz, nz
+					}
+				}
+			}
+		}
+	}
+}
+
+// putBlock writes the tokens of a block's levels from position first on
+// and returns 1 if any of them is non-zero.
+func (e *vp8Encoder) putBlock(bw *vp8BoolWriter, plane int, ctx uint8, first int, levels *[16]int16) uint8 {
+	put := func(band, ctx, node int, bit bool) {
+		if bw == nil {
+			if bit {
+				e.counts[plane][band][ctx][node][1]++
+			} else {
+				e.counts[plane][band][ctx][node][0]++
+			}
+			return
+		}
+		bw.put(e.probs[plane][band][ctx][node], bit)
+	}
+	extra := func(prob uint8, bit bool) {
+		if bw != nil {
+			bw.put(prob, bit)
+		}
+	}
+
+	last := -1
+	for i := 15; i >= first; i-- {
+		if levels[i] != 0 {
+			last = i
+			break
+		}
+	}
+	c := int(ctx)
+	put(vp8CoeffBands[first], c, 0, last >= 0)
+	if last < 0 {
+		return 0
+	}
+	for i := first; i <= last; i++ {
+		band := vp8CoeffBands[i]
+		v := int32(levels[i])
+		if v == 0 {
+			put(band, c, 1, false)
+			c = 0
+			continue
+		}
+		put(band, c, 1, true)
+		a := v
+		if a < 0 {
+			a = -a
+		}
+		put(band, c, 2, a > 1)
+		switch {
+		case a == 1:
+		case a <= 4:
+			put(band, c, 3, false)
+			put(band, c, 4, a > 2)
+			if a > 2 {
+				put(band, c, 5, a == 4)
+			}
+		case a <= 10:
+			put(band, c, 3, true)
+			put(band, c, 6, false)
+			put(band, c, 7, a > 6)
+			if a <= 6 {
+				extra(159, a == 6)
+			} else {
+				extra(165, (a-7)&2 != 0)
+				extra(145, (a-7)&1 != 0)
+			}
+		default:
+			put(band, c, 3, true)
+			put(band, c, 6, true)
+			cat := 3
+			for cat > 0 && a < 3+8<<cat {
+				cat--
+			}
+			put(band, c, 8, cat >= 2)
+			put(band, c, 9+cat/2, cat%2 == 1)
+			rest := a - (3 + 8<<cat)
+			probs := vp8CatProbs[cat]
+			for k, prob := range probs {
+				extra(prob, rest>>(len(probs)-1-k)&1 != 0)
+			}
+		}
+		c = 2
+		if a == 1 {
+			c = 1
+		}
+		extra(128, v < 0)
+		if i == 15 {
+			return 1
+		}
+		put(vp8CoeffBands[i+1], c, 0, i != last)
+	}
+	return 1
+}
+
+// putProbUpdates writes the token probability updates of the frame
+// header, replacing a default probability where the counted branches
+// save more bits than the update costs.
+func (e *vp8Encoder) putProbUpdates(bw *vp8BoolWriter) {
+	for p := range e.probs {
+		for b := range e.probs[p] {
+			for c := range e.probs[p][b] {
+				for n := range e.probs[p][b][c] {
+					old := vp8DefaultCoeffProbs[p][b][c][n]
+					update := vp8CoeffUpdateProbs[p][b][c][n]
+					counts := e.counts[p][b][c][n]
+					prob := old
+					if total := counts[0] + counts[1]; total > 0 {
+						candidate := uint8(min(max((255*counts[0]+total/2)/total, 1), 255))
+						saving := vp8BranchCost(counts, old) - vp8BranchCost(counts, candidate) -
+							(8 + vp8BitCost(update, true) - vp8BitCost(update, false))
+						if saving > 0 {
+							prob = candidate
+						}
+					}
+					bw.put(update, prob != old)
+					if prob != old {
+						bw.putLiteral(uint32(prob), 8)
+					}
+					e.probs[p][b][c][n] = prob
+				}
+			}
+		}
+	}
+}
+
+// vp8BitCost returns the bits needed to code bit with probability prob
+// of a zero.
+func vp8BitCost(prob uint8, bit bool) float64 {
+	if bit {
+		return -math.Log2(float64(256-int(prob)) / 256)
+	}
+	return -math.Log2(float64(prob) / 256)
+}
+
+func vp8BranchCost(counts [2]uint32, prob uint8) float64 {
+	return float64(counts[0])*vp8BitCost(prob, false) + float64(counts[1])*vp8BitCost(prob, true)
+}
+
+// vp8BoolWriter is the boolean entropy encoder of RFC 6386 section 7.
+type vp8BoolWriter struct {
+	buf    []byte
+	rng    uint32
+	bottom uint32
+	count  int
+}
+
+func newVP8BoolWriter() *vp8BoolWriter {
+	return &vp8BoolWriter{rng: 255, count: 24}
+}
+
+// put writes bit, which is zero with probability prob/256.
+func (b *vp8BoolWriter) put(prob uint8, bit bool) {
+	split := 1 + (b.rng-1)*uint32(prob)>>8
+	if bit {
+		b.bottom += split
+		b.rng -= split
+	} else {
+		b.rng = split
+	}
+	for b.rng < 128 {
+		b.rng <<= 1
+		if b.bottom&(1<<31) != 0 {
+			b.carry()
+		}
+		b.bottom <<= 1
+		b.count--
+		if b.count == 0 {
+			b.buf = append(b.buf, byte(b.bottom>>24))
+			b.bottom &= 1<<24 - 1
+			b.count = 8
+		}
+	}
+}
+
+func (b *vp8BoolWriter) carry() {
+	for i := len(b.buf) - 1; i >= 0; i-- {
+		b.buf[i]++
+		if b.buf[i] != 0 {
+			return
+		}
+	}
+}
+
+// putLiteral writes the n low bits of v, most significant first.
+func (b *vp8BoolWriter) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.put(128, v>>uint(i)&1 != 0)
+	}
+}
+
+// flush pushes out the pending bits and returns the encoded bytes.
+func (b *vp8BoolWriter) flush() []byte {
+	for i := 0; i < 32; i++ {
+		b.put(128, false)
+	}
+	return b.buf
+}
@@ -0,0 +1,87 @@
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// embeddedFontFamily prefixes the font-family name of fonts embedded with
+// @font-face. The names are plain CSS identifiers so they need no quoting.
+const embeddedFontFamily = "xpost-embedded"
+
+// embedsFonts reports whether SVG/HTML output should carry its own fonts.
+// Only user-supplied fonts are embedded; with the built-in fonts the
+// font-family fallbacks are used as before.
+func embedsFonts(opts RenderOptions) bool {
+	return opts.FontPath != "" || opts.BoldFontPath != ""
+}
+
+// fontFaceCSS returns @font-face rules for the regular (400) and bold (700)
+// fonts, each subset to the glyphs the card uses and inlined as a data URI,
+// and the font-family name they declare. The name is derived from the
+// subsets: cards that share a document (collages, fragments on one page)
+// keep the glyphs they need instead of the last card's rules winning.
+func fontFaceCSS(data TweetData, layout Layout, fonts FontSet) (string, string, error) {
+	regularText := []string{layout.HandleLine, buildHandleLine(data), data.Text, layout.DateLine}
+	for _, action := range layout.Actions {
+		regularText = append(regularText, action.Label)
+	}
+	boldText := []string{layout.NameLine, data.Name, layout.CTA, initials(data.Name)}
+
+	var uris [2]string
+	for i, face := range []struct {
+		file   fontFile
+		weight int
+		text   string
+	}{
+		{fonts.regular, 400, strings.Join(regularText, "")},
+		{fonts.bold, 700, strings.Join(boldText, "")},
+	} {
+		uri, err := fontDataURI(face.file, face.text)
+		if err != nil {
+			return "", "", err
+		}
+		uris[i] = uri
+	}
+	sum := sha256.Sum256([]byte(uris[0] + "\n" + uris[1]))
+	family := embeddedFontFamily + "-" + hex.EncodeToString(sum[:6])
+	var css strings.Builder
+	for i, weight := range []int{400, 700} {
+		fmt.Fprintf(&css, "@font-face { font-family: %s; font-weight: %d; src: url(%s); }\n", family, weight, uris[i])
+	}
+	return css.String(), family, nil
+}
+
+// fontDataURI subsets a TrueType font to the glyphs of text. CFF-based fonts
+// cannot be subset here and are embedded whole.
+func fontDataURI(file fontFile, text string) (string, error) {
+	var buf sfnt.Buffer
+	keep := map[uint16]bool{}
+	for _, r := range text {
+		gid, err := file.Font.GlyphIndex(&buf, r)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up glyph: %w", err)
+		}
+		keep[uint16(gid)] = true
+	}
+	data, err := subsetTrueType(file.Data, keep)
+	mime := "font/ttf"
+	if errors.Is(err, errNotTrueType) {
+		data, mime = file.Data, "font/otf"
+	} else if err != nil {
+		return "", fmt.Errorf("failed to subset font: %w", err)
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// withEmbeddedFont puts the embedded font in front of the configured
+// font-family list.
+func withEmbeddedFont(embedded string, family string) string {
+	return embedded + ", " + family
+}
@@ -0,0 +1,621 @@
+package render
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	imagedraw "image/draw"
+	"io"
+)
+
+// WebPOptions controls the WebP encoder.
+type WebPOptions struct {
+	// NearLossless enables near-lossless preprocessing: pixels on edges
+	// (where anti-aliasing makes most colors unique) are quantized before
+	// the lossless encoding so they repeat more often. Flat areas stay
+	// exact. The output is still VP8L, not lossy VP8.
+	NearLossless bool
+	// Lossy writes a lossy VP8 bitstream instead of VP8L, with the alpha
+	// channel (if any) compressed losslessly in an ALPH chunk. It takes
+	// precedence over NearLossless.
+	Lossy bool
+	// Quality (1-100) controls how aggressively near-lossless mode
+	// quantizes, or the quantizer of lossy mode.
+	Quality int
+}
+
+const (
+	vp8lMaxSize       = 1 << 14
+	vp8lMaxCopyLength = 4096
+	vp8lMinCopyLength = 3
+	vp8lHashBits      = 16
+	vp8lChainDepth    = 16
+	vp8lNumLiterals   = 256
+	vp8lNumLengths    = 24
+	vp8lNumDistances  = 40
+	// vp8lMaxDistance is the farthest backward reference the 40 distance
+	// symbols can express: codes reach 1<<20 and the first 120 are plane
+	// codes.
+	vp8lMaxDistance = 1<<20 - 120
+	vp8lCodeLengths = 19
+)
+
+var vp8lCodeLengthOrder = [vp8lCodeLengths]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes img as a WebP file using the lossless VP8L bitstream,
+// or the lossy VP8 bitstream when opts.Lossy is set.
+func EncodeWebP(w io.Writer, img image.Image, opts WebPOptions) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return fmt.Errorf("webp: unsupported image size %dx%d", width, height)
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	imagedraw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, imagedraw.Src)
+
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for i := range argb {
+		p := nrgba.Pix[i*4 : i*4+4]
+		if p[3] != 0xff {
+			hasAlpha = true
+		}
+		argb[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+	}
+	if opts.Lossy {
+		return encodeLossyWebP(w, nrgba, argb, hasAlpha, opts.Quality)
+	}
+	if opts.NearLossless {
+		nearLossless(argb, width, height, opts.Quality)
+	}
+
+	var bw vp8lBitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+	writeVP8LStream(&bw, argb, width)
+	return writeWebPChunks(w, webpChunk{"VP8L", bw.bytes()})
+}
+
+// encodeLossyWebP writes a VP8 frame. Images with transparency get the
+// extended format: a VP8X header and the alpha plane as a VP8L stream in
+// an ALPH chunk, stored in the green channel as the format requires.
+func encodeLossyWebP(w io.Writer, img *image.NRGBA, argb []uint32, hasAlpha bool, quality int) error {
+	frame, err := encodeVP8(img, quality)
+	if err != nil {
+		return err
+	}
+	if !hasAlpha {
+		return writeWebPChunks(w, webpChunk{"VP8 ", frame})
+	}
+	alpha := make([]uint32, len(argb))
+	for i, p := range argb {
+		alpha[i] = p >> 24 << 8
+	}
+	var bw vp8lBitWriter
+	writeVP8LStream(&bw, alpha, img.Rect.Dx())
+	// ALPH header: no preprocessing, no filtering, VP8L compression.
+	alph := append([]byte{1}, bw.bytes()...)
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x10 // alpha
+	putUint24(vp8x[4:], img.Rect.Dx()-1)
+	putUint24(vp8x[7:], img.Rect.Dy()-1)
+	return writeWebPChunks(w, webpChunk{"VP8X", vp8x}, webpChunk{"ALPH", alph}, webpChunk{"VP8 ", frame})
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// webpChunk is a RIFF chunk of a WebP file.
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// writeWebPChunks writes the RIFF container with the given chunks, each
+// padded to an even length.
+func writeWebPChunks(w io.Writer, chunks ...webpChunk) error {
+	size := 4
+	for _, chunk := range chunks {
+		size += 8 + len(chunk.data) + len(chunk.data)&1
+	}
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	_ = binary.Write(&out, binary.LittleEndian, uint32(size))
+	out.WriteString("WEBP")
+	for _, chunk := range chunks {
+		out.WriteString(chunk.fourCC)
+		_ = binary.Write(&out, binary.LittleEndian, uint32(len(chunk.data)))
+		out.Write(chunk.data)
+		if len(chunk.data)&1 == 1 {
+			out.WriteByte(0)
+		}
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// writeVP8LStream writes the part of a VP8L bitstream that follows the
+// header: the transforms and the entropy-coded image. argb is modified in
+// place.
+func writeVP8LStream(bw *vp8lBitWriter, argb []uint32, width int) {
+	// Subtract-green transform: decorrelates red/blue from green so the
+	// literal alphabets of gray anti-aliasing collapse to a few symbols.
+	bw.write(1, 1)
+	bw.write(2, 2)
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+	bw.write(0, 1)
+
+	// No color cache, single prefix code group.
+	bw.write(0, 1)
+	bw.write(0, 1)
+	writeVP8LImage(bw, vp8lBackwardRefs(argb, width))
+}
+
+// nearLossless rounds the channels of pixels that differ from a neighbor,
+// dropping up to 4 low bits depending on quality.
+func nearLossless(argb []uint32, width int, height int, quality int) {
+	if quality <= 0 || quality > 100 {
+		quality = 75
+	}
+	bits := uint((100 - quality) / 20)
+	if bits == 0 {
+		return
+	}
+	src := make([]uint32, len(argb))
+	copy(src, argb)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			p := src[i]
+			if (x == 0 || src[i-1] == p) && (x == width-1 || src[i+1] == p) &&
+				(y == 0 || src[i-width] == p) && (y == height-1 || src[i+width] == p) {
+				continue
+			}
+			var out uint32
+			for shift := uint(0); shift < 32; shift += 8 {
+				out |= quantizeChannel((p>>shift)&0xff, bits) << shift
+			}
+			argb[i] = out
+		}
+	}
+}
+
+func quantizeChannel(v uint32, bits uint) uint32 {
+	half := uint32(1) << (bits - 1)
+	q := (v + half) >> bits << bits
+	if q > 0xff {
+		q = 0xff
+	}
+	return q
+}
+
+// vp8lToken is either a literal ARGB pixel or a backward reference.
+type vp8lToken struct {
+	literal  bool
+	argb     uint32
+	length   int
+	distCode int
+}
+
+func vp8lBackwardRefs(argb []uint32, width int) []vp8lToken {
+	n := len(argb)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		h := argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1 ^ argb[i+2]*0x85ebca6b
+		return h >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+2 >= n {
+			return
+		}
+		h := hash(i)
+		prev[i] = head[h]
+		head[h] = int32(i)
+	}
+	matchLength := func(i int, dist int) int {
+		limit := n - i
+		if limit > vp8lMaxCopyLength {
+			limit = vp8lMaxCopyLength
+		}
+		length := 0
+		for length < limit && argb[i+length] == argb[i+length-dist] {
+			length++
+		}
+		return length
+	}
+
+	tokens := make([]vp8lToken, 0, n/4)
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		for _, dist := range [2]int{1, width} {
+			if dist <= i && dist <= vp8lMaxDistance {
+				if l := matchLength(i, dist); l > bestLen {
+					bestLen, bestDist = l, dist
+				}
+			}
+		}
+		if i+2 < n && bestLen < vp8lMaxCopyLength {
+			candidate := head[hash(i)]
+			for depth := 0; candidate >= 0 && depth < vp8lChainDepth; depth++ {
+				dist := i - int(candidate)
+				if dist > vp8lMaxDistance {
+					// The chain runs backwards, so the rest is farther.
+					break
+				}
+				if dist > 0 {
+					if l := matchLength(i, dist); l > bestLen {
+						bestLen, bestDist = l, dist
+					}
+				}
+				candidate = prev[candidate]
+			}
+		}
+
+		if bestLen >= vp8lMinCopyLength {
+			tokens = append(tokens, vp8lToken{length: bestLen, distCode: vp8lDistanceCode(bestDist, width)})
+			for j := 0; j < bestLen; j++ {
+				insert(i + j)
+			}
+			i += bestLen
+			continue
+		}
+		tokens = append(tokens, vp8lToken{literal: true, argb: argb[i]})
+		insert(i)
+		i++
+	}
+	return tokens
+}
+
+// vp8lDistanceCode maps a pixel distance to a distance code. The two most
+// common neighbors use the short plane codes; everything else uses the
+// direct mapping (code = distance + 120), so dist must not exceed
+// vp8lMaxDistance.
+func vp8lDistanceCode(dist int, width int) int {
+	switch dist {
+	case width:
+		return 1
+	case 1:
+		return 2
+	default:
+		return dist + 120
+	}
+}
+
+// vp8lPrefix splits a length or distance code into its prefix symbol and
+// extra bits.
+func vp8lPrefix(value int) (symbol int, extraBits uint, extra uint32) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := 31
+	for v>>uint(high) == 0 {
+		high--
+	}
+	second := (v >> uint(high-1)) & 1
+	extraBits = uint(high - 1)
+	return 2*high + second, extraBits, uint32(v) & (1<<extraBits - 1)
+}
+
+func writeVP8LImage(bw *vp8lBitWriter, tokens []vp8lToken) {
+	green := make([]int, vp8lNumLiterals+vp8lNumLengths)
+	red := make([]int, vp8lNumLiterals)
+	blue := make([]int, vp8lNumLiterals)
+	alpha := make([]int, vp8lNumLiterals)
+	dist := make([]int, vp8lNumDistances)
+	for _, t := range tokens {
+		if t.literal {
+			green[(t.argb>>8)&0xff]++
+			red[(t.argb>>16)&0xff]++
+			blue[t.argb&0xff]++
+			alpha[t.argb>>24]++
+			continue
+		}
+		lengthSymbol, _, _ := vp8lPrefix(t.length)
+		distSymbol, _, _ := vp8lPrefix(t.distCode)
+		green[vp8lNumLiterals+lengthSymbol]++
+		dist[distSymbol]++
+	}
+
+	codes := [5]vp8lCode{}
+	for i, histogram := range [][]int{green, red, blue, alpha, dist} {
+		codes[i] = writeVP8LCode(bw, histogram)
+	}
+
+	for _, t := range tokens {
+		if t.literal {
+			codes[0].write(bw, int((t.argb>>8)&0xff))
+			codes[1].write(bw, int((t.argb>>16)&0xff))
+			codes[2].write(bw, int(t.argb&0xff))
+			codes[3].write(bw, int(t.argb>>24))
+			continue
+		}
+		lengthSymbol, lengthBits, lengthExtra := vp8lPrefix(t.length)
+		codes[0].write(bw, vp8lNumLiterals+lengthSymbol)
+		bw.write(lengthExtra, lengthBits)
+		distSymbol, distBits, distExtra := vp8lPrefix(t.distCode)
+		codes[4].write(bw, distSymbol)
+		bw.write(distExtra, distBits)
+	}
+}
+
+// vp8lCode is a canonical prefix code. A code with a single symbol uses
+// zero bits per symbol, as the decoder expects.
+type vp8lCode struct {
+	lengths []int
+	codes   []uint32
+	single  bool
+}
+
+func (c vp8lCode) write(bw *vp8lBitWriter, symbol int) {
+	if c.single {
+		return
+	}
+	bw.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+func writeVP8LCode(bw *vp8lBitWriter, histogram []int) vp8lCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	// Simple code: one or two symbols below 256.
+	if len(used) <= 2 && used[len(used)-1] < vp8lNumLiterals {
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		lengths := make([]int, len(histogram))
+		if len(used) == 1 {
+			return vp8lCode{lengths: lengths, single: true}
+		}
+		bw.write(uint32(used[1]), 8)
+		lengths[used[0]], lengths[used[1]] = 1, 1
+		return vp8lCode{lengths: lengths, codes: canonicalCodes(lengths)}
+	}
+
+	lengths := huffmanLengths(histogram, 15)
+	bw.write(0, 1)
+	writeVP8LCodeLengths(bw, lengths)
+	return vp8lCode{lengths: lengths, codes: canonicalCodes(lengths), single: len(used) == 1}
+}
+
+// writeVP8LCodeLengths writes the code lengths of a normal prefix code
+// using the code length code, run-length encoding zeros with 17 and 18.
+func writeVP8LCodeLengths(bw *vp8lBitWriter, lengths []int) {
+	type clToken struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+	var tokens []clToken
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, clToken{symbol: lengths[i]})
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(lengths) && lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		for run > 0 {
+			switch {
+			case run >= 11:
+				n := min(run, 138)
+				tokens = append(tokens, clToken{symbol: 18, extra: uint32(n - 11), extraBits: 7})
+				run -= n
+			case run >= 3:
+				tokens = append(tokens, clToken{symbol: 17, extra: uint32(run - 3), extraBits: 3})
+				run = 0
+			default:
+				tokens = append(tokens, clToken{symbol: 0})
+				run--
+			}
+		}
+	}
+
+	histogram := make([]int, vp8lCodeLengths)
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	clLengths := huffmanLengths(histogram, 7)
+	clCode := vp8lCode{lengths: clLengths, codes: canonicalCodes(clLengths)}
+	usedSymbols := 0
+	for _, l := range clLengths {
+		if l > 0 {
+			usedSymbols++
+		}
+	}
+	clCode.single = usedSymbols == 1
+
+	count := vp8lCodeLengths
+	for count > 4 && clLengths[vp8lCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+	bw.write(uint32(count-4), 4)
+	for i := 0; i < count; i++ {
+		bw.write(uint32(clLengths[vp8lCodeLengthOrder[i]]), 3)
+	}
+	bw.write(0, 1) // max_symbol is the alphabet size
+	for _, t := range tokens {
+		clCode.write(bw, t.symbol)
+		bw.write(t.extra, t.extraBits)
+	}
+}
+
+// huffmanLengths builds code lengths no longer than limit. When the tree
+// gets too deep, the counts are flattened and the tree rebuilt.
+func huffmanLengths(histogram []int, limit int) []int {
+	counts := make([]int, len(histogram))
+	copy(counts, histogram)
+	for {
+		lengths := buildHuffmanLengths(counts)
+		longest := 0
+		for _, l := range lengths {
+			longest = max(longest, l)
+		}
+		if longest <= limit {
+			return lengths
+		}
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+type huffmanNode struct {
+	count  int
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+func buildHuffmanLengths(counts []int) []int {
+	lengths := make([]int, len(counts))
+	nodes := &huffmanHeap{}
+	for symbol, count := range counts {
+		if count > 0 {
+			*nodes = append(*nodes, &huffmanNode{count: count, symbol: symbol})
+		}
+	}
+	switch nodes.Len() {
+	case 0:
+		return lengths
+	case 1:
+		lengths[(*nodes)[0].symbol] = 1
+		return lengths
+	}
+	heap.Init(nodes)
+	next := len(counts)
+	for nodes.Len() > 1 {
+		a := heap.Pop(nodes).(*huffmanNode)
+		b := heap.Pop(nodes).(*huffmanNode)
+		heap.Push(nodes, &huffmanNode{count: a.count + b.count, symbol: next, left: a, right: b})
+		next++
+	}
+	var walk func(n *huffmanNode, depth int)
+	walk = func(n *huffmanNode, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(heap.Pop(nodes).(*huffmanNode), 0)
+	return lengths
+}
+
+// canonicalCodes assigns canonical codes and bit-reverses them, since the
+// bit writer emits the least significant bit first.
+func canonicalCodes(lengths []int) []uint32 {
+	var blCount [16]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			blCount[l]++
+		}
+	}
+	var nextCode [16]uint32
+	code := uint32(0)
+	for bits := 1; bits < 16; bits++ {
+		code = (code + blCount[bits-1]) << 1
+		nextCode[bits] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := nextCode[l]
+		nextCode[l]++
+		var reversed uint32
+		for i := 0; i < l; i++ {
+			reversed = reversed<<1 | (c>>uint(i))&1
+		}
+		codes[symbol] = reversed
+	}
+	return codes
+}
+
+// vp8lBitWriter packs bits least significant bit first.
+type vp8lBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (b *vp8lBitWriter) write(value uint32, n uint) {
+	if n == 0 {
+		return
+	}
+	b.acc |= uint64(value&(1<<n-1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+func (b *vp8lBitWriter) bytes() []byte {
+	if b.nbits > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc = 0
+		b.nbits = 0
+	}
+	return b.buf
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package termimg
+
+import "os"
+
+// TerminalSize is not available on this platform and returns a zero Size.
+func TerminalSize(f *os.File) Size {
+	return Size{}
+}
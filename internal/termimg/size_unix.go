@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package termimg
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// TerminalSize asks the terminal behind f for its size. It returns a zero
+// Size when f is not a terminal.
+func TerminalSize(f *os.File) Size {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return Size{}
+	}
+	return Size{Columns: int(ws.Col), Width: int(ws.Xpixel)}
+}
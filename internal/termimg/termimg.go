@@ -0,0 +1,236 @@
+// Package termimg draws images in a terminal with the kitty graphics
+// protocol, Sixel, or 24-bit colored Unicode half blocks.
+package termimg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+
+	"github.com/ackkerman/x-post-preview-generator/internal/render"
+)
+
+// Protocol is a way of drawing images in a terminal.
+type Protocol string
+
+const (
+	Kitty  Protocol = "kitty"
+	Sixel  Protocol = "sixel"
+	Blocks Protocol = "blocks"
+)
+
+// defaultCellWidth is the assumed width of a terminal cell in pixels when
+// the terminal does not report its pixel size.
+const defaultCellWidth = 8
+
+// ParseProtocol maps a -preview-mode value to a protocol. "auto" and ""
+// return an empty protocol, meaning Detect should decide.
+func ParseProtocol(value string) (Protocol, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "auto":
+		return "", nil
+	case "kitty":
+		return Kitty, nil
+	case "sixel":
+		return Sixel, nil
+	case "blocks":
+		return Blocks, nil
+	default:
+		return "", fmt.Errorf("unknown preview mode: %s (use auto, kitty, sixel or blocks)", value)
+	}
+}
+
+// Detect guesses the best protocol from the environment. Terminals that
+// support neither graphics protocol get half blocks.
+func Detect(getenv func(string) string) Protocol {
+	term := getenv("TERM")
+	program := getenv("TERM_PROGRAM")
+	switch {
+	case term == "xterm-kitty", getenv("KITTY_WINDOW_ID") != "", program == "WezTerm", program == "ghostty":
+		return Kitty
+	case strings.Contains(term, "sixel"), term == "foot", strings.HasPrefix(term, "mlterm"), strings.HasPrefix(term, "yaft"), program == "mintty":
+		return Sixel
+	default:
+		return Blocks
+	}
+}
+
+// Size is the terminal size in cells and, when known, in pixels.
+type Size struct {
+	Columns int
+	Width   int
+}
+
+// pixelWidth returns how many pixels wide the image may be.
+func (s Size) pixelWidth() int {
+	if s.Width > 0 {
+		return s.Width
+	}
+	return s.Columns * defaultCellWidth
+}
+
+// Write draws img with the given protocol, scaled down to fit the terminal
+// width. Images are never scaled up.
+func Write(w io.Writer, img image.Image, protocol Protocol, size Size) error {
+	if size.Columns <= 0 {
+		size.Columns = 80
+	}
+	switch protocol {
+	case Kitty:
+		return writeKitty(w, img, size)
+	case Sixel:
+		return writeSixel(w, fit(img, size.pixelWidth()))
+	default:
+		return writeBlocks(w, fit(img, size.Columns))
+	}
+}
+
+// fit scales img down to at most width pixels wide, keeping its aspect ratio.
+func fit(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width || width <= 0 {
+		return img
+	}
+	height := max(1, int(math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, b, xdraw.Src, nil)
+	return scaled
+}
+
+// writeKitty sends the image as PNG in base64 chunks of at most 4096 bytes.
+// The terminal scales it, so only the column count is given, and only when
+// the image is wider than the terminal.
+func writeKitty(w io.Writer, img image.Image, size Size) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+	control := "a=T,f=100"
+	if img.Bounds().Dx() > size.pixelWidth() {
+		control += fmt.Sprintf(",c=%d", size.Columns)
+	}
+	for first := true; first || payload != ""; first = false {
+		chunk := payload[:min(4096, len(payload))]
+		payload = payload[len(chunk):]
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(w, "\x1b_G%s,m=%d;%s\x1b\\", control, more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeSixel encodes the image in bands of six pixel rows, one pass per
+// palette color with run-length encoding.
+func writeSixel(w io.Writer, img image.Image) error {
+	paletted := render.Quantize(img, 256)
+	b := paletted.Bounds()
+	var out bytes.Buffer
+	// P2=1 leaves pixels of unused colors (transparent) untouched.
+	fmt.Fprintf(&out, "\x1bP0;1;0q\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i, c := range paletted.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	row := make([]byte, b.Dx())
+	for top := b.Min.Y; top < b.Max.Y; top += 6 {
+		used := map[uint8]bool{}
+		for y := top; y < min(top+6, b.Max.Y); y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				used[paletted.ColorIndexAt(x, y)] = true
+			}
+		}
+		first := true
+		for index := 0; index < len(paletted.Palette); index++ {
+			if !used[uint8(index)] || isTransparent(paletted.Palette[index]) {
+				continue
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && top+dy < b.Max.Y; dy++ {
+					if paletted.ColorIndexAt(x, top+dy) == uint8(index) {
+						bits |= 1 << dy
+					}
+				}
+				row[x-b.Min.X] = 63 + bits
+			}
+			if !first {
+				out.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&out, "#%d", index)
+			writeSixelRuns(&out, row)
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// writeSixelRuns writes a row of sixel characters, compressing repeats.
+func writeSixelRuns(out *bytes.Buffer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(out, "!%d%c", n, row[i])
+		} else {
+			out.Write(row[i:j])
+		}
+		i = j
+	}
+}
+
+func isTransparent(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a == 0
+}
+
+// writeBlocks draws two pixel rows per line with the upper half block: the
+// foreground colors the top pixel and the background the bottom one.
+// Transparent pixels keep the terminal's default colors.
+func writeBlocks(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	var out bytes.Buffer
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			bottom := color.NRGBA{}
+			if y+1 < b.Max.Y {
+				bottom = color.NRGBAModel.Convert(img.At(x, y+1)).(color.NRGBA)
+			}
+			switch {
+			case top.A < 128 && bottom.A < 128:
+				out.WriteString("\x1b[0m ")
+			case top.A < 128:
+				fmt.Fprintf(&out, "\x1b[0;38;2;%d;%d;%dm▄", bottom.R, bottom.G, bottom.B)
+			case bottom.A < 128:
+				fmt.Fprintf(&out, "\x1b[0;38;2;%d;%d;%dm▀", top.R, top.G, top.B)
+			default:
+				fmt.Fprintf(&out, "\x1b[38;2;%d;%d;%d;48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+			}
+		}
+		out.WriteString("\x1b[0m\n")
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,182 @@
+package termimg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			if x < width/2 {
+				c = color.RGBA{R: 29, G: 161, B: 242, A: 255}
+			}
+			if y%7 == 0 {
+				c = color.RGBA{A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want Protocol
+	}{
+		{map[string]string{"TERM": "xterm-kitty"}, Kitty},
+		{map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "WezTerm"}, Kitty},
+		{map[string]string{"TERM": "foot"}, Sixel},
+		{map[string]string{"TERM": "xterm-256color"}, Blocks},
+	}
+	for _, tc := range cases {
+		getenv := func(key string) string { return tc.env[key] }
+		if got := Detect(getenv); got != tc.want {
+			t.Fatalf("Detect(%v) = %s, want %s", tc.env, got, tc.want)
+		}
+	}
+	if _, err := ParseProtocol("iterm"); err == nil {
+		t.Fatalf("expected error for unknown preview mode")
+	}
+}
+
+func TestWriteBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testImage(40, 20), Blocks, Size{Columns: 20}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// 40x20 scaled to 20 columns is 20x10 pixels, two pixel rows per line.
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d", len(lines))
+	}
+	if n := strings.Count(lines[0], "▀") + strings.Count(lines[0], "▄"); n != 20 {
+		t.Fatalf("expected 20 cells per line, got %d", n)
+	}
+	if !strings.Contains(buf.String(), "38;2;") || !strings.Contains(buf.String(), "48;2;") {
+		t.Fatalf("expected 24-bit colors")
+	}
+}
+
+func TestWriteKitty(t *testing.T) {
+	// Noise keeps the PNG large enough to need several chunks.
+	img := image.NewRGBA(image.Rect(0, 0, 120, 80))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	if err := Write(&buf, img, Kitty, Size{Columns: 10}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	chunks := regexp.MustCompile("\x1b_G([^;]*);([^\x1b]*)\x1b\\\\").FindAllStringSubmatch(buf.String(), -1)
+	if len(chunks) < 2 {
+		t.Fatalf("expected a chunked transfer, got %d chunks", len(chunks))
+	}
+	if !strings.Contains(chunks[0][1], "f=100") || !strings.Contains(chunks[0][1], "c=10") {
+		t.Fatalf("unexpected first chunk control data %q", chunks[0][1])
+	}
+	for i, chunk := range chunks {
+		if len(chunk[2]) > 4096 {
+			t.Fatalf("chunk %d is too long: %d", i, len(chunk[2]))
+		}
+		last := i == len(chunks)-1
+		if strings.Contains(chunk[1], "m=1") == last {
+			t.Fatalf("chunk %d has the wrong more flag: %q", i, chunk[1])
+		}
+	}
+}
+
+func TestWriteSixel(t *testing.T) {
+	img := testImage(30, 14)
+	var buf bytes.Buffer
+	if err := Write(&buf, img, Sixel, Size{Columns: 80}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "\x1bP0;1;0q\"1;1;30;14") || !strings.HasSuffix(out, "\x1b\\\n") {
+		t.Fatalf("unexpected sixel framing")
+	}
+
+	decoded := decodeSixel(t, out[strings.Index(out, "#"):strings.LastIndex(out, "\x1b")], 30, 14)
+	for y := 0; y < 14; y++ {
+		for x := 0; x < 30; x++ {
+			want := img.RGBAAt(x, y)
+			got := decoded[y*30+x]
+			if absDiff(got.R, want.R) > 3 || absDiff(got.G, want.G) > 3 || absDiff(got.B, want.B) > 3 {
+				t.Fatalf("pixel %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// decodeSixel is a minimal decoder for the subset of Sixel that writeSixel
+// produces.
+func decodeSixel(t *testing.T, data string, width, height int) []color.RGBA {
+	t.Helper()
+	pixels := make([]color.RGBA, width*height)
+	palette := map[int]color.RGBA{}
+	current, x, top := 0, 0, 0
+	number := func(i int) (int, int) {
+		j := i
+		for j < len(data) && data[j] >= '0' && data[j] <= '9' {
+			j++
+		}
+		n, _ := strconv.Atoi(data[i:j])
+		return n, j
+	}
+	put := func(c byte, count int) {
+		for ; count > 0; count-- {
+			bits := c - 63
+			for dy := 0; dy < 6; dy++ {
+				if bits&(1<<dy) != 0 && top+dy < height && x < width {
+					pixels[(top+dy)*width+x] = palette[current]
+				}
+			}
+			x++
+		}
+	}
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == '#':
+			n, j := number(i + 1)
+			current, i = n, j
+			if i < len(data) && data[i] == ';' {
+				var v [4]int
+				for k := range v {
+					v[k], i = number(i + 1)
+				}
+				palette[current] = color.RGBA{R: uint8(v[1] * 255 / 100), G: uint8(v[2] * 255 / 100), B: uint8(v[3] * 255 / 100), A: 255}
+			}
+		case c == '!':
+			n, j := number(i + 1)
+			put(data[j], n)
+			i = j + 1
+		case c == '$':
+			x = 0
+			i++
+		case c == '-':
+			x, top = 0, top+6
+			i++
+		case c >= 63 && c <= 126:
+			put(c, 1)
+			i++
+		default:
+			t.Fatalf("unexpected sixel byte %q", c)
+		}
+	}
+	return pixels
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}